@@ -0,0 +1,167 @@
+package bitcoin
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/shopspring/decimal"
+	"github.com/volatiletech/null/v9"
+
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/transaction"
+)
+
+// CreateBatchTransaction pay every withdrawal of txs in a single transaction, they share its hash and fee.
+// subtract_fee in options make every recipient pay a share of the fee, subtract_fee in the options
+// of a withdrawal select only some of them, their amounts are updated with what is received and
+// their fee with the share they paid
+func (w *Wallet) CreateBatchTransaction(ctx context.Context, txs []*transaction.Transaction, options map[string]interface{}) ([]*transaction.Transaction, error) {
+	if len(txs) == 0 {
+		return txs, nil
+	}
+
+	var subtractFee bool
+	if options["subtract_fee"] != nil {
+		subtractFee = options["subtract_fee"].(bool)
+	}
+
+	outputs := make([]*output, len(txs))
+	seen := make(map[string]bool, len(txs))
+	for i, tx := range txs {
+		if seen[tx.ToAddress] {
+			return nil, fmt.Errorf("address %s is paid twice in the batch", tx.ToAddress)
+		}
+		seen[tx.ToAddress] = true
+
+		selected, _ := tx.Options["subtract_fee"].(bool)
+		outputs[i] = &output{
+			Address:     tx.ToAddress,
+			Amount:      tx.Amount.Shift(8).IntPart(),
+			SubtractFee: subtractFee || selected,
+		}
+	}
+
+	var funded *fundedTransaction
+	var positions []int64
+	var err error
+	described := true
+	if selector := w.coinSelector(); selector != nil {
+		funded, err = w.sendOutputs(ctx, outputs, selector, options)
+		if err != nil {
+			return nil, err
+		}
+
+		// the outputs are created in order before the change
+		positions = make([]int64, len(outputs))
+		for i := range positions {
+			positions[i] = int64(i)
+		}
+	} else {
+		txid, err := w.sendMany(ctx, outputs, options)
+		if err != nil {
+			return nil, err
+		}
+
+		// the transaction is already sent, the withdrawals are returned without their fee
+		// and position when the node can't describe it so they are never sent twice
+		funded, positions, err = w.describeSent(ctx, txid, outputs)
+		if err != nil {
+			funded = &fundedTransaction{TxID: txid, Inputs: []*UTXO{}, Amounts: make([]int64, len(outputs))}
+			for i, o := range outputs {
+				funded.Amounts[i] = o.Amount
+			}
+			described = false
+		}
+	}
+
+	// the fee is attributed to the withdrawals paying it, the wallet pays it for the whole batch otherwise
+	fees := feeShares(outputs, funded.Fee)
+	if !anySubtractFee(outputs) {
+		for i := range fees {
+			fees[i] = funded.Fee / int64(len(txs))
+		}
+		fees[0] += funded.Fee - fees[0]*int64(len(txs))
+	}
+
+	for i, tx := range txs {
+		tx.Amount = decimal.New(funded.Amounts[i], -8)
+		if described {
+			tx.Fee = decimal.NewNullDecimal(decimal.New(fees[i], -8))
+			tx.Position = null.Int64From(positions[i])
+		}
+		tx.Inputs = formatInputs(funded.Inputs)
+		tx.Status = transaction.StatusPending
+		tx.TxHash = null.StringFrom(funded.TxID)
+	}
+
+	return txs, nil
+}
+
+// anySubtractFee return whether one of outputs pays the fee
+func anySubtractFee(outputs []*output) bool {
+	for _, o := range outputs {
+		if o.SubtractFee {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sendMany pay outputs with the wallet of the node and return the transaction hash
+func (w *Wallet) sendMany(ctx context.Context, outputs []*output, options map[string]interface{}) (string, error) {
+	feeRate, err := w.feeRate(ctx, options)
+	if err != nil {
+		return "", err
+	}
+
+	amounts := make(map[string]decimal.Decimal, len(outputs))
+	subtractFeeFrom := make([]string, 0)
+	for _, o := range outputs {
+		amounts[o.Address] = decimal.New(o.Amount, -8)
+		if o.SubtractFee {
+			subtractFeeFrom = append(subtractFeeFrom, o.Address)
+		}
+	}
+
+	var txid string
+	if err := w.client.CallOnce(ctx, &txid, "sendmany", "", amounts, 1, "", subtractFeeFrom, w.replaceable(options), nil, "unset", formatFeeRate(feeRate)); err != nil {
+		return "", err
+	}
+
+	return txid, nil
+}
+
+// describeSent return the fee of a transaction sent by the wallet of the node, what each output received and its index
+func (w *Wallet) describeSent(ctx context.Context, txid string, outputs []*output) (*fundedTransaction, []int64, error) {
+	var resp *WalletTransaction
+	if err := w.client.Call(ctx, &resp, "gettransaction", txid); err != nil {
+		return nil, nil, err
+	}
+
+	funded := &fundedTransaction{
+		TxID:    txid,
+		Inputs:  []*UTXO{},
+		Fee:     resp.Fee.Abs().Shift(8).IntPart(),
+		Amounts: make([]int64, len(outputs)),
+	}
+	positions := make([]int64, len(outputs))
+
+	for i, o := range outputs {
+		found := false
+		for _, detail := range resp.Details {
+			if detail.Category == "send" && detail.Address == o.Address {
+				funded.Amounts[i] = detail.Amount.Abs().Shift(8).IntPart()
+				positions[i] = detail.Vout
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return nil, nil, errors.New("output of " + o.Address + " not found in transaction " + txid)
+		}
+	}
+
+	return funded, positions, nil
+}
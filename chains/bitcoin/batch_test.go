@@ -0,0 +1,172 @@
+package bitcoin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/multichain/internal/rpctest"
+	"github.com/zsmartex/multichain/pkg/transaction"
+)
+
+func newWithdrawals() []*transaction.Transaction {
+	return []*transaction.Transaction{
+		{ToAddress: "bcrt1qalice", Amount: decimal.NewFromFloat(0.001), Options: map[string]interface{}{"subtract_fee": true}},
+		{ToAddress: "bcrt1qbob", Amount: decimal.NewFromFloat(0.002)},
+		{ToAddress: "bcrt1qcarol", Amount: decimal.NewFromFloat(0.003), Options: map[string]interface{}{"subtract_fee": true}},
+	}
+}
+
+func TestWallet_CreateBatchTransactionSendMany(t *testing.T) {
+	var subtractFeeFrom []string
+	var feeRate decimal.Decimal
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"estimatesmartfee": map[string]interface{}{"feerate": 0.00012, "blocks": 6},
+		"sendmany": func(params []json.RawMessage) interface{} {
+			json.Unmarshal(params[4], &subtractFeeFrom)
+			json.Unmarshal(params[8], &feeRate)
+
+			return "txid"
+		},
+		"gettransaction": map[string]interface{}{
+			"fee": -0.00000300,
+			"details": []map[string]interface{}{
+				{"address": "bcrt1qcarol", "category": "send", "amount": -0.0029985, "vout": 0},
+				{"address": "bcrt1qalice", "category": "send", "amount": -0.0009985, "vout": 2},
+				{"address": "bcrt1qbob", "category": "send", "amount": -0.002, "vout": 3},
+			},
+		},
+	})
+	defer server.Close()
+
+	w := newTestWallet(t, server.URL, nil).(*Wallet)
+
+	txs, err := w.CreateBatchTransaction(context.Background(), newWithdrawals(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(subtractFeeFrom) != 2 || subtractFeeFrom[0] != "bcrt1qalice" || subtractFeeFrom[1] != "bcrt1qcarol" {
+		t.Errorf("unexpected subtractfeefrom %v", subtractFeeFrom)
+	}
+
+	if !feeRate.Equal(decimal.NewFromInt(12)) {
+		t.Errorf("unexpected fee rate %s", feeRate)
+	}
+
+	// bob don't subtract the fee so it's only attributed to alice and carol
+	expected := []struct {
+		amount   string
+		fee      string
+		position int64
+	}{{"0.0009985", "0.0000015", 2}, {"0.002", "0", 3}, {"0.0029985", "0.0000015", 0}}
+
+	for i, tx := range txs {
+		if tx.TxHash.String != "txid" || tx.Amount.String() != expected[i].amount || tx.Position.Int64 != expected[i].position {
+			t.Errorf("unexpected withdrawal %d: %s at %d", i, tx.Amount, tx.Position.Int64)
+		}
+
+		if tx.Fee.Decimal.String() != expected[i].fee {
+			t.Errorf("unexpected fee share %s", tx.Fee.Decimal)
+		}
+	}
+}
+
+func TestWallet_CreateBatchTransactionUTXO(t *testing.T) {
+	var outputs []map[string]decimal.Decimal
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"listunspent": []map[string]interface{}{
+			{"txid": "aa", "vout": 0, "amount": 0.01, "spendable": true, "safe": true},
+		},
+		"createrawtransaction": func(params []json.RawMessage) interface{} {
+			json.Unmarshal(params[1], &outputs)
+
+			return "raw"
+		},
+		"signrawtransactionwithwallet": map[string]interface{}{"hex": "signed", "complete": true},
+		"sendrawtransaction":           "txid",
+		"lockunspent":                  true,
+	})
+	defer server.Close()
+
+	w := newTestWallet(t, server.URL, map[string]interface{}{"coin_selection": CoinSelectionLargestFirst, "fee_rate": 1}).(*Wallet)
+
+	txs, err := w.CreateBatchTransaction(context.Background(), newWithdrawals(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one input, three recipients and the change pay 11 + 68 + 4 * 31 = 203 satoshis split between alice and carol
+	if len(outputs) != 4 || !txs[0].Amount.Equal(decimal.New(99_898, -8)) || !txs[1].Amount.Equal(decimal.New(200_000, -8)) || !txs[2].Amount.Equal(decimal.New(299_899, -8)) {
+		t.Errorf("unexpected amounts %s %s %s", txs[0].Amount, txs[1].Amount, txs[2].Amount)
+	}
+
+	if !outputs[3]["bcrt1qqqd8hdc684cqpm5ydfd535eygxlmh54wysmzry"].Equal(decimal.New(400_000, -8)) {
+		t.Errorf("unexpected change %v", outputs[3])
+	}
+
+	fees := []int64{102, 0, 101}
+	for i, tx := range txs {
+		if tx.Position.Int64 != int64(i) || len(tx.Inputs) != 1 {
+			t.Errorf("unexpected withdrawal %+v", tx)
+		}
+
+		if !tx.Fee.Decimal.Equal(decimal.New(fees[i], -8)) {
+			t.Errorf("unexpected fee of withdrawal %d: %s", i, tx.Fee.Decimal)
+		}
+	}
+}
+
+func TestWallet_CreateBatchTransactionWalletPaysFee(t *testing.T) {
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"estimatesmartfee": map[string]interface{}{"feerate": 0.00012, "blocks": 6},
+		"sendmany":         "txid",
+		"gettransaction": map[string]interface{}{
+			"fee": -0.00000301,
+			"details": []map[string]interface{}{
+				{"address": "bcrt1qalice", "category": "send", "amount": -0.001, "vout": 0},
+				{"address": "bcrt1qbob", "category": "send", "amount": -0.002, "vout": 1},
+			},
+		},
+	})
+	defer server.Close()
+
+	w := newTestWallet(t, server.URL, nil).(*Wallet)
+
+	txs, err := w.CreateBatchTransaction(context.Background(), []*transaction.Transaction{
+		{ToAddress: "bcrt1qalice", Amount: decimal.NewFromFloat(0.001)},
+		{ToAddress: "bcrt1qbob", Amount: decimal.NewFromFloat(0.002)},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// without subtract_fee the fee is split between the withdrawals of the batch
+	if txs[0].Fee.Decimal.String() != "0.00000151" || txs[1].Fee.Decimal.String() != "0.0000015" {
+		t.Errorf("unexpected fees %s %s", txs[0].Fee.Decimal, txs[1].Fee.Decimal)
+	}
+}
+
+func TestWallet_CreateBatchTransactionUndescribed(t *testing.T) {
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"estimatesmartfee": map[string]interface{}{"feerate": 0.00012, "blocks": 6},
+		"sendmany":         "txid",
+		"gettransaction":   &rpctest.Error{Code: -28, Message: "Loading wallet..."},
+	})
+	defer server.Close()
+
+	w := newTestWallet(t, server.URL, nil).(*Wallet)
+
+	txs, err := w.CreateBatchTransaction(context.Background(), newWithdrawals(), nil)
+	if err != nil {
+		t.Fatalf("expected the sent batch to be returned, got %v", err)
+	}
+
+	for _, tx := range txs {
+		if tx.TxHash.String != "txid" || tx.Fee.Valid || tx.Position.Valid || tx.Status != transaction.StatusPending {
+			t.Errorf("unexpected withdrawal %+v", tx)
+		}
+	}
+}
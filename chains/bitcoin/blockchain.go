@@ -2,18 +2,17 @@ package bitcoin
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
-	"math/rand"
 	"strings"
+	"time"
 
-	"github.com/go-resty/resty/v2"
 	"github.com/shopspring/decimal"
 	"github.com/volatiletech/null/v9"
 
 	"github.com/zsmartex/multichain/pkg/block"
 	"github.com/zsmartex/multichain/pkg/blockchain"
 	"github.com/zsmartex/multichain/pkg/currency"
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/rpc"
 	"github.com/zsmartex/multichain/pkg/transaction"
 )
 
@@ -31,90 +30,94 @@ type Vin struct {
 }
 
 type TxHash struct {
-	TxID string  `json:"txid"`
-	Vin  []*Vin  `json:"vin"`
-	VOut []*VOut `json:"vout"`
+	TxID          string  `json:"txid"`
+	Confirmations int64   `json:"confirmations"`
+	Vin           []*Vin  `json:"vin"`
+	VOut          []*VOut `json:"vout"`
+}
+
+// WalletTransaction is a transaction of the wallet of the node returned by gettransaction
+type WalletTransaction struct {
+	TxID            string          `json:"txid"`
+	BlockHash       string          `json:"blockhash"`
+	Confirmations   int64           `json:"confirmations"`
+	Fee             decimal.Decimal `json:"fee"`
+	Hex             string          `json:"hex"`
+	WalletConflicts []string        `json:"walletconflicts"`
+	ReplacesTxID    string          `json:"replaces_txid"`
+	ReplacedByTxID  string          `json:"replaced_by_txid"`
+	Details         []*struct {
+		Address  string          `json:"address"`
+		Category string          `json:"category"`
+		Amount   decimal.Decimal `json:"amount"`
+		Vout     int64           `json:"vout"`
+	} `json:"details"`
+}
+
+// replacedTxHash return the hash of the transaction replaced by t when the wallet of the node know it
+func (t *WalletTransaction) replacedTxHash() null.String {
+	if len(t.ReplacesTxID) > 0 {
+		return null.StringFrom(t.ReplacesTxID)
+	}
+
+	// a received replacement only conflict with the transaction it replaced
+	if t.Confirmations >= 0 && len(t.WalletConflicts) == 1 {
+		return null.StringFrom(t.WalletConflicts[0])
+	}
+
+	return null.String{}
 }
 
 type Block struct {
-	Hash          string    `json:"hash"`
-	Confirmations int       `json:"confirmations"`
-	Size          int       `json:"size"`
-	Height        int64     `json:"height"`
-	Version       int       `json:"version"`
-	MerkleRoot    string    `json:"merkleroot"`
-	Tx            []*TxHash `json:"tx"`
+	Hash              string    `json:"hash"`
+	PreviousBlockHash string    `json:"previousblockhash"`
+	Confirmations     int64     `json:"confirmations"`
+	Size              int       `json:"size"`
+	Height            int64     `json:"height"`
+	Version           int       `json:"version"`
+	MerkleRoot        string    `json:"merkleroot"`
+	Time              int64     `json:"time"`
+	Tx                []*TxHash `json:"tx"`
 }
 
+// replacementDepth the max confirmations of a transaction whose replaced transaction is looked up,
+// a replacement is reported while the transaction it replaced may still be tracked as pending
+const replacementDepth = 6
+
 type Blockchain struct {
 	currency *currency.Currency
 	setting  *blockchain.Setting
-	client   *resty.Client
+	client   *rpc.Client
 }
 
-func NewBlockchain() blockchain.Blockchain {
-	return &Blockchain{
-		client: resty.New(),
-	}
+func init() {
+	blockchain.Register("bitcoin", NewBlockchain)
 }
 
-func (b *Blockchain) Configure(settings *blockchain.Setting) {
-	b.setting = settings
-
-	for _, c := range settings.Currencies {
-		// allow only one currency
-		b.currency = c
-		break
-	}
+func NewBlockchain() blockchain.Blockchain {
+	return &Blockchain{}
 }
 
-func (b *Blockchain) jsonRPC(ctx context.Context, resp interface{}, method string, params ...interface{}) error {
-	type Result struct {
-		Version string           `json:"version"`
-		ID      int              `json:"id"`
-		Result  *json.RawMessage `json:"result"`
-		Error   *json.RawMessage `json:"error"`
-	}
-
-	response, err := b.client.
-		R().
-		SetContext(ctx).
-		SetResult(Result{}).
-		SetHeaders(map[string]string{
-			"Accept":       "application/json",
-			"Content-Type": "application/json",
-		}).
-		SetBody(map[string]interface{}{
-			"version": "2.0",
-			"id":      rand.Int(),
-			"method":  method,
-			"params":  params,
-		}).Post(b.setting.URI)
-
-	if err != nil {
+func (b *Blockchain) Configure(settings *blockchain.Setting) error {
+	if err := settings.Validate(); err != nil {
 		return err
 	}
 
-	result := response.Result().(*Result)
-
-	if result.Error != nil {
-		return errors.New("jsonRPC error: " + string(*result.Error))
-	}
-
-	if result.Result == nil {
-		return errors.New("jsonRPC error: result is nil")
+	// allow only one currency
+	if len(settings.Currencies) != 1 {
+		return errors.NewConfigError("currencies", "expected exactly one currency, got %d", len(settings.Currencies))
 	}
 
-	if err := json.Unmarshal(*result.Result, resp); err != nil {
-		return err
-	}
+	b.setting = settings
+	b.currency = settings.Currencies[0]
+	b.client = rpc.NewClient(settings.URI, rpc.WithErrorParser(parseRPCError), rpc.WithHealthCheck(rpc.DefaultHealthInterval, "getblockcount"))
 
 	return nil
 }
 
 func (b *Blockchain) GetLatestBlockNumber(ctx context.Context) (int64, error) {
 	var resp int64
-	if err := b.jsonRPC(ctx, &resp, "getblockcount"); err != nil {
+	if err := b.client.Call(ctx, &resp, "getblockcount"); err != nil {
 		return 0, err
 	}
 
@@ -123,7 +126,7 @@ func (b *Blockchain) GetLatestBlockNumber(ctx context.Context) (int64, error) {
 
 func (b *Blockchain) GetBlockByNumber(ctx context.Context, block_number int64) (*block.Block, error) {
 	var hash string
-	if err := b.jsonRPC(ctx, &hash, "getblockhash", block_number); err != nil {
+	if err := b.client.Call(ctx, &hash, "getblockhash", block_number); err != nil {
 		return nil, err
 	}
 
@@ -132,26 +135,35 @@ func (b *Blockchain) GetBlockByNumber(ctx context.Context, block_number int64) (
 
 func (b *Blockchain) GetBlockByHash(ctx context.Context, hash string) (*block.Block, error) {
 	var resp *Block
-	err := b.jsonRPC(ctx, &resp, "getblock", hash, 2)
+	err := b.client.Call(ctx, &resp, "getblock", hash, 2)
 	if err != nil {
 		return nil, err
 	}
 
+	replaced := b.blockReplacements(ctx, resp)
+
 	transactions := make([]*transaction.Transaction, 0)
 	for _, tx := range resp.Tx {
-		transactions = append(transactions, b.buildTransaction(ctx, tx)...)
+		for _, t := range b.buildTransaction(ctx, tx) {
+			t.ReplacedTxHash = replaced[tx.TxID]
+			transactions = append(transactions, t)
+		}
 	}
 
+	b.setting.ApplyConfirmations(resp.Confirmations, transactions...)
+
 	return &block.Block{
 		Hash:         resp.Hash,
+		ParentHash:   resp.PreviousBlockHash,
 		Number:       resp.Height,
+		Timestamp:    time.Unix(resp.Time, 0),
 		Transactions: transactions,
 	}, nil
 }
 
 func (b *Blockchain) GetBalanceOfAddress(ctx context.Context, address string, currencyID string) (decimal.Decimal, error) {
 	var resp [][][]interface{}
-	if err := b.jsonRPC(ctx, &resp, "listaddressgroupings"); err != nil {
+	if err := b.client.Call(ctx, &resp, "listaddressgroupings"); err != nil {
 		return decimal.Zero, err
 	}
 
@@ -168,10 +180,19 @@ func (b *Blockchain) GetBalanceOfAddress(ctx context.Context, address string, cu
 
 func (b *Blockchain) GetTransaction(ctx context.Context, transaction_hash string) (tx *transaction.Transaction, err error) {
 	var resp *TxHash
-	if err := b.jsonRPC(ctx, &resp, "getrawtransaction", transaction_hash, 1); err != nil {
+	if err := b.client.Call(ctx, &resp, "getrawtransaction", transaction_hash, 1); err != nil {
+		if errors.Is(err, errors.ErrTxNotFound) {
+			return b.replacedTransaction(ctx, transaction_hash, err)
+		}
+
 		return nil, err
 	}
 
+	var replacedTxHash null.String
+	if resp.Confirmations <= replacementDepth {
+		replacedTxHash = b.replacedTxHash(ctx, transaction_hash)
+	}
+
 	for _, v := range b.buildVOut(resp.VOut) {
 		fee, err := b.calculateFee(ctx, resp)
 		if err != nil {
@@ -179,19 +200,116 @@ func (b *Blockchain) GetTransaction(ctx context.Context, transaction_hash string
 		}
 
 		tx = &transaction.Transaction{
-			TxHash:      null.StringFrom(resp.TxID),
-			ToAddress:   v.ScriptPubKey.Addresses[0],
-			Currency:    b.currency.ID,
-			CurrencyFee: b.currency.ID,
-			Fee:         decimal.NewNullDecimal(fee),
-			Amount:      v.Value,
-			Status:      transaction.StatusSucceed,
+			TxHash:         null.StringFrom(resp.TxID),
+			ReplacedTxHash: replacedTxHash,
+			Position:       null.Int64From(v.N),
+			ToAddress:      v.ScriptPubKey.Addresses[0],
+			Currency:       b.currency.ID,
+			CurrencyFee:    b.currency.ID,
+			Fee:            decimal.NewNullDecimal(fee),
+			Amount:         v.Value,
+			Status:         transaction.StatusSucceed,
 		}
+
+		b.setting.ApplyConfirmations(resp.Confirmations, tx)
 	}
 
 	return
 }
 
+// replacedTransaction report the deposit of a transaction which left the mempool because a conflicting one,
+// usually a BIP125 replacement, was accepted instead, notFound is returned when the node don't know it was replaced
+func (b *Blockchain) replacedTransaction(ctx context.Context, hash string, notFound error) (*transaction.Transaction, error) {
+	var resp *WalletTransaction
+	if err := b.client.Call(ctx, &resp, "gettransaction", hash, true); err != nil {
+		return nil, notFound
+	}
+
+	// a negative number of confirmations mean a conflicting transaction is mined
+	if resp.Confirmations > 0 || resp.Confirmations == 0 && len(resp.ReplacedByTxID) == 0 && len(resp.WalletConflicts) == 0 {
+		return nil, notFound
+	}
+
+	// a received replacement is the only transaction conflicting with the deposit
+	replacedBy := null.NewString(resp.ReplacedByTxID, len(resp.ReplacedByTxID) > 0)
+	if !replacedBy.Valid && len(resp.WalletConflicts) == 1 {
+		replacedBy = null.StringFrom(resp.WalletConflicts[0])
+	}
+
+	var tx *transaction.Transaction
+	for _, detail := range resp.Details {
+		if detail.Category != "receive" {
+			continue
+		}
+
+		tx = &transaction.Transaction{
+			TxHash:           null.StringFrom(resp.TxID),
+			ReplacedByTxHash: replacedBy,
+			Position:         null.Int64From(detail.Vout),
+			ToAddress:        detail.Address,
+			Currency:         b.currency.ID,
+			CurrencyFee:      b.currency.ID,
+			Amount:           detail.Amount,
+			Status:           transaction.StatusFailed,
+		}
+	}
+
+	if tx == nil {
+		return nil, notFound
+	}
+
+	return tx, nil
+}
+
+// replacedTxHash return the hash of the transaction replaced by hash when the wallet of the node know it
+func (b *Blockchain) replacedTxHash(ctx context.Context, hash string) null.String {
+	var resp *WalletTransaction
+	if err := b.client.Call(ctx, &resp, "gettransaction", hash, true); err != nil {
+		return null.String{}
+	}
+
+	return resp.replacedTxHash()
+}
+
+// blockReplacements return the hash of the transaction replaced by each wallet transaction of blk
+// with a single listsinceblock, the wallet transactions of the blocks mined after blk are listed too
+// so the lookup is skipped for blocks deeper than replacementDepth
+func (b *Blockchain) blockReplacements(ctx context.Context, blk *Block) map[string]null.String {
+	replaced := make(map[string]null.String)
+	if blk.Confirmations > replacementDepth || len(blk.PreviousBlockHash) == 0 {
+		return replaced
+	}
+
+	var resp struct {
+		Transactions []*WalletTransaction `json:"transactions"`
+	}
+	// the node may run without wallet
+	if err := b.client.Call(ctx, &resp, "listsinceblock", blk.PreviousBlockHash, 1, true); err != nil {
+		return replaced
+	}
+
+	for _, tx := range resp.Transactions {
+		if tx.BlockHash != blk.Hash {
+			continue
+		}
+
+		if replacedTxHash := tx.replacedTxHash(); replacedTxHash.Valid {
+			replaced[tx.TxID] = replacedTxHash
+		}
+	}
+
+	return replaced
+}
+
+func (b *Blockchain) Confirmations(ctx context.Context, transactionHash string) (int64, error) {
+	var resp *TxHash
+	if err := b.client.Call(ctx, &resp, "getrawtransaction", transactionHash, 1); err != nil {
+		return 0, err
+	}
+
+	return resp.Confirmations, nil
+}
+
 func (b *Blockchain) calculateFee(ctx context.Context, tx *TxHash) (decimal.Decimal, error) {
 	vins := decimal.Zero
 	vouts := decimal.Zero
@@ -204,7 +322,7 @@ func (b *Blockchain) calculateFee(ctx context.Context, tx *TxHash) (decimal.Deci
 		}
 
 		var resp *TxHash
-		if err := b.jsonRPC(ctx, &resp, "getrawtransaction", vin, 1); err != nil {
+		if err := b.client.Call(ctx, &resp, "getrawtransaction", vin, 1); err != nil {
 			return decimal.Zero, err
 		}
 		if len(resp.VOut) == 0 {
@@ -240,7 +358,7 @@ func (b *Blockchain) buildVOut(vout []*VOut) []*VOut {
 
 func (b *Blockchain) transactionSource(ctx context.Context, transaction *transaction.Transaction) (string, error) {
 	var transHash *TxHash
-	err := b.jsonRPC(ctx, &transHash, "getrawtransaction", transaction.TxHash.String, 1)
+	err := b.client.Call(ctx, &transHash, "getrawtransaction", transaction.TxHash.String, 1)
 	if err != nil {
 		return "", err
 	}
@@ -251,7 +369,7 @@ func (b *Blockchain) transactionSource(ctx context.Context, transaction *transac
 		}
 
 		var vinTransaction *TxHash
-		err := b.jsonRPC(ctx, &vinTransaction, "getrawtransaction", vin.TxID, 1)
+		err := b.client.Call(ctx, &vinTransaction, "getrawtransaction", vin.TxID, 1)
 		if err != nil {
 			return "", err
 		}
@@ -288,6 +406,7 @@ func (b *Blockchain) buildTransaction(ctx context.Context, tx *TxHash) []*transa
 			ToAddress:   entry.ScriptPubKey.Addresses[0],
 			Amount:      entry.Value,
 			TxHash:      null.StringFrom(tx.TxID),
+			Position:    null.Int64From(entry.N),
 			Status:      transaction.StatusSucceed,
 		}
 
@@ -0,0 +1,230 @@
+package bitcoin
+
+import (
+	"encoding/hex"
+	"math"
+	"sort"
+
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/multichain/pkg/errors"
+)
+
+const (
+	CoinSelectionBranchAndBound   = "branch_and_bound"
+	CoinSelectionLargestFirst     = "largest_first"
+	CoinSelectionConsolidateSmall = "consolidate_small"
+)
+
+// virtual sizes of the parts of a transaction, used to estimate the fee before it is built
+const (
+	txOverheadVSize = 11
+	outputVSize     = 31
+	// inputVSize of a P2WPKH input, inputs spending an unknown script are sized like it
+	inputVSize           = 68
+	p2pkhInputVSize      = 148
+	p2shP2wpkhInputVSize = 91
+	p2trInputVSize       = 58
+)
+
+// dustThreshold change below this amount of satoshis is left to the fee instead of creating an output
+const dustThreshold = 546
+
+// bnbMaxTries the max number of branches explored by BranchAndBound
+const bnbMaxTries = 100_000
+
+// UTXO is a spendable output returned by listunspent
+type UTXO struct {
+	TxID          string          `json:"txid"`
+	Vout          int64           `json:"vout"`
+	Address       string          `json:"address"`
+	Amount        decimal.Decimal `json:"amount"`
+	Confirmations int64           `json:"confirmations"`
+	ScriptPubKey  string          `json:"scriptPubKey"`
+	Spendable     bool            `json:"spendable"`
+	Solvable      bool            `json:"solvable"`
+	Safe          bool            `json:"safe"`
+}
+
+// Satoshis return the amount of the output in satoshis
+func (u *UTXO) Satoshis() int64 {
+	return u.Amount.Shift(8).IntPart()
+}
+
+// InputVSize return the virtual size of an input spending u guessed from the type of its script
+func (u *UTXO) InputVSize() int64 {
+	script, err := hex.DecodeString(u.ScriptPubKey)
+	if err != nil {
+		return inputVSize
+	}
+
+	// taproot outputs are not classified by this txscript version
+	if len(script) == 34 && script[0] == txscript.OP_1 && script[1] == txscript.OP_DATA_32 {
+		return p2trInputVSize
+	}
+
+	switch txscript.GetScriptClass(script) {
+	case txscript.PubKeyHashTy:
+		return p2pkhInputVSize
+	case txscript.ScriptHashTy:
+		// the script hash outputs of a wallet are nested segwit
+		return p2shP2wpkhInputVSize
+	default:
+		return inputVSize
+	}
+}
+
+// SelectionParams describe the outputs a selection has to fund
+type SelectionParams struct {
+	// Amount satoshis sent to the recipients
+	Amount int64
+	// Outputs number of recipient outputs, the change output is not included
+	Outputs int
+	// FeeRate satoshis paid per virtual byte
+	FeeRate float64
+}
+
+// Fee return the fee of a transaction spending inputs with outputs
+func (p SelectionParams) Fee(inputs []*UTXO, outputs int) int64 {
+	vsize := int64(txOverheadVSize + outputs*outputVSize)
+	for _, input := range inputs {
+		vsize += input.InputVSize()
+	}
+
+	return int64(math.Ceil(float64(vsize) * p.FeeRate))
+}
+
+// inputFee return the fee paid to spend u
+func (p SelectionParams) inputFee(u *UTXO) int64 {
+	return int64(math.Ceil(float64(u.InputVSize()) * p.FeeRate))
+}
+
+// CoinSelector pick the utxos funding params and its fee, errors.ErrInsufficientFunds is returned when utxos are not enough
+type CoinSelector interface {
+	Select(utxos []*UTXO, params SelectionParams) ([]*UTXO, error)
+}
+
+var coinSelectors = map[string]CoinSelector{
+	CoinSelectionBranchAndBound:   BranchAndBound{},
+	CoinSelectionLargestFirst:     LargestFirst{},
+	CoinSelectionConsolidateSmall: ConsolidateSmall{},
+}
+
+// LargestFirst spend the largest outputs first, it minimizes the number of inputs
+type LargestFirst struct{}
+
+func (LargestFirst) Select(utxos []*UTXO, params SelectionParams) ([]*UTXO, error) {
+	sorted := sortUTXOs(utxos, func(a, b *UTXO) bool { return a.Satoshis() > b.Satoshis() })
+
+	return accumulate(sorted, params)
+}
+
+// ConsolidateSmall spend the smallest outputs first to merge them while fees are low
+type ConsolidateSmall struct{}
+
+func (ConsolidateSmall) Select(utxos []*UTXO, params SelectionParams) ([]*UTXO, error) {
+	sorted := sortUTXOs(utxos, func(a, b *UTXO) bool { return a.Satoshis() < b.Satoshis() })
+
+	return accumulate(sorted, params)
+}
+
+// BranchAndBound search a set of outputs matching the amount closely enough to not need a change output,
+// it falls back to LargestFirst when there is none
+type BranchAndBound struct{}
+
+func (BranchAndBound) Select(utxos []*UTXO, params SelectionParams) ([]*UTXO, error) {
+	// the value of an output once the fee to spend it is paid
+	candidates := make([]*UTXO, 0, len(utxos))
+	for _, utxo := range utxos {
+		if utxo.Satoshis()-params.inputFee(utxo) > 0 {
+			candidates = append(candidates, utxo)
+		}
+	}
+	candidates = sortUTXOs(candidates, func(a, b *UTXO) bool { return a.Satoshis() > b.Satoshis() })
+
+	effective := make([]int64, len(candidates))
+	remaining := int64(0)
+	for i, utxo := range candidates {
+		effective[i] = utxo.Satoshis() - params.inputFee(utxo)
+		remaining += effective[i]
+	}
+
+	target := params.Amount + params.Fee(nil, params.Outputs)
+	// spending more than the cost of a change output is better done with one
+	costOfChange := int64(math.Ceil(float64(outputVSize+inputVSize) * params.FeeRate))
+
+	var best []int
+	bestWaste := int64(math.MaxInt64)
+
+	selected := make([]int, 0)
+	current := int64(0)
+	tries := 0
+
+	var search func(index int, remaining int64)
+	search = func(index int, remaining int64) {
+		tries++
+		if tries > bnbMaxTries || current+remaining < target || current > target+costOfChange {
+			return
+		}
+
+		if current >= target {
+			if waste := current - target; waste < bestWaste {
+				bestWaste = waste
+				best = append([]int{}, selected...)
+			}
+
+			return
+		}
+
+		if index >= len(candidates) {
+			return
+		}
+
+		// include the output then explore without it
+		selected = append(selected, index)
+		current += effective[index]
+		search(index+1, remaining-effective[index])
+		current -= effective[index]
+		selected = selected[:len(selected)-1]
+
+		search(index+1, remaining-effective[index])
+	}
+	search(0, remaining)
+
+	if best == nil {
+		return LargestFirst{}.Select(utxos, params)
+	}
+
+	result := make([]*UTXO, len(best))
+	for i, index := range best {
+		result[i] = candidates[index]
+	}
+
+	return result, nil
+}
+
+// accumulate take utxos in order until they fund params with a change output
+func accumulate(utxos []*UTXO, params SelectionParams) ([]*UTXO, error) {
+	selected := make([]*UTXO, 0)
+	total := int64(0)
+	for _, utxo := range utxos {
+		selected = append(selected, utxo)
+		total += utxo.Satoshis()
+
+		// a change under the dust threshold is dropped so the transaction is funded without it too
+		if total >= params.Amount+params.Fee(selected, params.Outputs+1) ||
+			total >= params.Amount+params.Fee(selected, params.Outputs) && total-params.Amount-params.Fee(selected, params.Outputs) < dustThreshold {
+			return selected, nil
+		}
+	}
+
+	return nil, errors.ErrInsufficientFunds
+}
+
+func sortUTXOs(utxos []*UTXO, less func(a, b *UTXO) bool) []*UTXO {
+	sorted := append([]*UTXO{}, utxos...)
+	sort.SliceStable(sorted, func(i, j int) bool { return less(sorted[i], sorted[j]) })
+
+	return sorted
+}
@@ -0,0 +1,97 @@
+package bitcoin
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/multichain/pkg/errors"
+)
+
+func newUTXOs(amounts ...int64) []*UTXO {
+	utxos := make([]*UTXO, len(amounts))
+	for i, amount := range amounts {
+		utxos[i] = &UTXO{TxID: "tx", Vout: int64(i), Amount: decimal.New(amount, -8), Spendable: true, Safe: true}
+	}
+
+	return utxos
+}
+
+func selectedAmounts(utxos []*UTXO) []int64 {
+	amounts := make([]int64, len(utxos))
+	for i, utxo := range utxos {
+		amounts[i] = utxo.Satoshis()
+	}
+
+	return amounts
+}
+
+func TestCoinSelector_Select(t *testing.T) {
+	utxos := newUTXOs(1_000, 3_000, 20_000, 100_000, 37_200)
+	params := SelectionParams{Amount: 40_000, Outputs: 1, FeeRate: 1}
+
+	tests := []struct {
+		name     string
+		selector CoinSelector
+		expected []int64
+	}{
+		{name: "largest first", selector: LargestFirst{}, expected: []int64{100_000}},
+		{name: "consolidate small", selector: ConsolidateSmall{}, expected: []int64{1_000, 3_000, 20_000, 37_200}},
+		// 37200 + 3000 pay 40000 and the fee of two inputs without change
+		{name: "branch and bound", selector: BranchAndBound{}, expected: []int64{37_200, 3_000}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			selected, err := test.selector.Select(utxos, params)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			amounts := selectedAmounts(selected)
+			if len(amounts) != len(test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, amounts)
+			}
+
+			for i := range amounts {
+				if amounts[i] != test.expected[i] {
+					t.Fatalf("expected %v, got %v", test.expected, amounts)
+				}
+			}
+		})
+	}
+}
+
+func TestCoinSelector_InsufficientFunds(t *testing.T) {
+	utxos := newUTXOs(1_000, 5_000)
+	params := SelectionParams{Amount: 10_000, Outputs: 1, FeeRate: 1}
+
+	for _, selector := range coinSelectors {
+		if _, err := selector.Select(utxos, params); !errors.Is(err, errors.ErrInsufficientFunds) {
+			t.Errorf("expected insufficient funds, got %v", err)
+		}
+	}
+}
+
+func TestSelectionParams_Fee(t *testing.T) {
+	tests := []struct {
+		name         string
+		scriptPubKey string
+		expected     int64
+	}{
+		{name: "p2wpkh", scriptPubKey: "0014" + strings.Repeat("11", 20), expected: 11 + 68 + 31},
+		{name: "p2pkh", scriptPubKey: "76a914" + strings.Repeat("11", 20) + "88ac", expected: 11 + 148 + 31},
+		{name: "p2sh", scriptPubKey: "a914" + strings.Repeat("11", 20) + "87", expected: 11 + 91 + 31},
+		{name: "p2tr", scriptPubKey: "5120" + strings.Repeat("11", 32), expected: 11 + 58 + 31},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			params := SelectionParams{FeeRate: 1}
+			if fee := params.Fee([]*UTXO{{ScriptPubKey: test.scriptPubKey}}, 1); fee != test.expected {
+				t.Errorf("expected fee %d, got %d", test.expected, fee)
+			}
+		})
+	}
+}
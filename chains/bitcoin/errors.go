@@ -0,0 +1,64 @@
+package bitcoin
+
+import (
+	"strings"
+
+	"github.com/zsmartex/multichain/pkg/errors"
+)
+
+// bitcoind rpc error codes, see src/rpc/protocol.h
+const (
+	rpcInvalidAddressOrKey     = -5
+	rpcWalletInsufficientFunds = -6
+	rpcInvalidParameter        = -8
+	rpcClientNotConnected      = -9
+	rpcClientInInitialDownload = -10
+	rpcVerifyRejected          = -26
+	rpcVerifyAlreadyInChain    = -27
+	rpcInWarmup                = -28
+	rpcClientNodeNotConnected  = -29
+	rpcClientP2PDisabled       = -31
+)
+
+// parseRPCError map the error payload of bitcoind onto the shared errors
+func parseRPCError(code int, message string) error {
+	rpcErr := &errors.RPCError{
+		Code:    code,
+		Message: message,
+	}
+
+	msg := strings.ToLower(message)
+	switch {
+	case code == rpcWalletInsufficientFunds || strings.Contains(msg, "insufficient funds"):
+		rpcErr.Err = errors.ErrInsufficientFunds
+	case code == rpcInvalidAddressOrKey && strings.Contains(msg, "no such") && strings.Contains(msg, "transaction"),
+		code == rpcInvalidAddressOrKey && strings.Contains(msg, "non-wallet transaction"):
+		rpcErr.Err = errors.ErrTxNotFound
+	case code == rpcInvalidAddressOrKey && strings.Contains(msg, "block not found"),
+		code == rpcInvalidParameter && strings.Contains(msg, "block height out of range"):
+		rpcErr.Err = errors.ErrBlockNotFound
+	case code == rpcInvalidAddressOrKey && strings.Contains(msg, "address"):
+		rpcErr.Err = errors.ErrInvalidAddress
+	case code == rpcVerifyAlreadyInChain,
+		code == rpcVerifyRejected && (strings.Contains(msg, "txn-already-in-mempool") || strings.Contains(msg, "txn-already-known")):
+		rpcErr.Err = errors.ErrAlreadyBroadcast
+	case code == rpcVerifyRejected && (strings.Contains(msg, "txn-mempool-conflict") || strings.Contains(msg, "insufficient fee")):
+		rpcErr.Err = errors.ErrNonceConflict
+	case code == rpcInWarmup,
+		code == rpcClientNotConnected,
+		code == rpcClientInInitialDownload,
+		code == rpcClientNodeNotConnected,
+		code == rpcClientP2PDisabled:
+		rpcErr.Err = errors.ErrNodeUnavailable
+	}
+
+	return rpcErr
+}
+
+// isRejected report whether the node answered err, so the request was definitely not processed,
+// other errors like timeouts leave unknown whether the node got it
+func isRejected(err error) bool {
+	var rpcErr *errors.RPCError
+
+	return errors.As(err, &rpcErr) || errors.Is(err, errors.ErrRateLimited)
+}
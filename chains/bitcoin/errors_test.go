@@ -0,0 +1,32 @@
+package bitcoin
+
+import (
+	"testing"
+
+	"github.com/zsmartex/multichain/pkg/errors"
+)
+
+func TestParseRPCError(t *testing.T) {
+	cases := []struct {
+		code     int
+		message  string
+		expected error
+	}{
+		{-5, "No such mempool or blockchain transaction. Use gettransaction for wallet transactions.", errors.ErrTxNotFound},
+		{-5, "Block not found", errors.ErrBlockNotFound},
+		{-8, "Block height out of range", errors.ErrBlockNotFound},
+		{-5, "Invalid Bitcoin address", errors.ErrInvalidAddress},
+		{-6, "Insufficient funds", errors.ErrInsufficientFunds},
+		{-26, "txn-mempool-conflict", errors.ErrNonceConflict},
+		{-26, "txn-already-in-mempool", errors.ErrAlreadyBroadcast},
+		{-27, "Transaction already in block chain", errors.ErrAlreadyBroadcast},
+		{-27, "Transaction outputs already in utxo set", errors.ErrAlreadyBroadcast},
+		{-28, "Loading block index...", errors.ErrNodeUnavailable},
+	}
+
+	for _, c := range cases {
+		if err := parseRPCError(c.code, c.message); !errors.Is(err, c.expected) {
+			t.Errorf("%d %s: expected %v, got %v", c.code, c.message, c.expected, err)
+		}
+	}
+}
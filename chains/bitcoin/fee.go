@@ -0,0 +1,95 @@
+package bitcoin
+
+import (
+	"context"
+	"math"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/multichain/pkg/wallet"
+)
+
+// defaultFeeRate satoshis per virtual byte used when the node can't estimate a fee rate
+const defaultFeeRate = 1
+
+// feeRateTargets the confirmation target in blocks given to estimatesmartfee for each rate
+var feeRateTargets = map[wallet.GasPriceRate]int64{
+	wallet.GasPriceRateStandard: 6,
+	wallet.GasPriceRateFast:     2,
+}
+
+// feeRate return the fee_rate option in satoshis per virtual byte or the rate estimated by the node
+// for the gas_rate option, estimates are kept between the min_fee_rate and max_fee_rate of the currency
+func (w *Wallet) feeRate(ctx context.Context, options map[string]interface{}) (float64, error) {
+	if rate, ok := numberOption(options["fee_rate"]); ok {
+		return rate, nil
+	}
+
+	if rate, ok := numberOption(w.currency.Options["fee_rate"]); ok {
+		return rate, nil
+	}
+
+	rate, err := w.estimateFeeRate(ctx, options)
+	if err != nil {
+		return 0, err
+	}
+
+	if floor, ok := numberOption(w.currency.Options["min_fee_rate"]); ok {
+		rate = math.Max(rate, floor)
+	}
+
+	if ceiling, ok := numberOption(w.currency.Options["max_fee_rate"]); ok {
+		rate = math.Min(rate, ceiling)
+	}
+
+	return rate, nil
+}
+
+func (w *Wallet) estimateFeeRate(ctx context.Context, options map[string]interface{}) (float64, error) {
+	var rate wallet.GasPriceRate
+	switch r := options["gas_rate"].(type) {
+	case wallet.GasPriceRate:
+		rate = r
+	case string:
+		rate = wallet.GasPriceRate(r)
+	}
+
+	target, ok := feeRateTargets[rate]
+	if !ok {
+		target = feeRateTargets[wallet.GasPriceRateStandard]
+	}
+
+	var estimate struct {
+		FeeRate *decimal.Decimal `json:"feerate"`
+	}
+	if err := w.client.Call(ctx, &estimate, "estimatesmartfee", target); err != nil {
+		return 0, err
+	}
+
+	// estimatesmartfee answer in BTC per kvB
+	if estimate.FeeRate == nil {
+		return defaultFeeRate, nil
+	}
+
+	feeRate, _ := estimate.FeeRate.Shift(5).Float64()
+
+	return feeRate, nil
+}
+
+// formatFeeRate return rate as the fee_rate param of the wallet rpcs, they accept up to 3 decimals
+func formatFeeRate(rate float64) decimal.Decimal {
+	return decimal.NewFromFloat(rate).Round(3)
+}
+
+// transactionFee return the fee paid by a transaction sent by the wallet of the node
+func (w *Wallet) transactionFee(ctx context.Context, txid string) (decimal.Decimal, error) {
+	var resp struct {
+		Fee decimal.Decimal `json:"fee"`
+	}
+	if err := w.client.Call(ctx, &resp, "gettransaction", txid); err != nil {
+		return decimal.Zero, err
+	}
+
+	// the fee of a sent transaction is negative
+	return resp.Fee.Abs(), nil
+}
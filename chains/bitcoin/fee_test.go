@@ -0,0 +1,81 @@
+package bitcoin
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/multichain/internal/rpctest"
+	"github.com/zsmartex/multichain/pkg/transaction"
+	"github.com/zsmartex/multichain/pkg/wallet"
+)
+
+func TestWallet_FeeRate(t *testing.T) {
+	// 2 blocks are expected for 50 sat/vB, 6 blocks for 3 sat/vB
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"estimatesmartfee": func(params []json.RawMessage) interface{} {
+			if string(params[0]) == "2" {
+				return map[string]interface{}{"feerate": 0.0005, "blocks": 2}
+			}
+
+			return map[string]interface{}{"feerate": 0.00003, "blocks": 6}
+		},
+	})
+	defer server.Close()
+
+	w := newTestWallet(t, server.URL, map[string]interface{}{"min_fee_rate": 5, "max_fee_rate": 40.5}).(*Wallet)
+
+	tests := []struct {
+		options  map[string]interface{}
+		expected float64
+	}{
+		{nil, 5},
+		{map[string]interface{}{"gas_rate": wallet.GasPriceRateStandard}, 5},
+		{map[string]interface{}{"gas_rate": "fast"}, 40.5},
+		{map[string]interface{}{"gas_rate": "fast", "fee_rate": 80}, 80},
+	}
+
+	for _, test := range tests {
+		rate, err := w.feeRate(context.Background(), test.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rate != test.expected {
+			t.Errorf("expected %v sat/vB for %v, got %v", test.expected, test.options, rate)
+		}
+	}
+}
+
+func TestWallet_CreateTransactionFee(t *testing.T) {
+	var feeRate decimal.Decimal
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"sendtoaddress": func(params []json.RawMessage) interface{} {
+			json.Unmarshal(params[9], &feeRate)
+
+			return "txid"
+		},
+		"gettransaction": map[string]interface{}{"fee": -0.0000282},
+	})
+	defer server.Close()
+
+	w := newTestWallet(t, server.URL, nil)
+
+	tx, err := w.CreateTransaction(context.Background(), &transaction.Transaction{
+		ToAddress: "bcrt1qalice",
+		Amount:    decimal.NewFromFloat(0.001),
+	}, map[string]interface{}{"fee_rate": 12.3456})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !feeRate.Equal(decimal.NewFromFloat(12.346)) {
+		t.Errorf("unexpected fee rate %s", feeRate)
+	}
+
+	if tx.TxHash.String != "txid" || !tx.Fee.Decimal.Equal(decimal.NewFromFloat(0.0000282)) {
+		t.Errorf("unexpected transaction %s with fee %s", tx.TxHash.String, tx.Fee.Decimal)
+	}
+}
@@ -0,0 +1,33 @@
+package bitcoin
+
+import (
+	"testing"
+
+	"github.com/zsmartex/multichain/pkg/blockchain"
+	"github.com/zsmartex/multichain/pkg/currency"
+	"github.com/zsmartex/multichain/pkg/wallet"
+)
+
+func newTestBlockchain(t *testing.T, uri string) blockchain.Blockchain {
+	bl := NewBlockchain()
+	if err := bl.Configure(&blockchain.Setting{
+		URI:        uri,
+		Currencies: []*currency.Currency{{ID: "BTC", Subunits: 8}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return bl
+}
+
+func newTestWallet(t *testing.T, uri string, options map[string]interface{}) wallet.Wallet {
+	w := NewWallet()
+	if err := w.Configure(&wallet.Setting{
+		Wallet:   &wallet.SettingWallet{URI: uri, Address: "bcrt1qqqd8hdc684cqpm5ydfd535eygxlmh54wysmzry"},
+		Currency: &currency.Currency{ID: "BTC", Subunits: 8, Options: options},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
@@ -0,0 +1,442 @@
+package bitcoin
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/psbt"
+	"github.com/shopspring/decimal"
+	"github.com/volatiletech/null/v9"
+
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/transaction"
+)
+
+// networks the chain parameters selected by the network option, default to mainnet
+var networks = map[string]*chaincfg.Params{
+	"mainnet": &chaincfg.MainNetParams,
+	"testnet": &chaincfg.TestNet3Params,
+	"regtest": &chaincfg.RegressionNetParams,
+	"signet":  &chaincfg.SigNetParams,
+}
+
+// PSBTInput is an output spent by a PSBT
+type PSBTInput struct {
+	TxID    string
+	Vout    int64
+	Address string
+	Amount  decimal.Decimal
+	Signed  bool // at least one signature or the final script is set
+}
+
+// PSBTOutput is an output created by a PSBT
+type PSBTOutput struct {
+	Address string
+	Amount  decimal.Decimal
+	Change  bool // paid back to the wallet
+}
+
+// PSBTDetails is what a PSBT spends and pays, to be reviewed before it's signed
+type PSBTDetails struct {
+	TxID     string
+	Inputs   []*PSBTInput
+	Outputs  []*PSBTOutput
+	Fee      decimal.Decimal
+	Complete bool // every input is signed and it can be broadcast
+}
+
+// CreatePSBT fund tx with the outputs of the wallet address and return it as an unsigned base64 PSBT,
+// the node only has to watch the address so the keys can be kept offline. The spent outputs are locked
+// until the PSBT is broadcast with BroadcastPSBT or released with AbandonPSBT
+func (w *Wallet) CreatePSBT(ctx context.Context, tx *transaction.Transaction, options map[string]interface{}) (string, error) {
+	// the change must be paid to the offline keys instead of an address of the node
+	if changeAddress, _ := w.currency.Options["change_address"].(string); len(changeAddress) == 0 && len(w.wallet.Address) == 0 {
+		return "", errors.NewConfigError("currency.options.change_address", "is required to create a psbt without wallet address")
+	}
+
+	selector := w.coinSelector()
+	if selector == nil {
+		selector = coinSelectors[CoinSelectionLargestFirst]
+	}
+
+	var subtractFee bool
+	if options["subtract_fee"] != nil {
+		subtractFee = options["subtract_fee"].(bool)
+	}
+
+	utxos, err := w.listUnspent(ctx, true)
+	if err != nil {
+		return "", err
+	}
+
+	funded, rawOutputs, err := w.fundOutputs(ctx, []*output{
+		{Address: tx.ToAddress, Amount: tx.Amount.Shift(8).IntPart(), SubtractFee: subtractFee},
+	}, utxos, selector, options)
+	if err != nil {
+		return "", err
+	}
+
+	// the signing can take a while, a send in the meantime must not select the same outputs
+	if err := w.lockUnspent(ctx, false, funded.Inputs); err != nil {
+		return "", err
+	}
+
+	packet, err := w.buildPSBT(ctx, funded, rawOutputs, options)
+	if err != nil {
+		w.lockUnspent(ctx, true, funded.Inputs)
+		return "", err
+	}
+
+	return packet, nil
+}
+
+// buildPSBT return the base64 PSBT spending the inputs of funded to pay rawOutputs
+func (w *Wallet) buildPSBT(ctx context.Context, funded *fundedTransaction, rawOutputs []map[string]decimal.Decimal, options map[string]interface{}) (string, error) {
+	unsignedTx := wire.NewMsgTx(wire.TxVersion)
+	for _, input := range funded.Inputs {
+		hash, err := chainhash.NewHashFromStr(input.TxID)
+		if err != nil {
+			return "", err
+		}
+
+		txIn := wire.NewTxIn(wire.NewOutPoint(hash, uint32(input.Vout)), nil, nil)
+		if w.replaceable(options) {
+			txIn.Sequence = replaceableSequence
+		}
+
+		unsignedTx.AddTxIn(txIn)
+	}
+
+	for _, rawOutput := range rawOutputs {
+		for address, amount := range rawOutput {
+			script, err := w.payToAddress(address)
+			if err != nil {
+				return "", err
+			}
+
+			unsignedTx.AddTxOut(wire.NewTxOut(amount.Shift(8).IntPart(), script))
+		}
+	}
+
+	packet, err := psbt.NewFromUnsignedTx(unsignedTx)
+	if err != nil {
+		return "", err
+	}
+
+	for i, input := range funded.Inputs {
+		script, err := hex.DecodeString(input.ScriptPubKey)
+		if err != nil {
+			return "", err
+		}
+
+		// segwit inputs only commit to the spent output, legacy ones need the whole previous transaction
+		if txscript.IsWitnessProgram(script) {
+			packet.Inputs[i].WitnessUtxo = wire.NewTxOut(input.Satoshis(), script)
+			continue
+		}
+
+		packet.Inputs[i].NonWitnessUtxo, err = w.walletTransaction(ctx, input.TxID)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return packet.B64Encode()
+}
+
+// InspectPSBT decode a base64 PSBT and return its inputs, outputs and fee
+func (w *Wallet) InspectPSBT(packet string) (*PSBTDetails, error) {
+	p, err := decodePSBT(packet)
+	if err != nil {
+		return nil, err
+	}
+
+	details := &PSBTDetails{
+		TxID:     p.UnsignedTx.TxHash().String(),
+		Inputs:   make([]*PSBTInput, len(p.Inputs)),
+		Outputs:  make([]*PSBTOutput, len(p.UnsignedTx.TxOut)),
+		Complete: p.IsComplete(),
+	}
+
+	fee := int64(0)
+	for i, input := range p.Inputs {
+		outpoint := p.UnsignedTx.TxIn[i].PreviousOutPoint
+
+		prevOut, err := spentOutput(p, i)
+		if err != nil {
+			return nil, err
+		}
+
+		details.Inputs[i] = &PSBTInput{
+			TxID:    outpoint.Hash.String(),
+			Vout:    int64(outpoint.Index),
+			Address: w.scriptAddress(prevOut.PkScript),
+			Amount:  decimal.New(prevOut.Value, -8),
+			Signed:  len(input.PartialSigs) > 0 || len(input.FinalScriptSig) > 0 || len(input.FinalScriptWitness) > 0,
+		}
+
+		fee += prevOut.Value
+	}
+
+	for i, out := range p.UnsignedTx.TxOut {
+		address := w.scriptAddress(out.PkScript)
+
+		details.Outputs[i] = &PSBTOutput{
+			Address: address,
+			Amount:  decimal.New(out.Value, -8),
+			Change:  w.isChangeAddress(address),
+		}
+
+		fee -= out.Value
+	}
+
+	details.Fee = decimal.New(fee, -8)
+
+	return details, nil
+}
+
+// FinalizePSBT finalize the inputs of a signed base64 PSBT and return the hex encoded transaction,
+// the script of every input is executed to verify its signatures
+func (w *Wallet) FinalizePSBT(packet string) (string, error) {
+	p, err := decodePSBT(packet)
+	if err != nil {
+		return "", err
+	}
+
+	prevOuts := make([]*wire.TxOut, len(p.Inputs))
+	for i := range p.Inputs {
+		prevOuts[i], err = spentOutput(p, i)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return finalizePSBT(p, prevOuts)
+}
+
+// finalizePSBT finalize p and verify each input unlocks the output of prevOuts it spends
+func finalizePSBT(p *psbt.Packet, prevOuts []*wire.TxOut) (string, error) {
+	if err := psbt.MaybeFinalizeAll(p); err != nil {
+		return "", fmt.Errorf("failed to finalize psbt: %w", err)
+	}
+
+	signedTx, err := psbt.Extract(p)
+	if err != nil {
+		return "", err
+	}
+
+	hashes := txscript.NewTxSigHashes(signedTx)
+	for i, prevOut := range prevOuts {
+		engine, err := txscript.NewEngine(prevOut.PkScript, signedTx, i, txscript.StandardVerifyFlags, nil, hashes, prevOut.Value)
+		if err != nil {
+			return "", err
+		}
+
+		if err := engine.Execute(); err != nil {
+			return "", fmt.Errorf("invalid signature of psbt input %d: %w", i, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := signedTx.Serialize(&buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf.Bytes()), nil
+}
+
+// BroadcastPSBT finalize the signed PSBT and send it, tx is updated with its hash and fee,
+// created is the PSBT returned by CreatePSBT for tx, the signer must not change what it spends and pays
+func (w *Wallet) BroadcastPSBT(ctx context.Context, tx *transaction.Transaction, created string, signed string) (*transaction.Transaction, error) {
+	createdPacket, err := decodePSBT(created)
+	if err != nil {
+		return nil, err
+	}
+
+	signedPacket, err := decodePSBT(signed)
+	if err != nil {
+		return nil, err
+	}
+
+	// the unsigned transaction commits to every input, output amount and script
+	if signedPacket.UnsignedTx.TxHash() != createdPacket.UnsignedTx.TxHash() {
+		return nil, errors.New("signed psbt does not match the created one")
+	}
+
+	details, err := w.InspectPSBT(created)
+	if err != nil {
+		return nil, err
+	}
+
+	var paid *PSBTOutput
+	for _, out := range details.Outputs {
+		switch {
+		case strings.EqualFold(out.Address, tx.ToAddress):
+			paid = out
+		case !out.Change:
+			return nil, fmt.Errorf("psbt pays %s which is neither %s nor the change", out.Address, tx.ToAddress)
+		}
+	}
+
+	if paid == nil {
+		return nil, fmt.Errorf("psbt does not pay %s", tx.ToAddress)
+	}
+
+	if paid.Amount.GreaterThan(tx.Amount) {
+		return nil, fmt.Errorf("psbt pays %s to %s instead of %s", paid.Amount, tx.ToAddress, tx.Amount)
+	}
+
+	// the spent outputs of the created psbt are trusted, the signer could change the ones of its copy
+	prevOuts := make([]*wire.TxOut, len(createdPacket.Inputs))
+	for i := range createdPacket.Inputs {
+		prevOuts[i], err = spentOutput(createdPacket, i)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	rawTx, err := finalizePSBT(signedPacket, prevOuts)
+	if err != nil {
+		return nil, err
+	}
+
+	// the outputs stay locked when the broadcast fails so the PSBT can be sent again
+	txid, err := w.sendRawTransaction(ctx, rawTx)
+	if err != nil {
+		return nil, err
+	}
+
+	w.lockUnspent(ctx, true, spentUTXOs(details))
+
+	inputs := make([]string, len(details.Inputs))
+	for i, input := range details.Inputs {
+		inputs[i] = fmt.Sprintf("%s:%d", input.TxID, input.Vout)
+	}
+
+	tx.Amount = paid.Amount
+	tx.Fee = decimal.NewNullDecimal(details.Fee)
+	tx.Inputs = inputs
+	tx.Status = transaction.StatusPending
+	tx.TxHash = null.StringFrom(txid)
+
+	return tx, nil
+}
+
+// AbandonPSBT unlock the outputs spent by created, a PSBT returned by CreatePSBT which will not be broadcast
+func (w *Wallet) AbandonPSBT(ctx context.Context, created string) error {
+	details, err := w.InspectPSBT(created)
+	if err != nil {
+		return err
+	}
+
+	return w.lockUnspent(ctx, true, spentUTXOs(details))
+}
+
+// spentUTXOs return the outputs spent by the inputs of details
+func spentUTXOs(details *PSBTDetails) []*UTXO {
+	utxos := make([]*UTXO, len(details.Inputs))
+	for i, input := range details.Inputs {
+		utxos[i] = &UTXO{TxID: input.TxID, Vout: input.Vout}
+	}
+
+	return utxos
+}
+
+// walletTransaction return a transaction of the wallet, watch only ones included
+func (w *Wallet) walletTransaction(ctx context.Context, txid string) (*wire.MsgTx, error) {
+	var resp struct {
+		Hex string `json:"hex"`
+	}
+	if err := w.client.Call(ctx, &resp, "gettransaction", txid, true); err != nil {
+		return nil, err
+	}
+
+	raw, err := hex.DecodeString(resp.Hex)
+	if err != nil {
+		return nil, err
+	}
+
+	msgTx := new(wire.MsgTx)
+	if err := msgTx.Deserialize(bytes.NewReader(raw)); err != nil {
+		return nil, err
+	}
+
+	return msgTx, nil
+}
+
+func (w *Wallet) chainParams() *chaincfg.Params {
+	if name, ok := w.currency.Options["network"].(string); ok {
+		return networks[name]
+	}
+
+	return &chaincfg.MainNetParams
+}
+
+func (w *Wallet) payToAddress(address string) ([]byte, error) {
+	params := w.chainParams()
+
+	decoded, err := btcutil.DecodeAddress(address, params)
+	if err != nil {
+		return nil, err
+	}
+
+	if !decoded.IsForNet(params) {
+		return nil, fmt.Errorf("address %s is not a %s address", address, params.Name)
+	}
+
+	return txscript.PayToAddrScript(decoded)
+}
+
+// scriptAddress return the address paid by script, or an empty string when it's not a standard script
+func (w *Wallet) scriptAddress(script []byte) string {
+	_, addresses, _, err := txscript.ExtractPkScriptAddrs(script, w.chainParams())
+	if err != nil || len(addresses) != 1 {
+		return ""
+	}
+
+	return addresses[0].EncodeAddress()
+}
+
+func (w *Wallet) isChangeAddress(address string) bool {
+	if len(address) == 0 {
+		return false
+	}
+
+	if changeAddress, ok := w.currency.Options["change_address"].(string); ok && strings.EqualFold(address, changeAddress) {
+		return true
+	}
+
+	return strings.EqualFold(address, w.wallet.Address)
+}
+
+func decodePSBT(packet string) (*psbt.Packet, error) {
+	p, err := psbt.NewFromRawBytes(strings.NewReader(packet), true)
+	if err != nil {
+		return nil, fmt.Errorf("invalid psbt: %w", err)
+	}
+
+	return p, nil
+}
+
+// spentOutput return the output spent by the input i of p
+func spentOutput(p *psbt.Packet, i int) (*wire.TxOut, error) {
+	input := p.Inputs[i]
+	if input.WitnessUtxo != nil {
+		return input.WitnessUtxo, nil
+	}
+
+	index := p.UnsignedTx.TxIn[i].PreviousOutPoint.Index
+	if input.NonWitnessUtxo != nil && int(index) < len(input.NonWitnessUtxo.TxOut) {
+		return input.NonWitnessUtxo.TxOut[index], nil
+	}
+
+	return nil, fmt.Errorf("psbt is missing the output spent by input %d", i)
+}
@@ -0,0 +1,282 @@
+package bitcoin
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/btcsuite/btcutil"
+	"github.com/btcsuite/btcutil/psbt"
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/multichain/internal/rpctest"
+	"github.com/zsmartex/multichain/pkg/currency"
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/transaction"
+	"github.com/zsmartex/multichain/pkg/wallet"
+)
+
+// signPSBT sign every input of packet with key as a cold wallet would do
+func signPSBT(t *testing.T, packet string, key *btcec.PrivateKey) string {
+	p, err := psbt.NewFromRawBytes(strings.NewReader(packet), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	updater, err := psbt.NewUpdater(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hashes := txscript.NewTxSigHashes(p.UnsignedTx)
+	for i, input := range p.Inputs {
+		sig, err := txscript.RawTxInWitnessSignature(p.UnsignedTx, hashes, i, input.WitnessUtxo.Value, input.WitnessUtxo.PkScript, txscript.SigHashAll, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := updater.Sign(i, sig, key.PubKey().SerializeCompressed(), nil, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	signed, err := p.B64Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed
+}
+
+func TestWallet_PSBT(t *testing.T) {
+	key, err := btcec.NewPrivateKey(btcec.S256())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address, err := btcutil.NewAddressWitnessPubKeyHash(btcutil.Hash160(key.PubKey().SerializeCompressed()), &chaincfg.RegressionNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	script, err := txscript.PayToAddrScript(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var rawTx string
+	locks := make([]bool, 0)
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"lockunspent": func(params []json.RawMessage) interface{} {
+			var unlock bool
+			json.Unmarshal(params[0], &unlock)
+			locks = append(locks, unlock)
+
+			return true
+		},
+		"listunspent": []map[string]interface{}{
+			// an address added with importaddress is neither spendable nor solvable
+			{"txid": strings.Repeat("ab", 32), "vout": 1, "amount": 0.01, "scriptPubKey": hex.EncodeToString(script), "safe": true},
+		},
+		"sendrawtransaction": func(params []json.RawMessage) interface{} {
+			json.Unmarshal(params[0], &rawTx)
+
+			return "txid"
+		},
+	})
+	defer server.Close()
+
+	w := newTestWallet(t, server.URL, map[string]interface{}{
+		"network":        "regtest",
+		"change_address": address.EncodeAddress(),
+		"fee_rate":       1,
+	}).(*Wallet)
+
+	recipient, err := btcutil.NewAddressWitnessPubKeyHash(make([]byte, 20), &chaincfg.RegressionNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx := &transaction.Transaction{ToAddress: recipient.EncodeAddress(), Amount: decimal.NewFromFloat(0.004)}
+
+	packet, err := w.CreatePSBT(context.Background(), tx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(locks) != 1 || locks[0] {
+		t.Fatalf("expected the spent output to be locked, got %v", locks)
+	}
+
+	details, err := w.InspectPSBT(packet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// one input and two outputs pay 11 + 68 + 2 * 31 = 141 satoshis
+	if details.Complete || len(details.Inputs) != 1 || details.Inputs[0].Signed || !details.Fee.Equal(decimal.New(141, -8)) {
+		t.Fatalf("unexpected unsigned psbt %+v", details)
+	}
+
+	if len(details.Outputs) != 2 || details.Outputs[0].Address != tx.ToAddress || details.Outputs[0].Change || !details.Outputs[1].Change || !details.Outputs[1].Amount.Equal(decimal.New(599_859, -8)) {
+		t.Errorf("unexpected outputs %+v %+v", details.Outputs[0], details.Outputs[1])
+	}
+
+	if _, err := w.FinalizePSBT(packet); err == nil {
+		t.Error("expected an unsigned psbt to not be finalized")
+	}
+
+	signed := signPSBT(t, packet, key)
+
+	if details, err := w.InspectPSBT(signed); err != nil || !details.Inputs[0].Signed {
+		t.Fatalf("expected the input to be signed: %v", err)
+	}
+
+	forged, err := psbt.NewFromRawBytes(strings.NewReader(signed), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := forged.Inputs[0].PartialSigs[0].Signature
+	sig[len(sig)-2] ^= 0x01
+	forgedPacket, _ := forged.B64Encode()
+
+	if _, err := w.BroadcastPSBT(context.Background(), tx, packet, forgedPacket); err == nil || rawTx != "" || len(locks) != 1 {
+		t.Fatal("expected a psbt with an invalid signature to be rejected")
+	}
+
+	if _, err := w.BroadcastPSBT(context.Background(), tx, packet, signed); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(locks) != 2 || !locks[1] {
+		t.Errorf("expected the spent output to be unlocked once broadcast, got %v", locks)
+	}
+
+	if tx.TxHash.String != "txid" || !tx.Fee.Decimal.Equal(decimal.New(141, -8)) || tx.Inputs[0] != strings.Repeat("ab", 32)+":1" {
+		t.Errorf("unexpected transaction %+v", tx)
+	}
+
+	raw, _ := hex.DecodeString(rawTx)
+	sentTx := new(wire.MsgTx)
+	if err := sentTx.Deserialize(bytes.NewReader(raw)); err != nil {
+		t.Fatal(err)
+	}
+
+	engine, err := txscript.NewEngine(script, sentTx, 0, txscript.StandardVerifyFlags, nil, nil, 1_000_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := engine.Execute(); err != nil {
+		t.Errorf("broadcast transaction does not spend its input: %v", err)
+	}
+}
+
+func TestWallet_AbandonPSBT(t *testing.T) {
+	var unlocked []map[string]interface{}
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"lockunspent": func(params []json.RawMessage) interface{} {
+			var unlock bool
+			json.Unmarshal(params[0], &unlock)
+			if unlock {
+				json.Unmarshal(params[1], &unlocked)
+			}
+
+			return true
+		},
+	})
+	defer server.Close()
+
+	w := newTestWallet(t, server.URL, map[string]interface{}{"network": "regtest"}).(*Wallet)
+
+	unsignedTx := wire.NewMsgTx(wire.TxVersion)
+	unsignedTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(chaincfg.RegressionNetParams.GenesisHash, 3), nil, nil))
+	unsignedTx.AddTxOut(wire.NewTxOut(1000, []byte{txscript.OP_TRUE}))
+
+	p, err := psbt.NewFromUnsignedTx(unsignedTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Inputs[0].WitnessUtxo = wire.NewTxOut(2000, []byte{txscript.OP_TRUE})
+
+	packet, _ := p.B64Encode()
+	if err := w.AbandonPSBT(context.Background(), packet); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(unlocked) != 1 || unlocked[0]["txid"] != chaincfg.RegressionNetParams.GenesisHash.String() || unlocked[0]["vout"] != float64(3) {
+		t.Errorf("unexpected unlocked outputs %v", unlocked)
+	}
+}
+
+func TestWallet_BroadcastPSBTWrongRecipient(t *testing.T) {
+	w := newTestWallet(t, "http://127.0.0.1:0", map[string]interface{}{"network": "regtest"}).(*Wallet)
+
+	unsignedTx := wire.NewMsgTx(wire.TxVersion)
+	unsignedTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(chaincfg.RegressionNetParams.GenesisHash, 0), nil, nil))
+	unsignedTx.AddTxOut(wire.NewTxOut(1000, []byte{txscript.OP_TRUE}))
+
+	p, err := psbt.NewFromUnsignedTx(unsignedTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Inputs[0].WitnessUtxo = wire.NewTxOut(2000, []byte{txscript.OP_TRUE})
+
+	packet, _ := p.B64Encode()
+	if _, err := w.BroadcastPSBT(context.Background(), &transaction.Transaction{ToAddress: "bcrt1qqqd8hdc684cqpm5ydfd535eygxlmh54wysmzry"}, packet, packet); err == nil {
+		t.Error("expected a psbt not paying the recipient to be rejected")
+	}
+}
+
+func TestWallet_BroadcastPSBTTampered(t *testing.T) {
+	w := newTestWallet(t, "http://127.0.0.1:0", map[string]interface{}{"network": "regtest"}).(*Wallet)
+
+	recipient := "bcrt1qqqd8hdc684cqpm5ydfd535eygxlmh54wysmzry"
+	script, err := w.payToAddress(recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newPacket := func(amount int64) string {
+		unsignedTx := wire.NewMsgTx(wire.TxVersion)
+		unsignedTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(chaincfg.RegressionNetParams.GenesisHash, 0), nil, nil))
+		unsignedTx.AddTxOut(wire.NewTxOut(amount, script))
+
+		p, err := psbt.NewFromUnsignedTx(unsignedTx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.Inputs[0].WitnessUtxo = wire.NewTxOut(2000, []byte{txscript.OP_TRUE})
+
+		packet, _ := p.B64Encode()
+
+		return packet
+	}
+
+	tx := &transaction.Transaction{ToAddress: recipient, Amount: decimal.New(1000, -8)}
+	if _, err := w.BroadcastPSBT(context.Background(), tx, newPacket(1000), newPacket(900)); err == nil {
+		t.Error("expected a psbt paying another amount than the created one to be rejected")
+	}
+}
+
+func TestWallet_CreatePSBTWithoutChangeAddress(t *testing.T) {
+	w := NewWallet()
+	if err := w.Configure(&wallet.Setting{
+		Wallet:   &wallet.SettingWallet{URI: "http://127.0.0.1:0"},
+		Currency: &currency.Currency{ID: "BTC", Subunits: 8, Options: map[string]interface{}{"network": "regtest"}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := w.(*Wallet).CreatePSBT(context.Background(), &transaction.Transaction{ToAddress: "bcrt1qqqd8hdc684cqpm5ydfd535eygxlmh54wysmzry", Amount: decimal.NewFromFloat(0.001)}, nil)
+	if !errors.Is(err, errors.ErrInvalidConfig) {
+		t.Errorf("expected invalid config error, got %v", err)
+	}
+}
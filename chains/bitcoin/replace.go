@@ -0,0 +1,197 @@
+package bitcoin
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+	"math"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/shopspring/decimal"
+	"github.com/volatiletech/null/v9"
+
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/transaction"
+	"github.com/zsmartex/multichain/pkg/wallet"
+)
+
+// replaceableSequence the sequence of the inputs of transactions signaling BIP125
+const replaceableSequence = wire.MaxTxInSequenceNum - 2
+
+// incrementalFeeRate satoshis per virtual byte a replacement pay on top of the original fee rate, see BIP125
+const incrementalFeeRate = 1
+
+// replaceable return whether transactions signal BIP125 so their fee can be bumped, default to true
+func (w *Wallet) replaceable(options map[string]interface{}) bool {
+	if replaceable, ok := options["replaceable"].(bool); ok {
+		return replaceable
+	}
+
+	if replaceable, ok := w.currency.Options["replaceable"].(bool); ok {
+		return replaceable
+	}
+
+	return true
+}
+
+// SpeedUpTransaction replace tx with bumpfee at newRate, the fee rate is raised to what BIP125 require
+// when newRate is not enough, the returned transaction is linked to tx with its ReplacedTxHash
+func (w *Wallet) SpeedUpTransaction(ctx context.Context, tx *transaction.Transaction, newRate wallet.GasPriceRate) (*transaction.Transaction, error) {
+	original, err := w.pendingTransaction(ctx, tx.TxHash.String)
+	if err != nil {
+		return nil, err
+	}
+
+	feeRate, err := w.feeRate(ctx, map[string]interface{}{"gas_rate": newRate})
+	if err != nil {
+		return nil, err
+	}
+
+	vsize := virtualSize(original.MsgTx)
+	originalFee := original.Fee.Abs().Shift(8).IntPart()
+	minRate := math.Ceil(float64(originalFee+incrementalFeeRate*vsize)*1000/float64(vsize)) / 1000
+	feeRate = math.Max(feeRate, minRate)
+
+	var resp struct {
+		TxID string          `json:"txid"`
+		Fee  decimal.Decimal `json:"fee"`
+	}
+	if err := w.client.CallOnce(ctx, &resp, "bumpfee", tx.TxHash.String, map[string]interface{}{
+		"fee_rate": formatFeeRate(feeRate),
+	}); err != nil {
+		return nil, err
+	}
+
+	replacement := *tx
+	replacement.Fee = decimal.NewNullDecimal(resp.Fee)
+	replacement.Status = transaction.StatusPending
+	replacement.TxHash = null.StringFrom(resp.TxID)
+	replacement.ReplacedTxHash = tx.TxHash
+
+	return &replacement, nil
+}
+
+// ChildPaysForParent accelerate tx by spending its change output back to the wallet with a fee paying
+// for both transactions at newRate, the returned transaction is linked to tx with its ParentTxHash
+func (w *Wallet) ChildPaysForParent(ctx context.Context, tx *transaction.Transaction, newRate wallet.GasPriceRate) (*transaction.Transaction, error) {
+	parent, err := w.pendingTransaction(ctx, tx.TxHash.String)
+	if err != nil {
+		return nil, err
+	}
+
+	change, err := w.changeOutput(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	feeRate, err := w.feeRate(ctx, map[string]interface{}{"gas_rate": newRate})
+	if err != nil {
+		return nil, err
+	}
+
+	// the child pay what the parent is missing to reach feeRate for both transactions
+	params := SelectionParams{FeeRate: feeRate}
+	childFee := params.Fee([]*UTXO{change}, 1)
+	packageFee := int64(math.Ceil(float64(virtualSize(parent.MsgTx)+txOverheadVSize+change.InputVSize()+outputVSize)*feeRate)) - parent.Fee.Abs().Shift(8).IntPart()
+	if packageFee > childFee {
+		childFee = packageFee
+	}
+
+	amount := change.Satoshis() - childFee
+	if amount < dustThreshold {
+		return nil, errors.Wrap(errors.ErrInsufficientFunds, fmt.Errorf("change of %s can't pay a fee of %d satoshis", tx.TxHash.String, childFee))
+	}
+
+	changeAddress, err := w.changeAddress(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	txid, err := w.signAndSend(ctx, []*UTXO{change}, []map[string]decimal.Decimal{
+		{changeAddress: decimal.New(amount, -8)},
+	}, true)
+	if err != nil {
+		return nil, err
+	}
+
+	return &transaction.Transaction{
+		Currency:     w.currency.ID,
+		CurrencyFee:  w.currency.ID,
+		FromAddress:  change.Address,
+		ToAddress:    changeAddress,
+		Amount:       decimal.New(amount, -8),
+		Fee:          decimal.NewNullDecimal(decimal.New(childFee, -8)),
+		TxHash:       null.StringFrom(txid),
+		ParentTxHash: tx.TxHash,
+		Position:     null.Int64From(0),
+		Inputs:       formatInputs([]*UTXO{change}),
+		Status:       transaction.StatusPending,
+	}, nil
+}
+
+// pendingWalletTransaction is an unconfirmed transaction sent by the wallet of the node
+type pendingWalletTransaction struct {
+	*WalletTransaction
+	MsgTx *wire.MsgTx
+}
+
+func (w *Wallet) pendingTransaction(ctx context.Context, hash string) (*pendingWalletTransaction, error) {
+	var resp *WalletTransaction
+	if err := w.client.Call(ctx, &resp, "gettransaction", hash, true); err != nil {
+		return nil, err
+	}
+
+	// a negative number of confirmations mean a conflicting transaction is mined
+	if resp.Confirmations != 0 {
+		return nil, errors.Wrap(errors.ErrNonceConflict, fmt.Errorf("transaction %s is already mined", hash))
+	}
+
+	if len(resp.ReplacedByTxID) > 0 {
+		return nil, errors.Wrap(errors.ErrNonceConflict, fmt.Errorf("transaction %s is already replaced by %s", hash, resp.ReplacedByTxID))
+	}
+
+	raw, err := hex.DecodeString(resp.Hex)
+	if err != nil {
+		return nil, err
+	}
+
+	msgTx := new(wire.MsgTx)
+	if err := msgTx.Deserialize(bytes.NewReader(raw)); err != nil {
+		return nil, err
+	}
+
+	return &pendingWalletTransaction{WalletTransaction: resp, MsgTx: msgTx}, nil
+}
+
+// changeOutput return the largest unconfirmed output of tx which the wallet can spend and is not paid to its recipient
+func (w *Wallet) changeOutput(ctx context.Context, tx *transaction.Transaction) (*UTXO, error) {
+	var utxos []*UTXO
+	if err := w.client.Call(ctx, &utxos, "listunspent", 0, 0); err != nil {
+		return nil, err
+	}
+
+	var change *UTXO
+	for _, utxo := range utxos {
+		if utxo.TxID != tx.TxHash.String || !utxo.Spendable || utxo.Address == tx.ToAddress {
+			continue
+		}
+
+		if change == nil || utxo.Satoshis() > change.Satoshis() {
+			change = utxo
+		}
+	}
+
+	if change == nil {
+		return nil, fmt.Errorf("transaction %s has no change output to spend", tx.TxHash.String)
+	}
+
+	return change, nil
+}
+
+// virtualSize return the size of tx in virtual bytes, witness data count for a quarter
+func virtualSize(tx *wire.MsgTx) int64 {
+	weight := tx.SerializeSizeStripped()*3 + tx.SerializeSize()
+
+	return int64((weight + 3) / 4)
+}
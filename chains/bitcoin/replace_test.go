@@ -0,0 +1,251 @@
+package bitcoin
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/shopspring/decimal"
+	"github.com/volatiletech/null/v9"
+
+	"github.com/zsmartex/multichain/internal/rpctest"
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/transaction"
+	"github.com/zsmartex/multichain/pkg/wallet"
+)
+
+// newParentHex return a P2WPKH transaction with one input and two outputs, its virtual size is 141 vbytes
+func newParentHex(t *testing.T) string {
+	tx := wire.NewMsgTx(wire.TxVersion)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil, [][]byte{make([]byte, 72), make([]byte, 33)}))
+	tx.AddTxOut(wire.NewTxOut(400_000, append([]byte{0x00, 0x14}, make([]byte, 20)...)))
+	tx.AddTxOut(wire.NewTxOut(100_000, append([]byte{0x00, 0x14}, make([]byte, 20)...)))
+
+	var buf bytes.Buffer
+	if err := tx.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	return hex.EncodeToString(buf.Bytes())
+}
+
+func newParentResult(t *testing.T, confirmations int64) map[string]interface{} {
+	return map[string]interface{}{
+		"txid":          "parent",
+		"confirmations": confirmations,
+		"fee":           -0.00000141,
+		"hex":           newParentHex(t),
+	}
+}
+
+// newFeeEstimates answer estimatesmartfee with 10 sat/vB for 2 blocks and 1 sat/vB otherwise
+func newFeeEstimates(params []json.RawMessage) interface{} {
+	if string(params[0]) == "2" {
+		return map[string]interface{}{"feerate": 0.0001, "blocks": 2}
+	}
+
+	return map[string]interface{}{"feerate": 0.00001, "blocks": 6}
+}
+
+func TestWallet_SpeedUpTransaction(t *testing.T) {
+	var feeRates []decimal.Decimal
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"gettransaction":   newParentResult(t, 0),
+		"estimatesmartfee": newFeeEstimates,
+		"bumpfee": func(params []json.RawMessage) interface{} {
+			var options struct {
+				FeeRate decimal.Decimal `json:"fee_rate"`
+			}
+			json.Unmarshal(params[1], &options)
+			feeRates = append(feeRates, options.FeeRate)
+
+			return map[string]interface{}{"txid": "replacement", "origfee": 0.00000141, "fee": 0.00000282}
+		},
+	})
+	defer server.Close()
+
+	w := newTestWallet(t, server.URL, nil).(*Wallet)
+	tx := &transaction.Transaction{TxHash: null.StringFrom("parent"), ToAddress: "bcrt1qalice", Amount: decimal.NewFromFloat(0.004)}
+
+	replacement, err := w.SpeedUpTransaction(context.Background(), tx, wallet.GasPriceRateStandard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if replacement.TxHash.String != "replacement" || replacement.ReplacedTxHash.String != "parent" || !replacement.Fee.Decimal.Equal(decimal.New(282, -8)) {
+		t.Errorf("unexpected replacement %+v", replacement)
+	}
+
+	if tx.TxHash.String != "parent" {
+		t.Error("expected the original transaction to be left untouched")
+	}
+
+	if _, err := w.SpeedUpTransaction(context.Background(), tx, wallet.GasPriceRateFast); err != nil {
+		t.Fatal(err)
+	}
+
+	// the standard estimate is raised to the original rate plus the incremental rate
+	if len(feeRates) != 2 || !feeRates[0].Equal(decimal.NewFromInt(2)) || !feeRates[1].Equal(decimal.NewFromInt(10)) {
+		t.Errorf("unexpected fee rates %v", feeRates)
+	}
+}
+
+func TestWallet_SpeedUpMinedTransaction(t *testing.T) {
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"gettransaction": newParentResult(t, 1),
+	})
+	defer server.Close()
+
+	w := newTestWallet(t, server.URL, nil).(*Wallet)
+
+	_, err := w.SpeedUpTransaction(context.Background(), &transaction.Transaction{TxHash: null.StringFrom("parent")}, wallet.GasPriceRateFast)
+	if !errors.Is(err, errors.ErrNonceConflict) {
+		t.Errorf("expected a nonce conflict, got %v", err)
+	}
+}
+
+func TestWallet_ChildPaysForParent(t *testing.T) {
+	var inputs []map[string]interface{}
+	var outputs []map[string]decimal.Decimal
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"gettransaction":   newParentResult(t, 0),
+		"estimatesmartfee": newFeeEstimates,
+		"listunspent": []map[string]interface{}{
+			{"txid": "parent", "vout": 0, "address": "bcrt1qalice", "amount": 0.004, "spendable": true, "safe": false},
+			{"txid": "parent", "vout": 1, "address": "bcrt1qchange", "amount": 0.001, "spendable": true, "safe": false},
+			{"txid": "other", "vout": 0, "address": "bcrt1qchange", "amount": 1, "spendable": true, "safe": false},
+		},
+		"createrawtransaction": func(params []json.RawMessage) interface{} {
+			json.Unmarshal(params[0], &inputs)
+			json.Unmarshal(params[1], &outputs)
+
+			return "raw"
+		},
+		"signrawtransactionwithwallet": map[string]interface{}{"hex": "signed", "complete": true},
+		"sendrawtransaction":           "child",
+	})
+	defer server.Close()
+
+	w := newTestWallet(t, server.URL, map[string]interface{}{"change_address": "bcrt1qchange"}).(*Wallet)
+	tx := &transaction.Transaction{TxHash: null.StringFrom("parent"), ToAddress: "bcrt1qalice", Amount: decimal.NewFromFloat(0.004)}
+
+	child, err := w.ChildPaysForParent(context.Background(), tx, wallet.GasPriceRateFast)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(inputs) != 1 || inputs[0]["txid"] != "parent" || inputs[0]["vout"] != float64(1) {
+		t.Fatalf("expected the change output to be spent, got %v", inputs)
+	}
+
+	// both transactions weight 141 + 110 vbytes, at 10 sat/vB the child pay 2510 satoshis minus the 141 paid by the parent
+	if !child.Fee.Decimal.Equal(decimal.New(2369, -8)) || !outputs[0]["bcrt1qchange"].Equal(decimal.New(97_631, -8)) {
+		t.Errorf("unexpected child fee %s paying %v", child.Fee.Decimal, outputs)
+	}
+
+	if child.TxHash.String != "child" || child.ParentTxHash.String != "parent" {
+		t.Errorf("unexpected child %+v", child)
+	}
+}
+
+func TestBlockchain_GetReplacedTransaction(t *testing.T) {
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"getrawtransaction": func(params []json.RawMessage) interface{} {
+			if strings.Contains(string(params[0]), "original") {
+				return &rpctest.Error{Code: -5, Message: "No such mempool or blockchain transaction. Use gettransaction for wallet transactions."}
+			}
+
+			return map[string]interface{}{
+				"txid":          "replacement",
+				"confirmations": 1,
+				"vin":           []interface{}{},
+				"vout": []map[string]interface{}{
+					{"value": 0.002, "n": 0, "scriptPubKey": map[string]interface{}{"addresses": []string{"bcrt1qdeposit"}}},
+				},
+			}
+		},
+		"gettransaction": func(params []json.RawMessage) interface{} {
+			if strings.Contains(string(params[0]), "original") {
+				return map[string]interface{}{
+					"txid":            "original",
+					"confirmations":   -1,
+					"walletconflicts": []string{"replacement"},
+					"details": []map[string]interface{}{
+						{"address": "bcrt1qdeposit", "category": "receive", "amount": 0.002, "vout": 1},
+					},
+				}
+			}
+
+			return map[string]interface{}{"txid": "replacement", "confirmations": 1, "walletconflicts": []string{"original"}}
+		},
+	})
+	defer server.Close()
+
+	bl := newTestBlockchain(t, server.URL)
+
+	original, err := bl.GetTransaction(context.Background(), "original")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if original.Status != transaction.StatusFailed || original.ToAddress != "bcrt1qdeposit" || original.Position.Int64 != 1 || original.ReplacedByTxHash.String != "replacement" {
+		t.Errorf("expected the replaced deposit to fail, got %+v", original)
+	}
+
+	replacement, err := bl.GetTransaction(context.Background(), "replacement")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if replacement.ReplacedTxHash.String != "original" || replacement.Status != transaction.StatusSucceed {
+		t.Errorf("expected the replacement to be linked to the original deposit, got %+v", replacement)
+	}
+}
+
+func TestBlockchain_GetBlockReplacements(t *testing.T) {
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"getblockhash": "block",
+		"getblock": map[string]interface{}{
+			"hash":              "block",
+			"previousblockhash": "parent",
+			"height":            101,
+			"confirmations":     1,
+			"tx": []map[string]interface{}{
+				{
+					"txid": "replacement",
+					"vin":  []interface{}{},
+					"vout": []map[string]interface{}{
+						{"value": 0.002, "n": 0, "scriptPubKey": map[string]interface{}{"addresses": []string{"bcrt1qdeposit"}}},
+					},
+				},
+			},
+		},
+		"listsinceblock": func(params []json.RawMessage) interface{} {
+			if string(params[0]) != `"parent"` {
+				t.Errorf("expected the wallet transactions since the parent block, got %s", params[0])
+			}
+
+			return map[string]interface{}{
+				"transactions": []map[string]interface{}{
+					{"txid": "replacement", "blockhash": "block", "confirmations": 1, "walletconflicts": []string{"original"}},
+					{"txid": "later", "blockhash": "child", "confirmations": 0, "walletconflicts": []string{"other"}},
+				},
+			}
+		},
+	})
+	defer server.Close()
+
+	blk, err := newTestBlockchain(t, server.URL).GetBlockByNumber(context.Background(), 101)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(blk.Transactions) != 1 || blk.Transactions[0].ReplacedTxHash.String != "original" {
+		t.Errorf("expected the replacement to be linked to the original deposit, got %+v", blk.Transactions)
+	}
+}
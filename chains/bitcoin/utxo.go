@@ -0,0 +1,363 @@
+package bitcoin
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/shopspring/decimal"
+	"github.com/volatiletech/null/v9"
+
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/transaction"
+)
+
+// output is a recipient of a transaction built from selected utxos
+type output struct {
+	Address     string
+	Amount      int64 // satoshis
+	SubtractFee bool  // the recipient pay its share of the fee
+}
+
+// fundedTransaction is a transaction built from selected utxos and sent
+type fundedTransaction struct {
+	TxID    string
+	Inputs  []*UTXO
+	Fee     int64
+	Amounts []int64 // satoshis received by each output once the fee is subtracted
+}
+
+// SetCoinSelector use selector to pick the utxos spent by transactions instead of the coin_selection option
+func (w *Wallet) SetCoinSelector(selector CoinSelector) {
+	w.selector = selector
+}
+
+// coinSelector return nil when transactions are left to the wallet of the node
+func (w *Wallet) coinSelector() CoinSelector {
+	if w.selector != nil {
+		return w.selector
+	}
+
+	if name, ok := w.currency.Options["coin_selection"].(string); ok {
+		return coinSelectors[name]
+	}
+
+	return nil
+}
+
+func (w *Wallet) createUTXOTransaction(ctx context.Context, tx *transaction.Transaction, options map[string]interface{}, selector CoinSelector) (*transaction.Transaction, error) {
+	var subtractFee bool
+	if options["subtract_fee"] != nil {
+		subtractFee = options["subtract_fee"].(bool)
+	}
+
+	funded, err := w.sendOutputs(ctx, []*output{
+		{Address: tx.ToAddress, Amount: tx.Amount.Shift(8).IntPart(), SubtractFee: subtractFee},
+	}, selector, options)
+	if err != nil {
+		return nil, err
+	}
+
+	tx.Amount = decimal.New(funded.Amounts[0], -8)
+	tx.Fee = decimal.NewNullDecimal(decimal.New(funded.Fee, -8))
+	tx.Inputs = formatInputs(funded.Inputs)
+	tx.Status = transaction.StatusPending
+	tx.TxHash = null.StringFrom(funded.TxID)
+
+	return tx, nil
+}
+
+// sendOutputs fund outputs with the utxos picked by selector, the change go to the change address
+func (w *Wallet) sendOutputs(ctx context.Context, outputs []*output, selector CoinSelector, options map[string]interface{}) (*fundedTransaction, error) {
+	utxos, err := w.listUnspent(ctx, false)
+	if err != nil {
+		return nil, err
+	}
+
+	funded, rawOutputs, err := w.fundOutputs(ctx, outputs, utxos, selector, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// the inputs are locked until the transaction is broadcast so a concurrent send can't select them,
+	// the node refuse to lock an output twice
+	if err := w.lockUnspent(ctx, false, funded.Inputs); err != nil {
+		return nil, err
+	}
+
+	signedTx, err := w.sign(ctx, funded.Inputs, rawOutputs, w.replaceable(options))
+	if err != nil {
+		w.lockUnspent(ctx, true, funded.Inputs)
+		return nil, err
+	}
+
+	funded.TxID, err = w.sendRawTransaction(ctx, signedTx)
+	if err != nil {
+		// the inputs stay locked when the node may have got the transaction
+		if isRejected(err) {
+			w.lockUnspent(ctx, true, funded.Inputs)
+		}
+
+		return nil, err
+	}
+
+	// the spent outputs are not listed anymore while the transaction is in the mempool
+	w.lockUnspent(ctx, true, funded.Inputs)
+
+	return funded, nil
+}
+
+// lockUnspent lock utxos so they are not spent, or unlock them
+func (w *Wallet) lockUnspent(ctx context.Context, unlock bool, utxos []*UTXO) error {
+	outpoints := make([]map[string]interface{}, len(utxos))
+	for i, utxo := range utxos {
+		outpoints[i] = map[string]interface{}{"txid": utxo.TxID, "vout": utxo.Vout}
+	}
+
+	var locked bool
+	return w.client.Call(ctx, &locked, "lockunspent", unlock, outpoints)
+}
+
+// fundOutputs pick the utxos paying outputs and return the outputs of the transaction with the change
+func (w *Wallet) fundOutputs(ctx context.Context, outputs []*output, utxos []*UTXO, selector CoinSelector, options map[string]interface{}) (*fundedTransaction, []map[string]decimal.Decimal, error) {
+	feeRate, err := w.feeRate(ctx, options)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	total := int64(0)
+	subtracting := 0
+	for _, o := range outputs {
+		total += o.Amount
+		if o.SubtractFee {
+			subtracting++
+		}
+	}
+
+	params := SelectionParams{Amount: total, Outputs: len(outputs), FeeRate: feeRate}
+	// the recipients pay the fee so the inputs only have to cover the amount
+	selection := params
+	if subtracting > 0 {
+		selection.FeeRate = 0
+	}
+
+	inputs, err := selector.Select(utxos, selection)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	inputTotal := int64(0)
+	for _, input := range inputs {
+		inputTotal += input.Satoshis()
+	}
+
+	fee := params.Fee(inputs, len(outputs)+1)
+	change := inputTotal - total
+	if subtracting == 0 {
+		change -= fee
+	}
+
+	if change < dustThreshold {
+		fee = params.Fee(inputs, len(outputs))
+		if subtracting == 0 {
+			change = inputTotal - total - fee
+		}
+
+		if change < 0 {
+			return nil, nil, errors.ErrInsufficientFunds
+		}
+
+		// the dust is left to the miner
+		fee += change
+		change = 0
+	}
+
+	amounts := make([]int64, len(outputs))
+	for i, o := range outputs {
+		amounts[i] = o.Amount
+	}
+
+	if subtracting > 0 {
+		splitFee(outputs, amounts, fee-(inputTotal-total-change))
+	}
+
+	rawOutputs := make([]map[string]decimal.Decimal, 0, len(outputs)+1)
+	for i, o := range outputs {
+		if amounts[i] < dustThreshold {
+			return nil, nil, fmt.Errorf("amount sent to %s is too small to pay the fee", o.Address)
+		}
+
+		rawOutputs = append(rawOutputs, map[string]decimal.Decimal{o.Address: decimal.New(amounts[i], -8)})
+	}
+
+	if change > 0 {
+		changeAddress, err := w.changeAddress(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		rawOutputs = append(rawOutputs, map[string]decimal.Decimal{changeAddress: decimal.New(change, -8)})
+	}
+
+	return &fundedTransaction{
+		Inputs:  inputs,
+		Fee:     fee,
+		Amounts: amounts,
+	}, rawOutputs, nil
+}
+
+// splitFee subtract fee from the outputs paying it
+func splitFee(outputs []*output, amounts []int64, fee int64) {
+	for i, share := range feeShares(outputs, fee) {
+		amounts[i] -= share
+	}
+}
+
+// feeShares return the part of fee paid by each output, it's split equally between
+// the outputs with SubtractFee and the remainder is paid by the first one
+func feeShares(outputs []*output, fee int64) []int64 {
+	shares := make([]int64, len(outputs))
+	payers := make([]int, 0)
+	for i, o := range outputs {
+		if o.SubtractFee {
+			payers = append(payers, i)
+		}
+	}
+
+	if len(payers) == 0 {
+		return shares
+	}
+
+	share := fee / int64(len(payers))
+	for _, i := range payers {
+		shares[i] = share
+	}
+	shares[payers[0]] += fee - share*int64(len(payers))
+
+	return shares
+}
+
+// signAndSend sign the transaction with the wallet of the node and send it
+func (w *Wallet) signAndSend(ctx context.Context, inputs []*UTXO, outputs []map[string]decimal.Decimal, replaceable bool) (string, error) {
+	signedTx, err := w.sign(ctx, inputs, outputs, replaceable)
+	if err != nil {
+		return "", err
+	}
+
+	return w.sendRawTransaction(ctx, signedTx)
+}
+
+// sign return the transaction signed with the wallet of the node, replaceable signal BIP125 so its fee can be bumped
+func (w *Wallet) sign(ctx context.Context, inputs []*UTXO, outputs []map[string]decimal.Decimal, replaceable bool) (string, error) {
+	rawInputs := make([]map[string]interface{}, len(inputs))
+	for i, input := range inputs {
+		rawInputs[i] = map[string]interface{}{"txid": input.TxID, "vout": input.Vout}
+	}
+
+	var rawTx string
+	if err := w.client.Call(ctx, &rawTx, "createrawtransaction", rawInputs, outputs, 0, replaceable); err != nil {
+		return "", err
+	}
+
+	var signed struct {
+		Hex      string `json:"hex"`
+		Complete bool   `json:"complete"`
+	}
+	if err := w.client.Call(ctx, &signed, "signrawtransactionwithwallet", rawTx); err != nil {
+		return "", err
+	}
+
+	if !signed.Complete {
+		return "", errors.New("failed to sign every input of the transaction")
+	}
+
+	return signed.Hex, nil
+}
+
+// sendRawTransaction broadcast a signed transaction and return its hash,
+// a transaction the node already has was broadcast by a previous attempt so it's a success
+func (w *Wallet) sendRawTransaction(ctx context.Context, rawTx string) (string, error) {
+	var txid string
+	err := w.client.CallOnce(ctx, &txid, "sendrawtransaction", rawTx)
+	if err == nil || !errors.Is(err, errors.ErrAlreadyBroadcast) {
+		return txid, err
+	}
+
+	raw, decodeErr := hex.DecodeString(rawTx)
+	if decodeErr != nil {
+		return "", decodeErr
+	}
+
+	msgTx := new(wire.MsgTx)
+	if decodeErr := msgTx.Deserialize(bytes.NewReader(raw)); decodeErr != nil {
+		return "", decodeErr
+	}
+
+	return msgTx.TxHash().String(), nil
+}
+
+// listUnspent return the spendable outputs of the wallet address, or of the whole wallet when it's not set,
+// watchOnly include the outputs the node can't sign, like the ones of an address added with importaddress
+func (w *Wallet) listUnspent(ctx context.Context, watchOnly bool) ([]*UTXO, error) {
+	params := []interface{}{1, 9_999_999}
+	if len(w.wallet.Address) > 0 {
+		params = append(params, []string{w.wallet.Address})
+	}
+
+	var utxos []*UTXO
+	if err := w.client.Call(ctx, &utxos, "listunspent", params...); err != nil {
+		return nil, err
+	}
+
+	spendable := make([]*UTXO, 0, len(utxos))
+	for _, utxo := range utxos {
+		if utxo.Safe && (utxo.Spendable || watchOnly) {
+			spendable = append(spendable, utxo)
+		}
+	}
+
+	return spendable, nil
+}
+
+// changeAddress return the change_address option, the wallet address or a new change address of the node
+func (w *Wallet) changeAddress(ctx context.Context) (string, error) {
+	if address, ok := w.currency.Options["change_address"].(string); ok && len(address) > 0 {
+		return address, nil
+	}
+
+	if len(w.wallet.Address) > 0 {
+		return w.wallet.Address, nil
+	}
+
+	var address string
+	if err := w.client.Call(ctx, &address, "getrawchangeaddress"); err != nil {
+		return "", err
+	}
+
+	return address, nil
+}
+
+func numberOption(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
+
+// formatInputs return the outpoints of inputs as txid:vout
+func formatInputs(inputs []*UTXO) []string {
+	outpoints := make([]string, len(inputs))
+	for i, input := range inputs {
+		outpoints[i] = fmt.Sprintf("%s:%d", input.TxID, input.Vout)
+	}
+
+	return outpoints
+}
@@ -0,0 +1,196 @@
+package bitcoin
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/txscript"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/multichain/internal/rpctest"
+	"github.com/zsmartex/multichain/pkg/currency"
+	"github.com/zsmartex/multichain/pkg/transaction"
+	"github.com/zsmartex/multichain/pkg/wallet"
+)
+
+func TestWallet_CreateUTXOTransaction(t *testing.T) {
+	var inputs []map[string]interface{}
+	var outputs []map[string]decimal.Decimal
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"listunspent": []map[string]interface{}{
+			{"txid": "aa", "vout": 0, "amount": 0.0001, "spendable": true, "safe": true},
+			{"txid": "bb", "vout": 1, "amount": 0.01, "spendable": true, "safe": true},
+			{"txid": "cc", "vout": 0, "amount": 1, "spendable": true, "safe": false},
+		},
+		"createrawtransaction": func(params []json.RawMessage) interface{} {
+			json.Unmarshal(params[0], &inputs)
+			json.Unmarshal(params[1], &outputs)
+
+			return "raw"
+		},
+		"signrawtransactionwithwallet": map[string]interface{}{"hex": "signed", "complete": true},
+		"sendrawtransaction":           "txid",
+		"lockunspent":                  true,
+	})
+	defer server.Close()
+
+	w := newTestWallet(t, server.URL, map[string]interface{}{
+		"coin_selection": CoinSelectionLargestFirst,
+		"change_address": "bcrt1qchange",
+		"fee_rate":       2,
+	})
+
+	tx, err := w.CreateTransaction(context.Background(), &transaction.Transaction{
+		ToAddress: "bcrt1qrecipient",
+		Amount:    decimal.NewFromFloat(0.005),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tx.Inputs) != 1 || tx.Inputs[0] != "bb:1" || len(inputs) != 1 {
+		t.Errorf("unexpected inputs %v", tx.Inputs)
+	}
+
+	// one input and two outputs at 2 sat/vB
+	if tx.Fee.Decimal.String() != "0.00000282" || tx.TxHash.String != "txid" {
+		t.Errorf("unexpected fee %s or hash %s", tx.Fee.Decimal, tx.TxHash.String)
+	}
+
+	if len(outputs) != 2 || !outputs[0]["bcrt1qrecipient"].Equal(decimal.NewFromFloat(0.005)) || !outputs[1]["bcrt1qchange"].Equal(decimal.NewFromFloat(0.00499718)) {
+		t.Errorf("unexpected outputs %v", outputs)
+	}
+}
+
+func TestWallet_CreateUTXOTransactionSubtractFee(t *testing.T) {
+	var outputs []map[string]decimal.Decimal
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"listunspent": []map[string]interface{}{
+			{"txid": "aa", "vout": 0, "amount": 0.01, "spendable": true, "safe": true},
+		},
+		"createrawtransaction": func(params []json.RawMessage) interface{} {
+			json.Unmarshal(params[1], &outputs)
+
+			return "raw"
+		},
+		"signrawtransactionwithwallet": map[string]interface{}{"hex": "signed", "complete": true},
+		"sendrawtransaction":           "txid",
+		"lockunspent":                  true,
+	})
+	defer server.Close()
+
+	w := newTestWallet(t, server.URL, map[string]interface{}{"coin_selection": CoinSelectionBranchAndBound, "fee_rate": 1})
+
+	tx, err := w.CreateTransaction(context.Background(), &transaction.Transaction{
+		ToAddress: "bcrt1qrecipient",
+		Amount:    decimal.NewFromFloat(0.01),
+	}, map[string]interface{}{"subtract_fee": true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the whole output is spent without change and the recipient pay the fee
+	if len(outputs) != 1 || !outputs[0]["bcrt1qrecipient"].Equal(decimal.NewFromFloat(0.0099989)) || !tx.Amount.Equal(outputs[0]["bcrt1qrecipient"]) {
+		t.Errorf("unexpected outputs %v", outputs)
+	}
+}
+
+func TestWallet_ConfigureCoinSelection(t *testing.T) {
+	w := NewWallet()
+	err := w.Configure(&wallet.Setting{
+		Currency: &currency.Currency{ID: "BTC", Subunits: 8, Options: map[string]interface{}{"coin_selection": "random"}},
+	})
+	if err == nil {
+		t.Error("expected an error for an unknown coin selection")
+	}
+}
+
+func TestWallet_SendRawTransactionAlreadyBroadcast(t *testing.T) {
+	msgTx := wire.NewMsgTx(wire.TxVersion)
+	msgTx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{1}, 0), nil, nil))
+	msgTx.AddTxOut(wire.NewTxOut(1000, []byte{txscript.OP_TRUE}))
+
+	var buf bytes.Buffer
+	if err := msgTx.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"sendrawtransaction": &rpctest.Error{Code: -27, Message: "Transaction already in block chain"},
+	})
+	defer server.Close()
+
+	w := newTestWallet(t, server.URL, nil).(*Wallet)
+
+	txid, err := w.sendRawTransaction(context.Background(), hex.EncodeToString(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if txid != msgTx.TxHash().String() {
+		t.Errorf("expected hash %s, got %s", msgTx.TxHash(), txid)
+	}
+}
+
+func TestWallet_CreateUTXOTransactionLocksInputs(t *testing.T) {
+	tests := []struct {
+		name     string
+		send     interface{}
+		expected []bool
+	}{
+		{name: "sent", send: "txid", expected: []bool{false, true}},
+		{name: "rejected", send: &rpctest.Error{Code: -26, Message: "txn-mempool-conflict"}, expected: []bool{false, true}},
+		{name: "unavailable", send: &rpctest.Error{Code: -28, Message: "Loading block index..."}, expected: []bool{false, true}},
+		// the connection is dropped so the node may have got the transaction
+		{name: "unknown", send: rpctest.Handler(func([]json.RawMessage) interface{} { panic(http.ErrAbortHandler) }), expected: []bool{false}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var locks []bool
+			server := rpctest.NewServer(t, map[string]interface{}{
+				"listunspent": []map[string]interface{}{
+					{"txid": "aa", "vout": 0, "amount": 0.01, "spendable": true, "safe": true},
+				},
+				"createrawtransaction":         "raw",
+				"signrawtransactionwithwallet": map[string]interface{}{"hex": "signed", "complete": true},
+				"sendrawtransaction":           test.send,
+				"lockunspent": func(params []json.RawMessage) interface{} {
+					var unlock bool
+					json.Unmarshal(params[0], &unlock)
+					locks = append(locks, unlock)
+
+					return true
+				},
+			})
+			defer server.Close()
+
+			w := newTestWallet(t, server.URL, map[string]interface{}{
+				"coin_selection": CoinSelectionLargestFirst,
+				"change_address": "bcrt1qchange",
+				"fee_rate":       2,
+			})
+
+			w.CreateTransaction(context.Background(), &transaction.Transaction{
+				ToAddress: "bcrt1qrecipient",
+				Amount:    decimal.NewFromFloat(0.005),
+			}, nil)
+
+			if len(locks) != len(test.expected) {
+				t.Fatalf("expected lockunspent calls %v, got %v", test.expected, locks)
+			}
+
+			for i := range locks {
+				if locks[i] != test.expected[i] {
+					t.Errorf("expected lockunspent calls %v, got %v", test.expected, locks)
+				}
+			}
+		})
+	}
+}
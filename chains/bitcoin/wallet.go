@@ -2,82 +2,65 @@ package bitcoin
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
-	"math/rand"
+	"fmt"
 	"strings"
 
-	"github.com/go-resty/resty/v2"
 	"github.com/shopspring/decimal"
 	"github.com/volatiletech/null/v9"
 
 	"github.com/zsmartex/multichain/pkg/currency"
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/rpc"
 	"github.com/zsmartex/multichain/pkg/transaction"
 	"github.com/zsmartex/multichain/pkg/utils"
 	"github.com/zsmartex/multichain/pkg/wallet"
 )
 
 type Wallet struct {
-	client   *resty.Client
+	client   *rpc.Client
 	currency *currency.Currency
 	wallet   *wallet.SettingWallet
+	selector CoinSelector
 }
 
-func NewWallet() wallet.Wallet {
-	return &Wallet{
-		client: resty.New(),
-	}
+func init() {
+	wallet.Register("bitcoin", NewWallet)
 }
 
-func (w *Wallet) Configure(settings *wallet.Setting) {
-	if settings.Wallet != nil {
-		w.wallet = settings.Wallet
-	}
-
-	if settings.Currency != nil {
-		w.currency = settings.Currency
-	}
+func NewWallet() wallet.Wallet {
+	return &Wallet{}
 }
 
-func (w *Wallet) jsonRPC(ctx context.Context, resp interface{}, method string, params ...interface{}) error {
-	type Result struct {
-		Version string           `json:"version"`
-		ID      int              `json:"id"`
-		Result  *json.RawMessage `json:"result"`
-		Error   *json.RawMessage `json:"error"`
+func (w *Wallet) Configure(settings *wallet.Setting) error {
+	if err := settings.Validate(); err != nil {
+		return err
 	}
 
-	response, err := w.client.
-		R().
-		SetContext(ctx).
-		SetResult(Result{}).
-		SetHeaders(map[string]string{
-			"Accept":       "application/json",
-			"Content-Type": "application/json",
-		}).
-		SetBody(map[string]interface{}{
-			"version": "2.0",
-			"id":      rand.Int(),
-			"method":  method,
-			"params":  params,
-		}).Post(w.wallet.URI)
-
-	if err != nil {
-		return err
+	if settings.Wallet != nil {
+		w.wallet = settings.Wallet
+		w.client = rpc.NewClient(settings.Wallet.URI, rpc.WithErrorParser(parseRPCError), rpc.WithHealthCheck(rpc.DefaultHealthInterval, "getblockcount"))
 	}
 
-	result := response.Result().(*Result)
+	if settings.Currency != nil {
+		if name, ok := settings.Currency.Options["coin_selection"]; ok {
+			if _, ok := coinSelectors[fmt.Sprint(name)]; !ok {
+				return errors.NewConfigError("currency.options.coin_selection", "%v is not a known coin selection", name)
+			}
+		}
 
-	if result.Error != nil {
-		return errors.New("jsonRPC error: " + string(*result.Error))
-	}
+		if name, ok := settings.Currency.Options["network"]; ok {
+			if _, ok := networks[fmt.Sprint(name)]; !ok {
+				return errors.NewConfigError("currency.options.network", "%v is not a known network", name)
+			}
+		}
 
-	if result.Result == nil {
-		return errors.New("jsonRPC error: result is nil")
-	}
+		floor, hasFloor := numberOption(settings.Currency.Options["min_fee_rate"])
+		ceiling, hasCeiling := numberOption(settings.Currency.Options["max_fee_rate"])
+		if hasFloor && hasCeiling && floor > ceiling {
+			return errors.NewConfigError("currency.options.min_fee_rate", "%v is greater than max_fee_rate %v", floor, ceiling)
+		}
 
-	if err := json.Unmarshal(*result.Result, resp); err != nil {
-		return err
+		w.currency = settings.Currency
 	}
 
 	return nil
@@ -86,12 +69,16 @@ func (w *Wallet) jsonRPC(ctx context.Context, resp interface{}, method string, p
 func (w *Wallet) CreateAddress(ctx context.Context) (address, secret string, err error) {
 	secret = utils.RandomString(32)
 
-	err = w.jsonRPC(ctx, &address, "getnewaddress", secret)
+	err = w.client.Call(ctx, &address, "getnewaddress", secret)
 
 	return
 }
 
 func (w *Wallet) CreateTransaction(ctx context.Context, tx *transaction.Transaction, options map[string]interface{}) (*transaction.Transaction, error) {
+	if selector := w.coinSelector(); selector != nil {
+		return w.createUTXOTransaction(ctx, tx, options, selector)
+	}
+
 	var txid string
 	var subtractFee bool
 
@@ -99,12 +86,22 @@ func (w *Wallet) CreateTransaction(ctx context.Context, tx *transaction.Transact
 		subtractFee = options["subtract_fee"].(bool)
 	}
 
-	if err := w.jsonRPC(ctx, &txid, "sendtoaddress",
+	feeRate, err := w.feeRate(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := w.client.CallOnce(ctx, &txid, "sendtoaddress",
 		tx.ToAddress,
 		tx.Amount,
 		"",
 		"",
 		subtractFee,
+		w.replaceable(options),
+		nil,
+		"unset",
+		nil,
+		formatFeeRate(feeRate),
 	); err != nil {
 		return nil, err
 	}
@@ -112,13 +109,18 @@ func (w *Wallet) CreateTransaction(ctx context.Context, tx *transaction.Transact
 	tx.Status = transaction.StatusPending
 	tx.TxHash = null.StringFrom(txid)
 
+	// the transaction is already sent, it's returned without its fee when the node can't tell it
+	if fee, err := w.transactionFee(ctx, txid); err == nil {
+		tx.Fee = decimal.NewNullDecimal(fee)
+	}
+
 	return tx, nil
 }
 
 func (w *Wallet) LoadBalance(ctx context.Context) (balance decimal.Decimal, err error) {
 	var resp [][][]interface{}
 
-	err = w.jsonRPC(ctx, &resp, "listaddressgroupings")
+	err = w.client.Call(ctx, &resp, "listaddressgroupings")
 	if err != nil {
 		return
 	}
@@ -0,0 +1,253 @@
+package evm
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/multichain/pkg/currency"
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/rpc"
+)
+
+// multicall3Address the address of Multicall3 on most EVM chains, override it with the multicall_address option of the native currency
+var multicall3Address = "0xcA11bde05977b3631167028862bE2a173976CA11"
+
+var multicall3AbiDefinition = `[{"inputs":[{"components":[{"name":"target","type":"address"},{"name":"allowFailure","type":"bool"},{"name":"callData","type":"bytes"}],"name":"calls","type":"tuple[]"}],"name":"aggregate3","outputs":[{"components":[{"name":"success","type":"bool"},{"name":"returnData","type":"bytes"}],"name":"returnData","type":"tuple[]"}],"stateMutability":"payable","type":"function"},{"inputs":[{"name":"addr","type":"address"}],"name":"getEthBalance","outputs":[{"name":"balance","type":"uint256"}],"stateMutability":"view","type":"function"}]`
+
+const (
+	// multicallBatchSize the number of balances aggregated in a single eth_call
+	multicallBatchSize = 500
+	// balanceBatchSize the number of balance requests sent in a single batch without Multicall3
+	balanceBatchSize = 100
+)
+
+type multicall struct {
+	Target       common.Address
+	AllowFailure bool
+	CallData     []byte
+}
+
+type multicallResult struct {
+	Success    bool
+	ReturnData []byte
+}
+
+// balanceQuery is the balance of an address for a currency, the native balance is queried when contract is nil
+type balanceQuery struct {
+	currency *currency.Currency
+	address  string
+	contract *common.Address
+	data     []byte
+	balance  *big.Int
+}
+
+// GetBalancesOfAddresses return the balances of addresses for each currency by currency id then checksummed address,
+// they are aggregated through Multicall3 when it's deployed and all read at the same block, the balances
+// Multicall3 failed to read are queried directly
+func (b *Blockchain) GetBalancesOfAddresses(ctx context.Context, addresses []string, currencyIDs []string) (map[string]map[string]decimal.Decimal, error) {
+	// an invalid address would be read as the zero address or a truncated one
+	for _, address := range addresses {
+		if !common.IsHexAddress(address) {
+			return nil, fmt.Errorf("%w: %s", errors.ErrInvalidAddress, address)
+		}
+	}
+
+	erc20ABI, err := abi.JSON(strings.NewReader(abiDefinition))
+	if err != nil {
+		return nil, err
+	}
+
+	queries := make([]*balanceQuery, 0, len(addresses)*len(currencyIDs))
+	for _, currencyID := range currencyIDs {
+		c, contract, err := b.balanceCurrency(currencyID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, address := range addresses {
+			query := &balanceQuery{currency: c, address: address, contract: contract}
+			if contract != nil {
+				query.data, err = erc20ABI.Pack("balanceOf", common.HexToAddress(address))
+				if err != nil {
+					return nil, err
+				}
+			}
+
+			queries = append(queries, query)
+		}
+	}
+
+	blockNumber, err := b.GetLatestBlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	err = b.multicallBalances(ctx, queries, big.NewInt(blockNumber))
+	if errors.Is(err, errors.ErrNodeUnavailable) || errors.Is(err, errors.ErrRateLimited) {
+		return nil, err
+	}
+
+	failed := make([]*balanceQuery, 0)
+	for _, query := range queries {
+		if query.balance == nil {
+			failed = append(failed, query)
+		}
+	}
+
+	if err := b.batchBalances(ctx, failed, big.NewInt(blockNumber)); err != nil {
+		return nil, err
+	}
+
+	balances := make(map[string]map[string]decimal.Decimal, len(currencyIDs))
+	for _, query := range queries {
+		if balances[query.currency.ID] == nil {
+			balances[query.currency.ID] = make(map[string]decimal.Decimal, len(addresses))
+		}
+
+		balances[query.currency.ID][common.HexToAddress(query.address).Hex()] = decimal.NewFromBigInt(query.balance, -query.currency.Subunits)
+	}
+
+	return balances, nil
+}
+
+// balanceCurrency return the currency of currencyID and its ERC20 contract, contract is nil for the native currency
+func (b *Blockchain) balanceCurrency(currencyID string) (*currency.Currency, *common.Address, error) {
+	if b.currency.ID == currencyID {
+		return b.currency, nil, nil
+	}
+
+	for _, c := range b.contracts {
+		if c.ID == currencyID {
+			contract := common.HexToAddress(c.Options["erc20_contract_address"].(string))
+
+			return c, &contract, nil
+		}
+	}
+
+	return nil, nil, fmt.Errorf("currency %s is not a native or ERC20 currency of the blockchain", currencyID)
+}
+
+func (b *Blockchain) multicallAddress() common.Address {
+	if address, ok := b.currency.Options["multicall_address"].(string); ok {
+		return common.HexToAddress(address)
+	}
+
+	return common.HexToAddress(multicall3Address)
+}
+
+// multicallBalances set the balance of the queries Multicall3 could read, the others are left nil
+func (b *Blockchain) multicallBalances(ctx context.Context, queries []*balanceQuery, blockNumber *big.Int) error {
+	multicallABI, err := abi.JSON(strings.NewReader(multicall3AbiDefinition))
+	if err != nil {
+		return err
+	}
+
+	multicallAddress := b.multicallAddress()
+
+	for start := 0; start < len(queries); start += multicallBatchSize {
+		end := start + multicallBatchSize
+		if end > len(queries) {
+			end = len(queries)
+		}
+
+		batch := queries[start:end]
+		calls := make([]multicall, len(batch))
+		for i, query := range batch {
+			if query.contract == nil {
+				data, err := multicallABI.Pack("getEthBalance", common.HexToAddress(query.address))
+				if err != nil {
+					return err
+				}
+
+				calls[i] = multicall{Target: multicallAddress, AllowFailure: true, CallData: data}
+			} else {
+				calls[i] = multicall{Target: *query.contract, AllowFailure: true, CallData: query.data}
+			}
+		}
+
+		data, err := multicallABI.Pack("aggregate3", calls)
+		if err != nil {
+			return err
+		}
+
+		output, err := b.client.CallContract(ctx, ethereum.CallMsg{To: &multicallAddress, Data: data}, blockNumber)
+		if err != nil {
+			return mapError(err, errors.ErrBlockNotFound)
+		}
+
+		// an address without code answer nothing
+		values, err := multicallABI.Unpack("aggregate3", output)
+		if err != nil {
+			return err
+		}
+
+		results := *abi.ConvertType(values[0], new([]multicallResult)).(*[]multicallResult)
+		if len(results) != len(batch) {
+			return fmt.Errorf("multicall returned %d results for %d calls", len(results), len(batch))
+		}
+
+		for i, result := range results {
+			if !result.Success {
+				continue
+			}
+
+			batch[i].balance = new(big.Int).SetBytes(result.ReturnData)
+		}
+	}
+
+	return nil
+}
+
+// batchBalances query each balance with eth_getBalance or eth_call in batched requests
+func (b *Blockchain) batchBalances(ctx context.Context, queries []*balanceQuery, blockNumber *big.Int) error {
+	block := hexutil.EncodeBig(blockNumber)
+
+	for start := 0; start < len(queries); start += balanceBatchSize {
+		end := start + balanceBatchSize
+		if end > len(queries) {
+			end = len(queries)
+		}
+
+		batch := queries[start:end]
+		results := make([]hexutil.Bytes, len(batch))
+		balances := make([]hexutil.Big, len(batch))
+		elems := make([]rpc.BatchElem, len(batch))
+		for i, query := range batch {
+			if query.contract == nil {
+				elems[i] = rpc.BatchElem{Method: "eth_getBalance", Params: []interface{}{query.address, block}, Result: &balances[i]}
+			} else {
+				elems[i] = rpc.BatchElem{
+					Method: "eth_call",
+					Params: []interface{}{map[string]string{"to": query.contract.Hex(), "data": hexutil.Encode(query.data)}, block},
+					Result: &results[i],
+				}
+			}
+		}
+
+		if err := b.rpcClient.BatchCall(ctx, elems); err != nil {
+			return mapError(err, errors.ErrBlockNotFound)
+		}
+
+		for i, elem := range elems {
+			if elem.Error != nil {
+				return mapError(elem.Error, errors.ErrBlockNotFound)
+			}
+
+			if batch[i].contract == nil {
+				batch[i].balance = balances[i].ToInt()
+			} else {
+				batch[i].balance = new(big.Int).SetBytes(results[i])
+			}
+		}
+	}
+
+	return nil
+}
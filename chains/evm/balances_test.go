@@ -0,0 +1,160 @@
+package evm
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/zsmartex/multichain/internal/rpctest"
+	"github.com/zsmartex/multichain/pkg/errors"
+)
+
+func TestBlockchain_GetBalancesOfAddresses(t *testing.T) {
+	multicallABI, err := abi.JSON(strings.NewReader(multicall3AbiDefinition))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addresses := []string{"0x249aeb18f3a323c12334a595cb6220912c4b9087", "0xf37111de2f6ae2f64be1e59472b5c50801540c8c"}
+	balance := func(i int64) []byte {
+		return common.BigToHash(big.NewInt(i * 1_000_000)).Bytes()
+	}
+
+	aggregated, err := multicallABI.Methods["aggregate3"].Outputs.Pack([]multicallResult{
+		{Success: true, ReturnData: balance(1)},
+		{Success: true, ReturnData: balance(2)},
+		{Success: true, ReturnData: balance(3)},
+		{Success: true, ReturnData: balance(4)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	partial, err := multicallABI.Methods["aggregate3"].Outputs.Pack([]multicallResult{
+		{Success: true, ReturnData: balance(1)},
+		{Success: true, ReturnData: balance(2)},
+		{Success: false},
+		{Success: true, ReturnData: balance(4)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		results map[string]interface{}
+	}{
+		{
+			name: "multicall",
+			results: map[string]interface{}{
+				"eth_blockNumber": "0x10",
+				"eth_call": func(params []json.RawMessage) interface{} {
+					var block string
+					json.Unmarshal(params[1], &block)
+					if block != "0x10" {
+						t.Errorf("expected the balances to be read at block 0x10, got %s", block)
+					}
+
+					return hexutil.Encode(aggregated)
+				},
+			},
+		},
+		{
+			name: "failed call",
+			results: map[string]interface{}{
+				"eth_blockNumber": "0x10",
+				"eth_call": func(params []json.RawMessage) interface{} {
+					var call map[string]string
+					json.Unmarshal(params[0], &call)
+
+					if strings.EqualFold(call["to"], multicall3Address) {
+						return hexutil.Encode(partial)
+					}
+
+					// only the failed balance is queried again
+					if !strings.Contains(call["data"], addresses[0][2:]) {
+						t.Errorf("unexpected call %v", call)
+					}
+
+					return hexutil.Encode(balance(3))
+				},
+			},
+		},
+		{
+			name: "batch",
+			results: map[string]interface{}{
+				"eth_blockNumber": "0x10",
+				"eth_getBalance": func(params []json.RawMessage) interface{} {
+					var address string
+					json.Unmarshal(params[0], &address)
+
+					if address == addresses[0] {
+						return hexutil.EncodeBig(big.NewInt(1_000_000))
+					}
+
+					return hexutil.EncodeBig(big.NewInt(2_000_000))
+				},
+				"eth_call": func(params []json.RawMessage) interface{} {
+					var call map[string]string
+					json.Unmarshal(params[0], &call)
+
+					// Multicall3 is not deployed
+					if strings.EqualFold(call["to"], multicall3Address) {
+						return "0x"
+					}
+
+					if strings.Contains(call["data"], addresses[0][2:]) {
+						return hexutil.Encode(balance(3))
+					}
+
+					return hexutil.Encode(balance(4))
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := rpctest.NewServer(t, test.results)
+			defer server.Close()
+
+			bl := newTestBlockchain(t, server.URL)
+
+			balances, err := bl.GetBalancesOfAddresses(context.Background(), addresses, []string{"BSC", "USDT"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expected := map[string]map[string]string{
+				"BSC":  {addresses[0]: "0.000000000001", addresses[1]: "0.000000000002"},
+				"USDT": {addresses[0]: "3", addresses[1]: "4"},
+			}
+
+			// the balances are keyed by checksummed address
+			for currencyID, byAddress := range expected {
+				for address, amount := range byAddress {
+					if got := balances[currencyID][common.HexToAddress(address).Hex()].String(); got != amount {
+						t.Errorf("expected %s balance of %s to be %s, got %s", currencyID, address, amount, got)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestBlockchain_GetBalancesOfInvalidAddresses(t *testing.T) {
+	bl := newTestBlockchain(t, "http://127.0.0.1:0")
+
+	for _, address := range []string{"0x1234", "f37111de2f6ae2f64be1e59472b5c50801540c8", "not an address"} {
+		_, err := bl.GetBalancesOfAddresses(context.Background(), []string{"0xf37111de2f6ae2f64be1e59472b5c50801540c8c", address}, []string{"BSC"})
+		if !errors.Is(err, errors.ErrInvalidAddress) {
+			t.Errorf("expected invalid address error for %q, got %v", address, err)
+		}
+	}
+}
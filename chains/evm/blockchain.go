@@ -4,20 +4,25 @@ import (
 	"context"
 	"fmt"
 	"math/big"
+	"net/http"
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/ethereum/go-ethereum"
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/ethereum/go-ethereum/ethclient"
-	"github.com/ethereum/go-ethereum/rpc"
+	gethrpc "github.com/ethereum/go-ethereum/rpc"
 	"github.com/shopspring/decimal"
 	"github.com/volatiletech/null/v9"
 
 	"github.com/zsmartex/multichain/pkg/block"
 	"github.com/zsmartex/multichain/pkg/blockchain"
 	"github.com/zsmartex/multichain/pkg/currency"
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/rpc"
 	"github.com/zsmartex/multichain/pkg/transaction"
 )
 
@@ -25,47 +30,130 @@ var abiDefinition = `[{"constant":true,"inputs":[],"name":"name","outputs":[{"na
 var tokenEventIdentifier = "0xddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef"
 
 type Blockchain struct {
-	currency  *currency.Currency
-	contracts []*currency.Currency
-	client    *ethclient.Client
-	setting   *blockchain.Setting
+	currency     *currency.Currency
+	contracts    []*currency.Currency
+	nftContracts []*currency.Currency // currencies of ERC721 and ERC1155 contracts
+	client       *ethclient.Client    // sends its requests through rpcClient
+	rpcClient    *rpc.Client
+	wsURIs       []string // the websocket endpoints used by Subscribe
+	setting      *blockchain.Setting
+
+	// noBlockReceipts is set once the node rejected eth_getBlockReceipts
+	noBlockReceipts int32
+}
+
+func init() {
+	blockchain.Register("evm", NewBlockchain)
 }
 
 func NewBlockchain() blockchain.Blockchain {
 	return &Blockchain{
-		contracts: make([]*currency.Currency, 0),
+		contracts:    make([]*currency.Currency, 0),
+		nftContracts: make([]*currency.Currency, 0),
 	}
 }
 
-func (b *Blockchain) Configure(setting *blockchain.Setting) {
-	rpcClient, err := rpc.Dial(setting.URI)
+func (b *Blockchain) Configure(setting *blockchain.Setting) error {
+	if err := setting.Validate(); err != nil {
+		return err
+	}
+
+	var nativeCurrency *currency.Currency
+	contracts := make([]*currency.Currency, 0)
+	nftContracts := make([]*currency.Currency, 0)
+	for _, c := range setting.Currencies {
+		if err := validateContractAddress(c); err != nil {
+			return err
+		}
+
+		switch {
+		case c.Options["erc20_contract_address"] != nil:
+			contracts = append(contracts, c)
+		case c.Options["erc721_contract_address"] != nil, c.Options["erc1155_contract_address"] != nil:
+			nftContracts = append(nftContracts, c)
+		default:
+			if nativeCurrency != nil {
+				return errors.NewConfigError("currencies", "expected exactly one native currency, got %s and %s", nativeCurrency.ID, c.ID)
+			}
+
+			nativeCurrency = c
+		}
+	}
+
+	if nativeCurrency == nil {
+		return errors.NewConfigError("currencies", "native currency is missing")
+	}
+
+	if err := validateTraceMode(nativeCurrency); err != nil {
+		return err
+	}
+
+	// the requests fail over between the http endpoints, the websocket ones are kept for Subscribe
+	httpURIs := make([]string, 0)
+	wsURIs := make([]string, 0)
+	for _, uri := range rpc.SplitURI(setting.URI) {
+		if isWebsocketURI(uri) {
+			wsURIs = append(wsURIs, uri)
+		} else {
+			httpURIs = append(httpURIs, uri)
+		}
+	}
+
+	if len(httpURIs) == 0 {
+		return errors.NewConfigError("uri", "expected at least one http endpoint for the requests, got %s", setting.URI)
+	}
+
+	rpcClient := rpc.NewClient(strings.Join(httpURIs, ","), rpc.WithErrorParser(parseRPCError), rpc.WithHealthCheck(rpc.DefaultHealthInterval, "eth_blockNumber"))
+
+	ethClient, err := gethrpc.DialHTTPWithClient(httpURIs[0], &http.Client{Transport: rpcClient.RoundTripper()})
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("failed to dial rpc: %w", err)
 	}
 
-	client := ethclient.NewClient(rpcClient)
-	b.client = client
+	b.client = ethclient.NewClient(ethClient)
+	b.rpcClient = rpcClient
+	b.wsURIs = wsURIs
+	atomic.StoreInt32(&b.noBlockReceipts, 0)
 	b.setting = setting
+	b.currency = nativeCurrency
+	b.contracts = contracts
+	b.nftContracts = nftContracts
 
-	for _, c := range setting.Currencies {
-		if c.Options["erc20_contract_address"] != nil {
-			b.contracts = append(b.contracts, c)
-		} else {
-			b.currency = c
+	return nil
+}
+
+func isWebsocketURI(uri string) bool {
+	uri = strings.ToLower(uri)
+
+	return strings.HasPrefix(uri, "ws://") || strings.HasPrefix(uri, "wss://")
+}
+
+// validateContractAddress check the contract address options which are set on c
+func validateContractAddress(c *currency.Currency) error {
+	for _, option := range []string{"erc20_contract_address", "erc721_contract_address", "erc1155_contract_address"} {
+		if c.Options[option] == nil {
+			continue
+		}
+
+		contractAddress, ok := c.Options[option].(string)
+		if !ok || !common.IsHexAddress(contractAddress) {
+			return errors.NewConfigError("currency.options."+option, "%v of %s is not a valid address", c.Options[option], c.ID)
 		}
 	}
+
+	return nil
 }
 
 func (b *Blockchain) GetLatestBlockNumber(ctx context.Context) (int64, error) {
 	blockNumber, err := b.client.BlockNumber(ctx)
 
-	return int64(blockNumber), err
+	return int64(blockNumber), mapError(err, errors.ErrBlockNotFound)
 }
 
 func (b *Blockchain) GetBlockByNumber(ctx context.Context, blockNumber int64) (*block.Block, error) {
 	result, err := b.client.BlockByNumber(ctx, big.NewInt(blockNumber))
 	if err != nil {
-		return nil, err
+		return nil, mapError(err, errors.ErrBlockNotFound)
 	}
 
 	return b.GetBlockByHash(ctx, result.Hash().Hex())
@@ -73,13 +161,18 @@ func (b *Blockchain) GetBlockByNumber(ctx context.Context, blockNumber int64) (*
 
 func (b *Blockchain) GetBlockByHash(ctx context.Context, hash string) (*block.Block, error) {
 	result, err := b.client.BlockByHash(ctx, common.HexToHash(hash))
+	if err != nil {
+		return nil, mapError(err, errors.ErrBlockNotFound)
+	}
+
+	receipts, err := b.blockReceipts(ctx, result)
 	if err != nil {
 		return nil, err
 	}
 
 	transactions := make([]*transaction.Transaction, 0)
-	for _, t := range result.Transactions() {
-		txs, err := b.buildTransaction(ctx, t)
+	for i, t := range result.Transactions() {
+		txs, err := b.buildTransaction(t, receipts[i], result.BaseFee())
 		if err != nil {
 			return nil, err
 		}
@@ -87,27 +180,81 @@ func (b *Blockchain) GetBlockByHash(ctx context.Context, hash string) (*block.Bl
 		transactions = append(transactions, txs...)
 	}
 
+	internalTransactions, err := b.buildInternalTransactions(ctx, result, receipts)
+	if err != nil {
+		return nil, err
+	}
+
+	transactions = append(transactions, internalTransactions...)
+
+	latestBlockNumber, err := b.GetLatestBlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	b.setting.ApplyConfirmations(latestBlockNumber-result.Number().Int64()+1, transactions...)
+
 	return &block.Block{
 		Hash:         result.Hash().Hex(),
+		ParentHash:   result.ParentHash().Hex(),
 		Number:       result.Number().Int64(),
+		Timestamp:    time.Unix(int64(result.Time()), 0),
 		Transactions: transactions,
 	}, nil
 }
 
 func (b *Blockchain) GetTransaction(ctx context.Context, txHash string) (*transaction.Transaction, error) {
 	result, _, err := b.client.TransactionByHash(ctx, common.HexToHash(txHash))
+	if err != nil {
+		return nil, mapError(err, errors.ErrTxNotFound)
+	}
+
+	r, err := b.transactionReceipt(ctx, result.Hash())
 	if err != nil {
 		return nil, err
 	}
 
-	ts, err := b.buildTransaction(ctx, result)
+	ts, err := b.buildTransaction(result, r, nil)
 	if err != nil {
 		return nil, err
 	}
 
+	latestBlockNumber, err := b.GetLatestBlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	b.setting.ApplyConfirmations(latestBlockNumber-ts[0].BlockNumber+1, ts[0])
+
 	return ts[0], nil
 }
 
+func (b *Blockchain) Confirmations(ctx context.Context, txHash string) (int64, error) {
+	receipt, err := b.client.TransactionReceipt(ctx, common.HexToHash(txHash))
+	if errors.Is(err, ethereum.NotFound) {
+		// transaction without receipt can still be in mempool
+		_, isPending, err := b.client.TransactionByHash(ctx, common.HexToHash(txHash))
+		if err != nil {
+			return 0, mapError(err, errors.ErrTxNotFound)
+		}
+
+		if isPending {
+			return 0, nil
+		}
+
+		return 0, errors.ErrTxNotFound
+	} else if err != nil {
+		return 0, mapError(err, errors.ErrTxNotFound)
+	}
+
+	latestBlockNumber, err := b.GetLatestBlockNumber(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return latestBlockNumber - receipt.BlockNumber.Int64() + 1, nil
+}
+
 func (b *Blockchain) GetBalanceOfAddress(ctx context.Context, address string, currencyID string) (decimal.Decimal, error) {
 	for _, contract := range b.contracts {
 		if currencyID == contract.ID {
@@ -115,6 +262,19 @@ func (b *Blockchain) GetBalanceOfAddress(ctx context.Context, address string, cu
 		}
 	}
 
+	for _, contract := range b.nftContracts {
+		if currencyID != contract.ID {
+			continue
+		}
+
+		if contract.Options["erc1155_contract_address"] != nil {
+			return decimal.Zero, fmt.Errorf("%w: %s", ErrERC1155Balance, contract.ID)
+		}
+
+		// ERC721 has the balanceOf method of ERC20 returning the number of tokens held
+		return b.tokenBalance(ctx, address, common.HexToAddress(nftContractAddress(contract)), 0)
+	}
+
 	blockNumber, err := b.GetLatestBlockNumber(ctx)
 	if err != nil {
 		return decimal.Zero, err
@@ -122,7 +282,7 @@ func (b *Blockchain) GetBalanceOfAddress(ctx context.Context, address string, cu
 
 	amount, err := b.client.BalanceAt(context.Background(), common.HexToAddress(address), big.NewInt(blockNumber))
 	if err != nil {
-		return decimal.Zero, err
+		return decimal.Zero, mapError(err, errors.ErrBlockNotFound)
 	}
 
 	return decimal.NewFromBigInt(amount, -b.currency.Subunits), nil
@@ -131,6 +291,11 @@ func (b *Blockchain) GetBalanceOfAddress(ctx context.Context, address string, cu
 func (b *Blockchain) getERC20Balance(ctx context.Context, address string, currency *currency.Currency) (decimal.Decimal, error) {
 	contractAddress := common.HexToAddress(currency.Options["erc20_contract_address"].(string))
 
+	return b.tokenBalance(ctx, address, contractAddress, currency.Subunits)
+}
+
+// tokenBalance call balanceOf(address) on contractAddress at the latest block
+func (b *Blockchain) tokenBalance(ctx context.Context, address string, contractAddress common.Address, subunits int32) (decimal.Decimal, error) {
 	blockNumber, err := b.GetLatestBlockNumber(ctx)
 	if err != nil {
 		return decimal.Zero, err
@@ -151,34 +316,39 @@ func (b *Blockchain) getERC20Balance(ctx context.Context, address string, curren
 		Data: data,
 	}, big.NewInt(blockNumber))
 	if err != nil {
-		return decimal.Zero, err
+		return decimal.Zero, mapError(err, errors.ErrBlockNotFound)
 	}
 
-	return decimal.NewFromBigInt(new(big.Int).SetBytes(bytes), -currency.Subunits), nil
+	return decimal.NewFromBigInt(new(big.Int).SetBytes(bytes), -subunits), nil
 }
 
-func (b *Blockchain) buildTransaction(ctx context.Context, tx *types.Transaction) ([]*transaction.Transaction, error) {
-	receipt, err := b.client.TransactionReceipt(ctx, tx.Hash())
-	if err != nil {
-		return nil, err
+func (b *Blockchain) transactionReceipt(ctx context.Context, hash common.Hash) (*receipt, error) {
+	r := new(receipt)
+	if err := b.rpcClient.Call(ctx, r, "eth_getTransactionReceipt", hash); err != nil {
+		return nil, mapError(err, errors.ErrTxNotFound)
 	}
 
+	return r, nil
+}
+
+// buildTransaction baseFee is the base fee of the block of tx, it can be nil when it's unknown
+func (b *Blockchain) buildTransaction(tx *types.Transaction, receipt *receipt, baseFee *big.Int) ([]*transaction.Transaction, error) {
+	fee := decimal.NewFromBigInt(receipt.Fee(tx, baseFee), -b.currency.Subunits)
+
 	if len(receipt.Logs) > 0 {
-		return b.buildERC20Transactions(tx, receipt)
+		return b.buildERC20Transactions(tx, &receipt.Receipt, fee)
 	} else {
-		return b.buildETHTransactions(tx, receipt)
+		return b.buildETHTransactions(tx, &receipt.Receipt, fee)
 	}
 }
 
-func (b *Blockchain) buildETHTransactions(tx *types.Transaction, receipt *types.Receipt) ([]*transaction.Transaction, error) {
+func (b *Blockchain) buildETHTransactions(tx *types.Transaction, receipt *types.Receipt, fee decimal.Decimal) ([]*transaction.Transaction, error) {
 	msg, err := tx.AsMessage(types.LatestSignerForChainID(tx.ChainId()), tx.GasPrice())
 	if err != nil {
 		return nil, err
 	}
 
-	cost := decimal.NewFromBigInt(tx.Cost(), -b.currency.Subunits)
 	amount := decimal.NewFromBigInt(tx.Value(), -b.currency.Subunits)
-	fee := cost.Sub(amount)
 
 	var toAddress string
 	if tx.To() == nil {
@@ -196,58 +366,74 @@ func (b *Blockchain) buildETHTransactions(tx *types.Transaction, receipt *types.
 			ToAddress:   toAddress,
 			Fee:         decimal.NewNullDecimal(fee),
 			Amount:      amount,
+			BlockNumber: receipt.BlockNumber.Int64(),
 			Status:      b.transactionStatus(receipt),
 		},
 	}, nil
 }
 
-func (b *Blockchain) buildERC20Transactions(tx *types.Transaction, receipt *types.Receipt) ([]*transaction.Transaction, error) {
+func (b *Blockchain) buildERC20Transactions(tx *types.Transaction, receipt *types.Receipt, fee decimal.Decimal) ([]*transaction.Transaction, error) {
 	if b.transactionStatus(receipt) == transaction.StatusFailed && len(receipt.Logs) == 0 {
-		return b.buildInvalidErc20Transaction(tx, receipt)
+		return b.buildInvalidErc20Transaction(tx, receipt, fee)
 	}
 
-	fee := decimal.NewFromBigInt(big.NewInt(int64(receipt.GasUsed*tx.GasFeeCap().Uint64())), -b.currency.Subunits)
-
 	transactions := make([]*transaction.Transaction, 0)
 	for _, l := range receipt.Logs {
 		if len(l.BlockHash.Bytes()) == 0 && l.BlockNumber == 0 {
 			continue
 		}
-		if len(l.Topics) == 0 || l.Topics[0].Hex() != tokenEventIdentifier {
+
+		if t := b.buildERC20Transfer(l, receipt, fee); t != nil {
+			transactions = append(transactions, t)
 			continue
 		}
 
-		// Contract: l.Address.Hex()
-		fromAddress := fmt.Sprintf("0x%s", l.Topics[1].Hex()[26:])
-		toAddress := fmt.Sprintf("0x%s", l.Topics[2].Hex()[26:])
-
-		for _, c := range b.contracts {
-			contractAddress := c.Options["erc20_contract_address"].(string)
-			if strings.EqualFold(contractAddress, l.Address.Hex()) {
-				i := new(big.Int)
-				i.SetString(common.Bytes2Hex(l.Data), 16)
-				amount := decimal.NewFromBigInt(i, -c.Subunits)
-
-				transactions = append(transactions, &transaction.Transaction{
-					Currency:    c.ID,
-					CurrencyFee: b.currency.ID,
-					TxHash:      null.StringFrom(tx.Hash().Hex()),
-					FromAddress: fromAddress,
-					ToAddress:   toAddress,
-					Fee:         decimal.NewNullDecimal(fee),
-					Amount:      amount,
-					Status:      b.transactionStatus(receipt),
-				})
-			}
+		nftTransactions, err := b.buildNFTTransfers(l, receipt, fee)
+		if err != nil {
+			return nil, err
 		}
+
+		transactions = append(transactions, nftTransactions...)
 	}
 
 	return transactions, nil
 }
 
-func (b *Blockchain) buildInvalidErc20Transaction(tx *types.Transaction, receipt *types.Receipt) ([]*transaction.Transaction, error) {
-	fee := decimal.NewFromBigInt(big.NewInt(int64(receipt.GasUsed*tx.GasFeeCap().Uint64())), -b.currency.Subunits)
+// buildERC20Transfer return nil when l is not a Transfer event of a configured contract
+func (b *Blockchain) buildERC20Transfer(l *types.Log, receipt *types.Receipt, fee decimal.Decimal) *transaction.Transaction {
+	// ERC721 Transfer events share the same signature with the token id as a third indexed topic
+	if len(l.Topics) != 3 || l.Topics[0].Hex() != tokenEventIdentifier {
+		return nil
+	}
+
+	// Contract: l.Address.Hex()
+	fromAddress := fmt.Sprintf("0x%s", l.Topics[1].Hex()[26:])
+	toAddress := fmt.Sprintf("0x%s", l.Topics[2].Hex()[26:])
+
+	for _, c := range b.contracts {
+		contractAddress := c.Options["erc20_contract_address"].(string)
+		if strings.EqualFold(contractAddress, l.Address.Hex()) {
+			amount := decimal.NewFromBigInt(new(big.Int).SetBytes(l.Data), -c.Subunits)
+
+			return &transaction.Transaction{
+				Currency:    c.ID,
+				CurrencyFee: b.currency.ID,
+				TxHash:      null.StringFrom(receipt.TxHash.Hex()),
+				Position:    null.Int64From(int64(l.Index)),
+				FromAddress: fromAddress,
+				ToAddress:   toAddress,
+				Fee:         decimal.NewNullDecimal(fee),
+				Amount:      amount,
+				BlockNumber: receipt.BlockNumber.Int64(),
+				Status:      b.transactionStatus(receipt),
+			}
+		}
+	}
+
+	return nil
+}
 
+func (b *Blockchain) buildInvalidErc20Transaction(tx *types.Transaction, receipt *types.Receipt, fee decimal.Decimal) ([]*transaction.Transaction, error) {
 	transactions := make([]*transaction.Transaction, 0)
 
 	for _, c := range b.contracts {
@@ -2,10 +2,14 @@ package evm
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 
+	"github.com/zsmartex/multichain/internal/rpctest"
 	"github.com/zsmartex/multichain/pkg/blockchain"
 	"github.com/zsmartex/multichain/pkg/currency"
+	"github.com/zsmartex/multichain/pkg/errors"
 )
 
 func newBlockchain() blockchain.Blockchain {
@@ -31,6 +35,77 @@ func newBlockchain() blockchain.Blockchain {
 	return bl
 }
 
+func TestBlockchain_Configure(t *testing.T) {
+	settings := map[string]*blockchain.Setting{
+		"empty uri": {
+			Currencies: []*currency.Currency{{ID: "BSC", Subunits: 18}},
+		},
+		"missing native currency": {
+			URI: "http://localhost:8545",
+			Currencies: []*currency.Currency{
+				{ID: "USDT", Subunits: 18, Options: map[string]interface{}{"erc20_contract_address": "0x337610d27c682e347c9cd60bd4b3b107c9d34ddd"}},
+			},
+		},
+		"two native currencies": {
+			URI:        "http://localhost:8545",
+			Currencies: []*currency.Currency{{ID: "BSC", Subunits: 18}, {ID: "ETH", Subunits: 18}},
+		},
+		"invalid contract address": {
+			URI: "http://localhost:8545",
+			Currencies: []*currency.Currency{
+				{ID: "BSC", Subunits: 18},
+				{ID: "USDT", Subunits: 18, Options: map[string]interface{}{"erc20_contract_address": "0x337610"}},
+			},
+		},
+		"websocket endpoint only": {
+			URI:        "ws://localhost:8546",
+			Currencies: []*currency.Currency{{ID: "BSC", Subunits: 18}},
+		},
+		"invalid subunits": {
+			URI:        "http://localhost:8545",
+			Currencies: []*currency.Currency{{ID: "BSC", Subunits: 36}},
+		},
+	}
+
+	for name, setting := range settings {
+		if err := NewBlockchain().Configure(setting); !errors.Is(err, errors.ErrInvalidConfig) {
+			t.Errorf("%s: expected invalid config error, got %v", name, err)
+		}
+	}
+
+	if err := NewBlockchain().Configure(&blockchain.Setting{
+		URI:        "http://localhost:8545",
+		Currencies: []*currency.Currency{{ID: "BSC", Subunits: 18}},
+	}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBlockchain_Failover(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	up := rpctest.NewServer(t, map[string]interface{}{"eth_blockNumber": "0x10"})
+	defer up.Close()
+
+	bl := newTestBlockchain(t, down.URL+","+up.URL+",ws://127.0.0.1:0")
+
+	number, err := bl.GetLatestBlockNumber(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if number != 16 {
+		t.Errorf("unexpected block number %d", number)
+	}
+
+	if len(bl.wsURIs) != 1 {
+		t.Errorf("expected the websocket endpoint to be kept for subscriptions, got %v", bl.wsURIs)
+	}
+}
+
 func TestBlockchain_GetLatestBlockNumber(t *testing.T) {
 	bl := newBlockchain()
 
@@ -0,0 +1,127 @@
+package evm
+
+import (
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	gethrpc "github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/rpc"
+)
+
+// parseRPCError map the error payload of an evm node onto the shared errors
+func parseRPCError(code int, message string) error {
+	rpcErr := &errors.RPCError{
+		Code:    code,
+		Message: message,
+	}
+
+	msg := strings.ToLower(message)
+	switch {
+	case code == -32005 || strings.Contains(msg, "rate limit") || strings.Contains(msg, "too many requests"):
+		rpcErr.Err = errors.ErrRateLimited
+	case strings.Contains(msg, "insufficient funds"):
+		rpcErr.Err = errors.ErrInsufficientFunds
+	case strings.Contains(msg, "already known"),
+		strings.HasPrefix(msg, "known transaction"),
+		strings.Contains(msg, "already imported"):
+		rpcErr.Err = errors.ErrAlreadyBroadcast
+	case strings.Contains(msg, "nonce too low"),
+		strings.Contains(msg, "nonce too high"),
+		strings.Contains(msg, "replacement transaction underpriced"):
+		rpcErr.Err = errors.ErrNonceConflict
+	case strings.Contains(msg, "invalid address"),
+		strings.Contains(msg, "unknown account"),
+		strings.Contains(msg, "hex string has length"):
+		rpcErr.Err = errors.ErrInvalidAddress
+	case strings.Contains(msg, "header not found"), strings.Contains(msg, "unknown block"):
+		rpcErr.Err = errors.ErrBlockNotFound
+	case strings.Contains(msg, "transaction not found"), strings.Contains(msg, "unknown transaction"):
+		rpcErr.Err = errors.ErrTxNotFound
+	}
+
+	return rpcErr
+}
+
+// isRejected report whether the node answered err, so the request was definitely not processed,
+// other errors like timeouts leave unknown whether the node got it
+func isRejected(err error) bool {
+	var rpcErr *errors.RPCError
+
+	return errors.As(err, &rpcErr) || errors.Is(err, errors.ErrRateLimited)
+}
+
+// isAlreadyKnown report whether err is a node refusing a transaction because it's already in its mempool
+func isAlreadyKnown(err error) bool {
+	return errors.Is(err, errors.ErrAlreadyBroadcast)
+}
+
+// isMethodNotFound report whether err is a node rejecting a method it doesn't implement
+func isMethodNotFound(err error) bool {
+	var code int
+	var message string
+
+	var rpcErr *errors.RPCError
+	var nodeErr gethrpc.Error
+	switch {
+	case errors.As(err, &rpcErr):
+		code, message = rpcErr.Code, rpcErr.Message
+	case errors.As(err, &nodeErr):
+		code, message = nodeErr.ErrorCode(), nodeErr.Error()
+	default:
+		return false
+	}
+
+	msg := strings.ToLower(message)
+
+	return code == -32601 ||
+		strings.Contains(msg, "method not found") ||
+		strings.Contains(msg, "does not exist/is not available") ||
+		strings.Contains(msg, "not supported")
+}
+
+// parseHTTPStatus map a failed http status onto the shared errors
+func parseHTTPStatus(statusCode int, err error) error {
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return errors.Wrap(errors.ErrRateLimited, err)
+	case statusCode >= http.StatusInternalServerError:
+		return errors.Wrap(errors.ErrNodeUnavailable, err)
+	default:
+		return err
+	}
+}
+
+// mapError map an error returned by ethclient onto the shared errors, notFound is used for ethereum.NotFound
+// and the null results of the shared json-rpc client
+func mapError(err error, notFound error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, ethereum.NotFound) || errors.Is(err, rpc.ErrNullResult) {
+		return errors.Wrap(notFound, err)
+	}
+
+	var httpErr gethrpc.HTTPError
+	if errors.As(err, &httpErr) {
+		return parseHTTPStatus(httpErr.StatusCode, err)
+	}
+
+	var rpcErr gethrpc.Error
+	if errors.As(err, &rpcErr) {
+		return parseRPCError(rpcErr.ErrorCode(), rpcErr.Error())
+	}
+
+	var urlErr *url.Error
+	var netErr net.Error
+	if errors.As(err, &urlErr) || errors.As(err, &netErr) {
+		return errors.Wrap(errors.ErrNodeUnavailable, err)
+	}
+
+	return err
+}
@@ -0,0 +1,32 @@
+package evm
+
+import (
+	"testing"
+
+	"github.com/zsmartex/multichain/pkg/errors"
+)
+
+func TestParseRPCError(t *testing.T) {
+	cases := map[string]error{
+		"insufficient funds for gas * price + value": errors.ErrInsufficientFunds,
+		"nonce too low":                                      errors.ErrNonceConflict,
+		"replacement transaction underpriced":                errors.ErrNonceConflict,
+		"already known":                                      errors.ErrAlreadyBroadcast,
+		"known transaction: 0x1":                             errors.ErrAlreadyBroadcast,
+		"unknown transaction":                                errors.ErrTxNotFound,
+		"header not found":                                   errors.ErrBlockNotFound,
+		"daily request count exceeded, request rate limited": errors.ErrRateLimited,
+	}
+
+	for message, expected := range cases {
+		if err := parseRPCError(-32000, message); !errors.Is(err, expected) {
+			t.Errorf("%s: expected %v, got %v", message, expected, err)
+		}
+	}
+
+	err := parseRPCError(-32000, "execution reverted")
+	var rpcErr *errors.RPCError
+	if !errors.As(err, &rpcErr) || rpcErr.Err != nil {
+		t.Errorf("expected unmapped rpc error, got %v", err)
+	}
+}
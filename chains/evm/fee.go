@@ -0,0 +1,152 @@
+package evm
+
+import (
+	"context"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/wallet"
+)
+
+const (
+	TxTypeLegacy  = "legacy"
+	TxTypeDynamic = "dynamic"
+)
+
+// feeHistoryBlocks the number of blocks used to estimate the priority fee
+const feeHistoryBlocks = 10
+
+// feeHistoryPercentiles the percentile of priority fees paid in recent blocks used for each rate
+var feeHistoryPercentiles = map[wallet.GasPriceRate]float64{
+	wallet.GasPriceRateStandard: 50,
+	wallet.GasPriceRateFast:     90,
+}
+
+// gasFee is the price paid per gas, GasPrice is set for legacy transactions
+// MaxFeePerGas and MaxPriorityFeePerGas are set for EIP-1559 dynamic fee transactions
+type gasFee struct {
+	GasPrice             *big.Int
+	MaxFeePerGas         *big.Int
+	MaxPriorityFeePerGas *big.Int
+	// BaseFee the base fee of the next block the dynamic fee was computed from
+	BaseFee *big.Int
+}
+
+func (f *gasFee) IsDynamic() bool {
+	return f.MaxFeePerGas != nil
+}
+
+// Cap return the max price which can be paid per gas
+func (f *gasFee) Cap() *big.Int {
+	if f.IsDynamic() {
+		return f.MaxFeePerGas
+	}
+
+	return f.GasPrice
+}
+
+// Total return the max fee paid for gasLimit
+func (f *gasFee) Total(gasLimit uint64) *big.Int {
+	return new(big.Int).Mul(f.Cap(), new(big.Int).SetUint64(gasLimit))
+}
+
+// apply set the fee fields of a transaction call object
+func (f *gasFee) apply(params map[string]string) {
+	if f.IsDynamic() {
+		params["maxFeePerGas"] = hexutil.EncodeBig(f.MaxFeePerGas)
+		params["maxPriorityFeePerGas"] = hexutil.EncodeBig(f.MaxPriorityFeePerGas)
+	} else {
+		params["gasPrice"] = hexutil.EncodeBig(f.GasPrice)
+	}
+}
+
+// calculateGasFee return a dynamic fee from eth_feeHistory when the chain support London,
+// a legacy gas price is used when gas_price is given, tx_type is legacy or the chain don't support it
+func (w *Wallet) calculateGasFee(ctx context.Context, options map[string]interface{}) (*gasFee, error) {
+	if options["gas_price"] != nil {
+		gasPrice, ok := numberOption(options["gas_price"])
+		if !ok || gasPrice < 0 {
+			return nil, errors.NewConfigError("options.gas_price", "%v is not a valid gas price", options["gas_price"])
+		}
+
+		return &gasFee{GasPrice: new(big.Int).SetUint64(uint64(gasPrice))}, nil
+	}
+
+	if options["tx_type"] != TxTypeLegacy {
+		fee, err := w.calculateDynamicFee(ctx, options)
+		if errors.Is(err, errors.ErrNodeUnavailable) || errors.Is(err, errors.ErrRateLimited) {
+			return nil, err
+		}
+
+		if err == nil && fee != nil {
+			return fee, nil
+		}
+	}
+
+	gasPrice, err := w.calculateGasPrice(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gasFee{GasPrice: new(big.Int).SetUint64(gasPrice)}, nil
+}
+
+// calculateDynamicFee return nil when the chain don't have a base fee
+func (w *Wallet) calculateDynamicFee(ctx context.Context, options map[string]interface{}) (*gasFee, error) {
+	var rate wallet.GasPriceRate
+	switch r := options["gas_rate"].(type) {
+	case wallet.GasPriceRate:
+		rate = r
+	case string:
+		rate = wallet.GasPriceRate(r)
+	}
+
+	percentile, ok := feeHistoryPercentiles[rate]
+	if !ok {
+		percentile = feeHistoryPercentiles[wallet.GasPriceRateStandard]
+	}
+
+	var result struct {
+		BaseFeePerGas []*hexutil.Big   `json:"baseFeePerGas"`
+		Reward        [][]*hexutil.Big `json:"reward"`
+	}
+	if err := w.client.Call(ctx, &result, "eth_feeHistory", hexutil.EncodeUint64(feeHistoryBlocks), "latest", []float64{percentile}); err != nil {
+		return nil, err
+	}
+
+	// baseFeePerGas include the base fee of the next block
+	if len(result.BaseFeePerGas) == 0 {
+		return nil, nil
+	}
+
+	baseFee := result.BaseFeePerGas[len(result.BaseFeePerGas)-1].ToInt()
+	if baseFee.Sign() == 0 {
+		return nil, nil
+	}
+
+	rewards := make([]*big.Int, 0, len(result.Reward))
+	for _, reward := range result.Reward {
+		if len(reward) > 0 && reward[0] != nil {
+			rewards = append(rewards, reward[0].ToInt())
+		}
+	}
+
+	priorityFee := big.NewInt(0)
+	if len(rewards) > 0 {
+		sort.Slice(rewards, func(i, j int) bool { return rewards[i].Cmp(rewards[j]) < 0 })
+		priorityFee = rewards[len(rewards)/2]
+	}
+
+	// leave room for the base fee to double before the transaction is mined
+	maxFee := new(big.Int).Mul(baseFee, big.NewInt(2))
+	maxFee.Add(maxFee, priorityFee)
+
+	return &gasFee{
+		MaxFeePerGas:         maxFee,
+		MaxPriorityFeePerGas: priorityFee,
+		BaseFee:              baseFee,
+	}, nil
+}
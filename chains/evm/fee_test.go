@@ -0,0 +1,91 @@
+package evm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/zsmartex/multichain/internal/rpctest"
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/wallet"
+)
+
+func TestWallet_CalculateGasFee(t *testing.T) {
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"eth_feeHistory": map[string]interface{}{
+			"oldestBlock":   "0x1",
+			"baseFeePerGas": []string{"0x64", "0x64", "0xc8"},
+			"reward":        [][]string{{"0x1"}, {"0x3"}},
+		},
+		"eth_gasPrice": "0x3e8",
+	})
+	defer server.Close()
+
+	w := newTestWallet(t, server.URL)
+
+	fee, err := w.calculateGasFee(context.Background(), map[string]interface{}{"gas_rate": wallet.GasPriceRateFast})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !fee.IsDynamic() || fee.MaxFeePerGas.Int64() != 403 || fee.MaxPriorityFeePerGas.Int64() != 3 {
+		t.Errorf("unexpected dynamic fee %v %v", fee.MaxFeePerGas, fee.MaxPriorityFeePerGas)
+	}
+
+	fee, err = w.calculateGasFee(context.Background(), map[string]interface{}{"gas_rate": wallet.GasPriceRateStandard, "tx_type": TxTypeLegacy})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fee.IsDynamic() || fee.GasPrice.Int64() != 1000 {
+		t.Errorf("unexpected legacy fee %v", fee.GasPrice)
+	}
+}
+
+func TestWallet_CalculateGasFeeBeforeLondon(t *testing.T) {
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"eth_gasPrice": "0x3e8",
+	})
+	defer server.Close()
+
+	fee, err := newTestWallet(t, server.URL).calculateGasFee(context.Background(), map[string]interface{}{"gas_rate": wallet.GasPriceRateStandard})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fee.IsDynamic() || fee.GasPrice.Int64() != 1000 {
+		t.Errorf("expected legacy fallback, got %v", fee)
+	}
+}
+
+func TestWallet_CalculateGasFeeGasPrice(t *testing.T) {
+	w := newTestWallet(t, "http://127.0.0.1:0")
+
+	// options decoded from json hold float64
+	fee, err := w.calculateGasFee(context.Background(), map[string]interface{}{"gas_price": float64(5_000_000_000)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if fee.IsDynamic() || fee.GasPrice.Int64() != 5_000_000_000 {
+		t.Errorf("unexpected gas price %v", fee.GasPrice)
+	}
+
+	if _, err := w.calculateGasFee(context.Background(), map[string]interface{}{"gas_price": "fast"}); !errors.Is(err, errors.ErrInvalidConfig) {
+		t.Errorf("expected invalid config error, got %v", err)
+	}
+}
+
+func TestWallet_MergeOptions(t *testing.T) {
+	w := newTestWallet(t, "http://127.0.0.1:0")
+	w.currency.Options = map[string]interface{}{"gas_rate": wallet.GasPriceRateStandard, "gas_multiplier": 1.5}
+
+	options := w.mergeOptions(nil, defaultEvmFee, w.currency.Options, map[string]interface{}{"gas_rate": wallet.GasPriceRateFast})
+
+	if options["gas_rate"] != wallet.GasPriceRateFast {
+		t.Errorf("expected the options of the call to win, got %v", options["gas_rate"])
+	}
+
+	if options["gas_multiplier"] != 1.5 || options["gas_limit"] != defaultEvmFee["gas_limit"] {
+		t.Errorf("unexpected options %v", options)
+	}
+}
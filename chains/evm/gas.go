@@ -0,0 +1,96 @@
+package evm
+
+import (
+	"context"
+	"fmt"
+	"math"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	"github.com/zsmartex/multichain/pkg/errors"
+)
+
+const (
+	// defaultGasMultiplier the safety margin applied to eth_estimateGas, override with the gas_multiplier option
+	defaultGasMultiplier = 1.2
+	// defaultGasLimitCap the max gas limit an estimation can return, override with the gas_limit_cap option
+	defaultGasLimitCap = 500_000
+	// transferGas the exact gas used by a native transfer to an account without code
+	transferGas = 21_000
+)
+
+// estimateGasLimit return the eth_estimateGas of call sent by from raised by gas_multiplier and capped to gas_limit_cap,
+// the cap never lower the limit under the estimation which fails when it's above the cap,
+// the gas_limit option is used when the node can't estimate the call
+func (w *Wallet) estimateGasLimit(ctx context.Context, from string, call *txCall, options map[string]interface{}) (uint64, error) {
+	gasLimit, ok := numberOption(options["gas_limit"])
+	if !ok || gasLimit <= 0 {
+		return 0, errors.NewConfigError("options.gas_limit", "%v is not a valid gas limit", options["gas_limit"])
+	}
+	fallback := uint64(gasLimit)
+
+	params := map[string]string{
+		"to": w.normalizeAddress(call.To.Hex()),
+	}
+	if len(from) > 0 {
+		params["from"] = w.normalizeAddress(from)
+	}
+	if call.Value != nil && call.Value.Sign() > 0 {
+		params["value"] = hexutil.EncodeBig(call.Value)
+	}
+	if len(call.Data) > 0 {
+		params["data"] = hexutil.Encode(call.Data)
+	}
+
+	var estimated hexutil.Uint64
+	if err := w.client.Call(ctx, &estimated, "eth_estimateGas", params); err != nil {
+		if errors.Is(err, errors.ErrNodeUnavailable) || errors.Is(err, errors.ErrRateLimited) {
+			return 0, err
+		}
+
+		return fallback, nil
+	}
+
+	// a plain transfer always use the same gas
+	if len(call.Data) == 0 && uint64(estimated) == transferGas {
+		return transferGas, nil
+	}
+
+	limitCap := uint64(optionFloat(options["gas_limit_cap"], defaultGasLimitCap))
+	if uint64(estimated) > limitCap {
+		return 0, fmt.Errorf("estimated gas %d is above the gas_limit_cap %d", uint64(estimated), limitCap)
+	}
+
+	percent := uint64(math.Round(optionFloat(options["gas_multiplier"], defaultGasMultiplier) * 100))
+	limit := (uint64(estimated)*percent + 99) / 100
+	if limit > limitCap {
+		limit = limitCap
+	}
+
+	return limit, nil
+}
+
+// optionFloat read a numeric option which may be decoded as an int or a float
+func optionFloat(value interface{}, fallback float64) float64 {
+	if v, ok := numberOption(value); ok {
+		return v
+	}
+
+	return fallback
+}
+
+// numberOption read a numeric option, options decoded from json hold float64 instead of int
+func numberOption(value interface{}) (float64, bool) {
+	switch v := value.(type) {
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case uint64:
+		return float64(v), true
+	case float64:
+		return v, true
+	default:
+		return 0, false
+	}
+}
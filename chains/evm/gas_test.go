@@ -0,0 +1,83 @@
+package evm
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/zsmartex/multichain/internal/rpctest"
+	"github.com/zsmartex/multichain/pkg/errors"
+)
+
+func TestWallet_EstimateGasLimit(t *testing.T) {
+	tests := []struct {
+		name     string
+		estimate interface{}
+		data     []byte
+		options  map[string]interface{}
+		expected uint64
+	}{
+		{name: "transfer", estimate: "0x5208", expected: 21_000},
+		{name: "multiplier", estimate: "0xc350", data: []byte{0x1}, expected: 60_000},
+		{name: "custom multiplier", estimate: "0xc350", data: []byte{0x1}, options: map[string]interface{}{"gas_multiplier": 1.5}, expected: 75_000},
+		{name: "cap", estimate: "0xc350", data: []byte{0x1}, options: map[string]interface{}{"gas_limit_cap": 55_000}, expected: 55_000},
+		{name: "cap equal to the estimation", estimate: "0xc350", data: []byte{0x1}, options: map[string]interface{}{"gas_limit_cap": 50_000}, expected: 50_000},
+		{name: "fallback", data: []byte{0x1}, expected: 90_000},
+		{name: "decoded fallback", data: []byte{0x1}, options: map[string]interface{}{"gas_limit": float64(120_000)}, expected: 120_000},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			results := map[string]interface{}{}
+			if test.estimate != nil {
+				results["eth_estimateGas"] = test.estimate
+			}
+
+			server := rpctest.NewServer(t, results)
+			defer server.Close()
+
+			w := newTestWallet(t, server.URL)
+
+			options := w.mergeOptions(nil, defaultErc20Fee, test.options)
+			limit, err := w.estimateGasLimit(context.Background(), w.wallet.Address, &txCall{
+				To:   common.HexToAddress("0xf37111de2f6ae2f64be1e59472b5c50801540c8c"),
+				Data: test.data,
+			}, options)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if limit != test.expected {
+				t.Errorf("expected gas limit %d, got %d", test.expected, limit)
+			}
+		})
+	}
+}
+
+func TestWallet_EstimateGasLimitInvalid(t *testing.T) {
+	w := newTestWallet(t, "http://127.0.0.1:0")
+
+	_, err := w.estimateGasLimit(context.Background(), w.wallet.Address, &txCall{
+		To: common.HexToAddress("0xf37111de2f6ae2f64be1e59472b5c50801540c8c"),
+	}, map[string]interface{}{"gas_limit": "90000"})
+	if !errors.Is(err, errors.ErrInvalidConfig) {
+		t.Errorf("expected invalid config error, got %v", err)
+	}
+}
+
+func TestWallet_EstimateGasLimitAboveCap(t *testing.T) {
+	server := rpctest.NewServer(t, map[string]interface{}{"eth_estimateGas": "0xc350"})
+	defer server.Close()
+
+	w := newTestWallet(t, server.URL)
+
+	// a limit lowered under the estimation would run out of gas and still burn the fee
+	_, err := w.estimateGasLimit(context.Background(), w.wallet.Address, &txCall{
+		To:   common.HexToAddress("0xf37111de2f6ae2f64be1e59472b5c50801540c8c"),
+		Data: []byte{0x1},
+	}, w.mergeOptions(nil, defaultErc20Fee, map[string]interface{}{"gas_limit_cap": 40_000}))
+	if err == nil {
+		t.Error("expected an error for an estimation above the cap")
+	}
+}
@@ -0,0 +1,36 @@
+package evm
+
+import (
+	"testing"
+
+	"github.com/zsmartex/multichain/pkg/blockchain"
+	"github.com/zsmartex/multichain/pkg/currency"
+	"github.com/zsmartex/multichain/pkg/wallet"
+)
+
+func newTestWallet(t *testing.T, uri string) *Wallet {
+	w := NewWallet().(*Wallet)
+	if err := w.Configure(&wallet.Setting{
+		Wallet:   &wallet.SettingWallet{URI: uri, Address: "0x249aeb18f3a323c12334a595cb6220912c4b9087"},
+		Currency: &currency.Currency{ID: "ETH", Subunits: 18},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return w
+}
+
+func newTestBlockchain(t *testing.T, uri string) *Blockchain {
+	bl := NewBlockchain().(*Blockchain)
+	if err := bl.Configure(&blockchain.Setting{
+		URI: uri,
+		Currencies: []*currency.Currency{
+			{ID: "BSC", Subunits: 18},
+			{ID: "USDT", Subunits: 6, Options: map[string]interface{}{"erc20_contract_address": "0x337610d27c682e347c9cd60bd4b3b107c9d34ddd"}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	return bl
+}
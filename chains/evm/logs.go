@@ -0,0 +1,186 @@
+package evm
+
+import (
+	"context"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/rpc"
+	"github.com/zsmartex/multichain/pkg/transaction"
+)
+
+// logsBlockRange the max number of blocks queried by a single eth_getLogs, providers reject wider ranges
+const logsBlockRange = 2_000
+
+// GetTokenTransfers return the transfers of the configured ERC20 contracts between fromBlock and toBlock included
+// using eth_getLogs instead of walking the receipts of every transaction, only transfers to recipients are returned when given.
+// Unlike GetBlockByNumber it never returns failed transfers, a reverted transaction has no logs so it can't be found by them
+func (b *Blockchain) GetTokenTransfers(ctx context.Context, fromBlock, toBlock int64, recipients ...string) ([]*transaction.Transaction, error) {
+	transactions := make([]*transaction.Transaction, 0)
+	if len(b.contracts) == 0 {
+		return transactions, nil
+	}
+
+	addresses := make([]common.Address, len(b.contracts))
+	for i, c := range b.contracts {
+		addresses[i] = common.HexToAddress(c.Options["erc20_contract_address"].(string))
+	}
+
+	topics := [][]common.Hash{{common.HexToHash(tokenEventIdentifier)}}
+	if len(recipients) > 0 {
+		recipientTopics := make([]common.Hash, len(recipients))
+		for i, recipient := range recipients {
+			recipientTopics[i] = common.BytesToHash(common.HexToAddress(recipient).Bytes())
+		}
+
+		topics = append(topics, nil, recipientTopics)
+	}
+
+	logs := make([]types.Log, 0)
+	for start := fromBlock; start <= toBlock; start += logsBlockRange {
+		end := start + logsBlockRange - 1
+		if end > toBlock {
+			end = toBlock
+		}
+
+		result, err := b.client.FilterLogs(ctx, ethereum.FilterQuery{
+			FromBlock: big.NewInt(start),
+			ToBlock:   big.NewInt(end),
+			Addresses: addresses,
+			Topics:    topics,
+		})
+		if err != nil {
+			return nil, mapError(err, errors.ErrBlockNotFound)
+		}
+
+		logs = append(logs, result...)
+	}
+
+	hashes := make([]common.Hash, 0)
+	seen := make(map[common.Hash]bool)
+	for _, l := range logs {
+		if !l.Removed && !seen[l.TxHash] {
+			seen[l.TxHash] = true
+			hashes = append(hashes, l.TxHash)
+		}
+	}
+
+	if len(hashes) == 0 {
+		return transactions, nil
+	}
+
+	receipts, err := b.batchReceipts(ctx, hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	receiptFees, err := b.receiptFees(ctx, receipts)
+	if err != nil {
+		return nil, err
+	}
+
+	fees := make(map[common.Hash]decimal.Decimal, len(receipts))
+	receiptsByHash := make(map[common.Hash]*receipt, len(receipts))
+	for i, r := range receipts {
+		fees[r.TxHash] = receiptFees[i]
+		receiptsByHash[r.TxHash] = r
+	}
+
+	latestBlockNumber, err := b.GetLatestBlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range logs {
+		l := &logs[i]
+		if l.Removed {
+			continue
+		}
+
+		r := receiptsByHash[l.TxHash]
+		if t := b.buildERC20Transfer(l, &r.Receipt, fees[l.TxHash]); t != nil {
+			b.setting.ApplyConfirmations(latestBlockNumber-t.BlockNumber+1, t)
+			transactions = append(transactions, t)
+		}
+	}
+
+	return transactions, nil
+}
+
+// blockBaseFee is the only field of eth_getBlockByNumber needed by receiptFees
+type blockBaseFee struct {
+	BaseFee *hexutil.Big `json:"baseFeePerGas"`
+}
+
+// receiptFees return the fee of each receipt, when the node don't report effectiveGasPrice
+// the transactions and the base fees of their blocks are fetched in batches to compute it
+func (b *Blockchain) receiptFees(ctx context.Context, receipts []*receipt) ([]decimal.Decimal, error) {
+	txs := make(map[common.Hash]*types.Transaction)
+	baseFees := make(map[uint64]*big.Int)
+	blocks := make(map[uint64]bool)
+	elems := make([]rpc.BatchElem, 0)
+	for _, r := range receipts {
+		if r.EffectiveGasPrice != nil {
+			continue
+		}
+
+		if _, ok := txs[r.TxHash]; !ok {
+			txs[r.TxHash] = nil
+			elems = append(elems, rpc.BatchElem{Method: "eth_getTransactionByHash", Params: []interface{}{r.TxHash}})
+		}
+
+		if number := r.BlockNumber.Uint64(); !blocks[number] {
+			blocks[number] = true
+			elems = append(elems, rpc.BatchElem{Method: "eth_getBlockByNumber", Params: []interface{}{hexutil.EncodeUint64(number), false}})
+		}
+	}
+
+	if len(elems) > 0 {
+		results := make([]interface{}, len(elems))
+		for i := range elems {
+			if elems[i].Method == "eth_getTransactionByHash" {
+				results[i] = new(*types.Transaction)
+			} else {
+				results[i] = new(*blockBaseFee)
+			}
+			elems[i].Result = results[i]
+		}
+
+		if err := b.batchCall(ctx, elems, errors.ErrTxNotFound); err != nil {
+			return nil, err
+		}
+
+		for i, elem := range elems {
+			switch result := results[i].(type) {
+			case **types.Transaction:
+				if *result == nil {
+					return nil, errors.ErrTxNotFound
+				}
+				txs[elem.Params[0].(common.Hash)] = *result
+			case **blockBaseFee:
+				if *result == nil {
+					return nil, errors.ErrBlockNotFound
+				}
+
+				number, err := hexutil.DecodeUint64(elem.Params[0].(string))
+				if err != nil {
+					return nil, err
+				}
+				baseFees[number] = (*big.Int)((*result).BaseFee)
+			}
+		}
+	}
+
+	fees := make([]decimal.Decimal, len(receipts))
+	for i, r := range receipts {
+		fees[i] = decimal.NewFromBigInt(r.Fee(txs[r.TxHash], baseFees[r.BlockNumber.Uint64()]), -b.currency.Subunits)
+	}
+
+	return fees, nil
+}
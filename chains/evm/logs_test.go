@@ -0,0 +1,118 @@
+package evm
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/zsmartex/multichain/internal/rpctest"
+	"github.com/zsmartex/multichain/pkg/transaction"
+)
+
+func TestBlockchain_GetTokenTransfers(t *testing.T) {
+	txHash := common.HexToHash("0x01")
+	recipient := "0xf37111de2f6ae2f64be1e59472b5c50801540c8c"
+
+	transferLog := func(topics ...string) map[string]interface{} {
+		return map[string]interface{}{
+			"address":          "0x337610d27c682e347c9cd60bd4b3b107c9d34ddd",
+			"topics":           topics,
+			"data":             "0x00000000000000000000000000000000000000000000000000000000000f4240",
+			"blockNumber":      "0x10",
+			"blockHash":        common.HexToHash("0x02").Hex(),
+			"transactionHash":  txHash.Hex(),
+			"transactionIndex": "0x0",
+			"logIndex":         "0x0",
+			"removed":          false,
+		}
+	}
+
+	var filter map[string]interface{}
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"eth_blockNumber": "0x11",
+		"eth_getLogs": func(params []json.RawMessage) interface{} {
+			json.Unmarshal(params[0], &filter)
+
+			return []interface{}{
+				transferLog(tokenEventIdentifier, common.HexToHash("0x249aeb18f3a323c12334a595cb6220912c4b9087").Hex(), common.HexToHash(recipient).Hex()),
+				// ERC721 transfer
+				transferLog(tokenEventIdentifier, common.HexToHash("0x249aeb18f3a323c12334a595cb6220912c4b9087").Hex(), common.HexToHash(recipient).Hex(), common.HexToHash("0x1").Hex()),
+			}
+		},
+		"eth_getTransactionReceipt": receiptJSON(txHash),
+	})
+	defer server.Close()
+
+	bl := newTestBlockchain(t, server.URL)
+
+	transactions, err := bl.GetTokenTransfers(context.Background(), 16, 16, recipient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if topics := filter["topics"].([]interface{}); len(topics) != 3 || topics[1] != nil {
+		t.Errorf("unexpected topics filter %v", filter["topics"])
+	}
+
+	if len(transactions) != 1 {
+		t.Fatalf("expected 1 transfer, got %d", len(transactions))
+	}
+
+	tx := transactions[0]
+	if tx.UniqueID() != txHash.Hex()+":0" {
+		t.Errorf("unexpected unique id %s", tx.UniqueID())
+	}
+
+	if tx.Currency != "USDT" || tx.ToAddress != recipient || tx.Amount.String() != "1" || tx.TxHash.String != txHash.Hex() {
+		t.Errorf("unexpected transfer %+v", tx)
+	}
+
+	// 21000 gas at an effective gas price of 100 wei
+	if tx.Fee.Decimal.Shift(18).String() != "2100000" || tx.Confirmations != 2 || tx.Status != transaction.StatusSucceed {
+		t.Errorf("unexpected fee %s, confirmations %d or status %s", tx.Fee.Decimal, tx.Confirmations, tx.Status)
+	}
+}
+
+func TestBlockchain_ReceiptFeesBaseFee(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	to := common.HexToAddress("0xf37111de2f6ae2f64be1e59472b5c50801540c8c")
+	tx, err := types.SignNewTx(key, types.LatestSignerForChainID(big.NewInt(1)), &types.DynamicFeeTx{
+		ChainID:   big.NewInt(1),
+		GasTipCap: big.NewInt(2),
+		GasFeeCap: big.NewInt(1_000),
+		Gas:       21_000,
+		To:        &to,
+		Value:     big.NewInt(1),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"eth_getTransactionByHash": tx,
+		// only the base fee of the block is decoded
+		"eth_getBlockByNumber": map[string]interface{}{"baseFeePerGas": "0x64"},
+	})
+	defer server.Close()
+
+	bl := newTestBlockchain(t, server.URL)
+
+	fees, err := bl.receiptFees(context.Background(), []*receipt{newTestReceipt(t, "")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 21000 gas at the base fee of 100 wei and the tip of 2 wei
+	if len(fees) != 1 || fees[0].Shift(18).String() != "2142000" {
+		t.Errorf("unexpected fees %v", fees)
+	}
+}
@@ -0,0 +1,136 @@
+package evm
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/shopspring/decimal"
+	"github.com/volatiletech/null/v9"
+
+	"github.com/zsmartex/multichain/pkg/currency"
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/transaction"
+)
+
+var erc721AbiDefinition = `[{"inputs":[{"name":"_from","type":"address"},{"name":"_to","type":"address"},{"name":"_tokenId","type":"uint256"}],"name":"safeTransferFrom","outputs":[],"stateMutability":"nonpayable","type":"function"}]`
+var erc1155AbiDefinition = `[{"inputs":[{"name":"_from","type":"address"},{"name":"_to","type":"address"},{"name":"_id","type":"uint256"},{"name":"_value","type":"uint256"},{"name":"_data","type":"bytes"}],"name":"safeTransferFrom","outputs":[],"stateMutability":"nonpayable","type":"function"},{"anonymous":false,"inputs":[{"indexed":true,"name":"_operator","type":"address"},{"indexed":true,"name":"_from","type":"address"},{"indexed":true,"name":"_to","type":"address"},{"indexed":false,"name":"_ids","type":"uint256[]"},{"indexed":false,"name":"_values","type":"uint256[]"}],"name":"TransferBatch","type":"event"}]`
+
+// ErrERC1155Balance is returned for the balance of an ERC1155 currency, its tokens are held per token id
+var ErrERC1155Balance = errors.New("the balance of an ERC1155 currency is held per token id")
+
+var erc1155TransferSingleIdentifier = "0xc3d58168c5ae7397731d063d5bbf3d657854427343f4c083240f7aacaa2d0f62"
+var erc1155TransferBatchIdentifier = "0x4a39dc06d4c0dbc64b70af90fd698a233a518aa5d07e595d983b8c0526c8f7fb"
+
+// nftContractAddress return the erc721_contract_address or erc1155_contract_address of c
+func nftContractAddress(c *currency.Currency) string {
+	if address, ok := c.Options["erc721_contract_address"].(string); ok {
+		return address
+	}
+
+	address, _ := c.Options["erc1155_contract_address"].(string)
+
+	return address
+}
+
+// packNFTTransfer encode the safeTransferFrom call data moving tokenID of the contract of c from from to to,
+// amount is only used by ERC1155 contracts
+func packNFTTransfer(c *currency.Currency, from, to common.Address, tokenID string, amount decimal.Decimal) ([]byte, error) {
+	id, ok := new(big.Int).SetString(tokenID, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid token id %q", tokenID)
+	}
+
+	if c.Options["erc721_contract_address"] != nil {
+		abiJSON, err := abi.JSON(strings.NewReader(erc721AbiDefinition))
+		if err != nil {
+			return nil, err
+		}
+
+		return abiJSON.Pack("safeTransferFrom", from, to, id)
+	}
+
+	abiJSON, err := abi.JSON(strings.NewReader(erc1155AbiDefinition))
+	if err != nil {
+		return nil, err
+	}
+
+	return abiJSON.Pack("safeTransferFrom", from, to, id, amount.Shift(c.Subunits).BigInt(), []byte{})
+}
+
+// buildNFTTransfers parse the ERC721 Transfer and ERC1155 TransferSingle and TransferBatch events of the configured contracts
+func (b *Blockchain) buildNFTTransfers(l *types.Log, receipt *types.Receipt, fee decimal.Decimal) ([]*transaction.Transaction, error) {
+	var c *currency.Currency
+	for _, contract := range b.nftContracts {
+		if strings.EqualFold(nftContractAddress(contract), l.Address.Hex()) {
+			c = contract
+			break
+		}
+	}
+
+	if c == nil || len(l.Topics) != 4 {
+		return nil, nil
+	}
+
+	newTransfer := func(from, to common.Hash, tokenID *big.Int, amount decimal.Decimal) *transaction.Transaction {
+		return &transaction.Transaction{
+			Currency:    c.ID,
+			CurrencyFee: b.currency.ID,
+			TxHash:      null.StringFrom(receipt.TxHash.Hex()),
+			Position:    null.Int64From(int64(l.Index)),
+			TokenID:     tokenID.String(),
+			FromAddress: fmt.Sprintf("0x%s", from.Hex()[26:]),
+			ToAddress:   fmt.Sprintf("0x%s", to.Hex()[26:]),
+			Fee:         decimal.NewNullDecimal(fee),
+			Amount:      amount,
+			BlockNumber: receipt.BlockNumber.Int64(),
+			Status:      b.transactionStatus(receipt),
+		}
+	}
+
+	switch {
+	case c.Options["erc721_contract_address"] != nil && l.Topics[0].Hex() == tokenEventIdentifier:
+		return []*transaction.Transaction{
+			newTransfer(l.Topics[1], l.Topics[2], l.Topics[3].Big(), decimal.NewFromInt(1)),
+		}, nil
+	case c.Options["erc1155_contract_address"] != nil && l.Topics[0].Hex() == erc1155TransferSingleIdentifier:
+		if len(l.Data) != 64 {
+			return nil, nil
+		}
+
+		id := new(big.Int).SetBytes(l.Data[:32])
+		value := new(big.Int).SetBytes(l.Data[32:])
+
+		return []*transaction.Transaction{
+			newTransfer(l.Topics[2], l.Topics[3], id, decimal.NewFromBigInt(value, -c.Subunits)),
+		}, nil
+	case c.Options["erc1155_contract_address"] != nil && l.Topics[0].Hex() == erc1155TransferBatchIdentifier:
+		abiJSON, err := abi.JSON(strings.NewReader(erc1155AbiDefinition))
+		if err != nil {
+			return nil, err
+		}
+
+		values, err := abiJSON.Unpack("TransferBatch", l.Data)
+		if err != nil || len(values) != 2 {
+			return nil, nil
+		}
+
+		ids, _ := values[0].([]*big.Int)
+		amounts, _ := values[1].([]*big.Int)
+		if len(ids) != len(amounts) {
+			return nil, nil
+		}
+
+		transactions := make([]*transaction.Transaction, len(ids))
+		for i := range ids {
+			transactions[i] = newTransfer(l.Topics[2], l.Topics[3], ids[i], decimal.NewFromBigInt(amounts[i], -c.Subunits))
+		}
+
+		return transactions, nil
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,352 @@
+package evm
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/multichain/internal/rpctest"
+	"github.com/zsmartex/multichain/pkg/blockchain"
+	"github.com/zsmartex/multichain/pkg/currency"
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/transaction"
+	"github.com/zsmartex/multichain/pkg/wallet"
+)
+
+func TestBlockchain_BuildNFTTransfers(t *testing.T) {
+	erc721 := common.HexToAddress("0x0000000000000000000000000000000000000721")
+	erc1155 := common.HexToAddress("0x0000000000000000000000000000000000001155")
+	operator := common.HexToHash("0x01")
+	from := common.HexToHash("0x249aeb18f3a323c12334a595cb6220912c4b9087")
+	to := common.HexToHash("0xf37111de2f6ae2f64be1e59472b5c50801540c8c")
+
+	bl := &Blockchain{
+		currency: &currency.Currency{ID: "ETH", Subunits: 18},
+		nftContracts: []*currency.Currency{
+			{ID: "PUNK", Options: map[string]interface{}{"erc721_contract_address": erc721.Hex()}},
+			{ID: "ITEM", Options: map[string]interface{}{"erc1155_contract_address": erc1155.Hex()}},
+		},
+	}
+
+	uint256Array, _ := abi.NewType("uint256[]", "", nil)
+	batchData, err := abi.Arguments{{Type: uint256Array}, {Type: uint256Array}}.Pack(
+		[]*big.Int{big.NewInt(7), big.NewInt(8)},
+		[]*big.Int{big.NewInt(2), big.NewInt(3)},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		log      *types.Log
+		expected []string
+	}{
+		{
+			name:     "erc721 transfer",
+			log:      &types.Log{Address: erc721, Topics: []common.Hash{common.HexToHash(tokenEventIdentifier), from, to, common.BigToHash(big.NewInt(42))}},
+			expected: []string{"PUNK 42 1"},
+		},
+		{
+			name:     "erc1155 transfer single",
+			log:      &types.Log{Address: erc1155, Topics: []common.Hash{common.HexToHash(erc1155TransferSingleIdentifier), operator, from, to}, Data: append(common.BigToHash(big.NewInt(5)).Bytes(), common.BigToHash(big.NewInt(10)).Bytes()...)},
+			expected: []string{"ITEM 5 10"},
+		},
+		{
+			name:     "erc1155 transfer batch",
+			log:      &types.Log{Address: erc1155, Topics: []common.Hash{common.HexToHash(erc1155TransferBatchIdentifier), operator, from, to}, Data: batchData},
+			expected: []string{"ITEM 7 2", "ITEM 8 3"},
+		},
+		{
+			name: "unknown contract",
+			log:  &types.Log{Address: common.HexToAddress("0x02"), Topics: []common.Hash{common.HexToHash(tokenEventIdentifier), from, to, common.BigToHash(big.NewInt(42))}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			receipt := &types.Receipt{Status: types.ReceiptStatusSuccessful, BlockNumber: big.NewInt(1), Logs: []*types.Log{test.log}}
+
+			transactions, err := bl.buildNFTTransfers(test.log, receipt, decimal.Zero)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(transactions) != len(test.expected) {
+				t.Fatalf("expected %d transfers, got %d", len(test.expected), len(transactions))
+			}
+
+			for i, tx := range transactions {
+				if got := tx.Currency + " " + tx.TokenID + " " + tx.Amount.String(); got != test.expected[i] {
+					t.Errorf("expected transfer %s, got %s", test.expected[i], got)
+				}
+
+				if tx.ToAddress != "0xf37111de2f6ae2f64be1e59472b5c50801540c8c" || tx.Status != transaction.StatusSucceed {
+					t.Errorf("unexpected transfer %+v", tx)
+				}
+			}
+		})
+	}
+}
+
+func TestWallet_CreateNFTTransaction(t *testing.T) {
+	var sent map[string]string
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"eth_chainId":             "0x1",
+		"eth_getTransactionCount": "0x0",
+		"eth_gasPrice":            "0x3e8",
+		"eth_estimateGas":         "0xc350",
+		"personal_sendTransaction": func(params []json.RawMessage) interface{} {
+			json.Unmarshal(params[0], &sent)
+			return "0x02"
+		},
+	})
+	defer server.Close()
+
+	w := NewWallet()
+	if err := w.Configure(&wallet.Setting{
+		Wallet: &wallet.SettingWallet{URI: server.URL, Address: "0x249aeb18f3a323c12334a595cb6220912c4b9087"},
+		Currency: &currency.Currency{
+			ID:      "PUNK",
+			Options: map[string]interface{}{"erc721_contract_address": "0x0000000000000000000000000000000000000721", "tx_type": TxTypeLegacy},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := w.CreateTransaction(context.Background(), &transaction.Transaction{
+		ToAddress: "0xf37111de2f6ae2f64be1e59472b5c50801540c8c",
+		TokenID:   "42",
+		Amount:    decimal.NewFromInt(1),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tx.TxHash.String != "0x02" || sent["to"] != "0x0000000000000000000000000000000000000721" {
+		t.Errorf("unexpected transaction %+v sent with %v", tx, sent)
+	}
+
+	// safeTransferFrom(address,address,uint256)
+	data := hexutil.MustDecode(sent["data"])
+	if hexutil.Encode(data[:4]) != "0x42842e0e" || new(big.Int).SetBytes(data[68:100]).Int64() != 42 {
+		t.Errorf("unexpected call data %s", sent["data"])
+	}
+
+	if !strings.EqualFold(common.BytesToAddress(data[4:36]).Hex(), "0x249aeb18f3a323c12334a595cb6220912c4b9087") {
+		t.Errorf("unexpected sender in call data %s", sent["data"])
+	}
+}
+
+func TestWallet_CreateNFTTransactionLocalSigner(t *testing.T) {
+	var sentTx *types.Transaction
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"eth_chainId":             "0x1",
+		"eth_getTransactionCount": "0x0",
+		"eth_gasPrice":            "0x3e8",
+		"eth_estimateGas":         "0xc350",
+		"eth_sendRawTransaction": func(params []json.RawMessage) interface{} {
+			var raw string
+			json.Unmarshal(params[0], &raw)
+
+			sentTx = new(types.Transaction)
+			if err := sentTx.UnmarshalBinary(hexutil.MustDecode(raw)); err != nil {
+				t.Error(err)
+				return &rpctest.Error{Code: -32000, Message: err.Error()}
+			}
+
+			return sentTx.Hash().Hex()
+		},
+	})
+	defer server.Close()
+
+	address, secret, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the address is derived from the key
+	w := NewWallet()
+	if err := w.Configure(&wallet.Setting{
+		Wallet: &wallet.SettingWallet{URI: server.URL, Secret: secret},
+		Currency: &currency.Currency{
+			ID:      "PUNK",
+			Options: map[string]interface{}{"erc721_contract_address": "0x0000000000000000000000000000000000000721", "signer": SignerLocal, "tx_type": TxTypeLegacy},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.CreateTransaction(context.Background(), &transaction.Transaction{
+		ToAddress: "0xf37111de2f6ae2f64be1e59472b5c50801540c8c",
+		TokenID:   "42",
+		Amount:    decimal.NewFromInt(1),
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if sentTx == nil {
+		t.Fatal("expected a signed transaction to be sent")
+	}
+
+	if from := common.BytesToAddress(sentTx.Data()[4:36]); !strings.EqualFold(from.Hex(), address) {
+		t.Errorf("expected the tokens to be sent from %s, got %s", address, from.Hex())
+	}
+}
+
+func TestWallet_LoadNFTBalance(t *testing.T) {
+	var balanceOf map[string]string
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"eth_call": func(params []json.RawMessage) interface{} {
+			json.Unmarshal(params[0], &balanceOf)
+			return hexutil.Encode(common.BigToHash(big.NewInt(3)).Bytes())
+		},
+	})
+	defer server.Close()
+
+	tests := []struct {
+		name     string
+		options  map[string]interface{}
+		expected string
+		err      error
+	}{
+		{
+			name:     "erc721",
+			options:  map[string]interface{}{"erc721_contract_address": "0x0000000000000000000000000000000000000721"},
+			expected: "3",
+		},
+		{
+			name:    "erc1155",
+			options: map[string]interface{}{"erc1155_contract_address": "0x0000000000000000000000000000000000001155"},
+			err:     ErrERC1155Balance,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := NewWallet()
+			if err := w.Configure(&wallet.Setting{
+				Wallet:   &wallet.SettingWallet{URI: server.URL, Address: "0x249aeb18f3a323c12334a595cb6220912c4b9087"},
+				Currency: &currency.Currency{ID: "PUNK", Options: test.options},
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			balance, err := w.LoadBalance(context.Background())
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("expected %v, got %v", test.err, err)
+				}
+
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if balance.String() != test.expected || balanceOf["to"] != "0x0000000000000000000000000000000000000721" {
+				t.Errorf("unexpected balance %s from %v", balance, balanceOf)
+			}
+		})
+	}
+}
+
+func TestBlockchain_GetNFTBalanceOfAddress(t *testing.T) {
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"eth_blockNumber": "0x10",
+		"eth_call":        hexutil.Encode(common.BigToHash(big.NewInt(3)).Bytes()),
+	})
+	defer server.Close()
+
+	bl := NewBlockchain().(*Blockchain)
+	if err := bl.Configure(&blockchain.Setting{
+		URI: server.URL,
+		Currencies: []*currency.Currency{
+			{ID: "ETH", Subunits: 18},
+			{ID: "PUNK", Options: map[string]interface{}{"erc721_contract_address": "0x0000000000000000000000000000000000000721"}},
+			{ID: "ITEM", Options: map[string]interface{}{"erc1155_contract_address": "0x0000000000000000000000000000000000001155"}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	balance, err := bl.GetBalanceOfAddress(context.Background(), "0x249aeb18f3a323c12334a595cb6220912c4b9087", "PUNK")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if balance.String() != "3" {
+		t.Errorf("expected 3 tokens, got %s", balance)
+	}
+
+	if _, err := bl.GetBalanceOfAddress(context.Background(), "0x249aeb18f3a323c12334a595cb6220912c4b9087", "ITEM"); !errors.Is(err, ErrERC1155Balance) {
+		t.Errorf("expected ErrERC1155Balance, got %v", err)
+	}
+}
+
+func TestWallet_PrepareNFTDepositCollection(t *testing.T) {
+	var estimated map[string]string
+	var sent map[string]string
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"eth_chainId":             "0x1",
+		"eth_getTransactionCount": "0x0",
+		"eth_gasPrice":            "0x3e8",
+		"eth_estimateGas": func(params []json.RawMessage) interface{} {
+			var call map[string]string
+			json.Unmarshal(params[0], &call)
+			if call["to"] == "0x0000000000000000000000000000000000000721" {
+				estimated = call
+				return "0xc350"
+			}
+
+			return "0x5208"
+		},
+		"personal_sendTransaction": func(params []json.RawMessage) interface{} {
+			json.Unmarshal(params[0], &sent)
+			return "0x02"
+		},
+	})
+	defer server.Close()
+
+	w := NewWallet()
+	if err := w.Configure(&wallet.Setting{
+		Wallet:   &wallet.SettingWallet{URI: server.URL, Address: "0xf37111de2f6ae2f64be1e59472b5c50801540c8c"},
+		Currency: &currency.Currency{ID: "ETH", Subunits: 18, Options: map[string]interface{}{"tx_type": TxTypeLegacy}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	depositAddress := "0x249aeb18f3a323c12334a595cb6220912c4b9087"
+	tx, err := w.PrepareDepositCollection(context.Background(), &transaction.Transaction{ToAddress: depositAddress}, []*transaction.Transaction{
+		{ToAddress: "0xf37111de2f6ae2f64be1e59472b5c50801540c8c", TokenID: "42", Amount: decimal.NewFromInt(1)},
+	}, &currency.Currency{
+		ID:      "PUNK",
+		Options: map[string]interface{}{"erc721_contract_address": "0x0000000000000000000000000000000000000721", "tx_type": TxTypeLegacy},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tx == nil || sent == nil || !strings.EqualFold(sent["to"], depositAddress) {
+		t.Fatalf("expected the fee to be sent to the deposit address, got %v", sent)
+	}
+
+	// the safeTransferFrom of the token is estimated from the deposit address
+	data := hexutil.MustDecode(estimated["data"])
+	if !strings.EqualFold(estimated["from"], depositAddress) || hexutil.Encode(data[:4]) != "0x42842e0e" || !strings.EqualFold(common.BytesToAddress(data[4:36]).Hex(), depositAddress) {
+		t.Errorf("unexpected estimation %v", estimated)
+	}
+
+	// the 50000 gas estimation raised by the default multiplier at 1000 wei
+	if !tx.Amount.Equal(decimal.New(6, -11)) {
+		t.Errorf("unexpected collection fee %s", tx.Amount)
+	}
+}
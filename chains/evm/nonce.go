@@ -0,0 +1,161 @@
+package evm
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// NonceGapTimeout how long a submitted nonce is trusted before the node not knowing it is considered a gap
+var NonceGapTimeout = time.Minute
+
+// NonceKey identify the nonce sequence of an address on a chain
+type NonceKey struct {
+	ChainID string
+	Address string
+}
+
+func newNonceKey(chainID string, address string) NonceKey {
+	return NonceKey{
+		ChainID: chainID,
+		Address: strings.ToLower(address),
+	}
+}
+
+// NonceManager coordinate the nonces of concurrent transactions sent from the same address,
+// an implementation backed by a shared store let several processes send from the same address
+type NonceManager interface {
+	// Reserve return the nonce to use for a new transaction, pending is the eth_getTransactionCount(pending) of the node
+	Reserve(ctx context.Context, key NonceKey, pending uint64) (uint64, error)
+	// Release give back a reserved nonce whose transaction failed to be submitted
+	Release(ctx context.Context, key NonceKey, nonce uint64) error
+	// Commit mark a reserved nonce as submitted
+	Commit(ctx context.Context, key NonceKey, nonce uint64) error
+}
+
+// DefaultNonceManager is shared by every wallet of the process which don't set their own NonceManager
+var DefaultNonceManager NonceManager = NewMemoryNonceManager()
+
+type nonceState struct {
+	next      uint64
+	reserved  map[uint64]bool
+	submitted map[uint64]time.Time
+	released  []uint64 // ascending
+}
+
+// MemoryNonceManager is a NonceManager for a single process
+type MemoryNonceManager struct {
+	mu     sync.Mutex
+	states map[NonceKey]*nonceState
+}
+
+func NewMemoryNonceManager() *MemoryNonceManager {
+	return &MemoryNonceManager{
+		states: make(map[NonceKey]*nonceState),
+	}
+}
+
+func (m *MemoryNonceManager) state(key NonceKey) *nonceState {
+	s, ok := m.states[key]
+	if !ok {
+		s = &nonceState{
+			reserved:  make(map[uint64]bool),
+			submitted: make(map[uint64]time.Time),
+			released:  make([]uint64, 0),
+		}
+		m.states[key] = s
+	}
+
+	return s
+}
+
+func (m *MemoryNonceManager) Reserve(_ context.Context, key NonceKey, pending uint64) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.state(key)
+
+	// nonces below pending are known by the node
+	if pending > s.next {
+		s.next = pending
+	}
+
+	for nonce := range s.submitted {
+		if nonce < pending {
+			delete(s.submitted, nonce)
+		}
+	}
+
+	released := make([]uint64, 0, len(s.released))
+	for _, nonce := range s.released {
+		if nonce >= pending {
+			released = append(released, nonce)
+		}
+	}
+	s.released = released
+
+	// the node expect a nonce nobody hold anymore, a submitted transaction was dropped
+	if pending < s.next && !s.reserved[pending] && !s.isReleased(pending) {
+		submittedAt, ok := s.submitted[pending]
+		if !ok || time.Since(submittedAt) > NonceGapTimeout {
+			delete(s.submitted, pending)
+			s.release(pending)
+		}
+	}
+
+	var nonce uint64
+	if len(s.released) > 0 {
+		nonce = s.released[0]
+		s.released = s.released[1:]
+	} else {
+		nonce = s.next
+		s.next++
+	}
+
+	s.reserved[nonce] = true
+
+	return nonce, nil
+}
+
+func (m *MemoryNonceManager) Release(_ context.Context, key NonceKey, nonce uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.state(key)
+	if !s.reserved[nonce] {
+		return nil
+	}
+
+	delete(s.reserved, nonce)
+	s.release(nonce)
+
+	return nil
+}
+
+func (m *MemoryNonceManager) Commit(_ context.Context, key NonceKey, nonce uint64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := m.state(key)
+	delete(s.reserved, nonce)
+	s.submitted[nonce] = time.Now()
+
+	return nil
+}
+
+func (s *nonceState) isReleased(nonce uint64) bool {
+	i := sort.Search(len(s.released), func(i int) bool { return s.released[i] >= nonce })
+
+	return i < len(s.released) && s.released[i] == nonce
+}
+
+func (s *nonceState) release(nonce uint64) {
+	if s.isReleased(nonce) {
+		return
+	}
+
+	s.released = append(s.released, nonce)
+	sort.Slice(s.released, func(i, j int) bool { return s.released[i] < s.released[j] })
+}
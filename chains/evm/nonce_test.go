@@ -0,0 +1,105 @@
+package evm
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMemoryNonceManager_ReserveConcurrent(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryNonceManager()
+	key := newNonceKey("56", "0xF37111De2f6AE2f64Be1e59472b5C50801540C8c")
+
+	var mu sync.Mutex
+	seen := make(map[uint64]bool)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			nonce, err := m.Reserve(ctx, key, 5)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if seen[nonce] {
+				t.Errorf("nonce %d reserved twice", nonce)
+			}
+			seen[nonce] = true
+		}()
+	}
+	wg.Wait()
+
+	for nonce := uint64(5); nonce < 25; nonce++ {
+		if !seen[nonce] {
+			t.Errorf("expected nonce %d to be reserved", nonce)
+		}
+	}
+}
+
+func TestMemoryNonceManager_Release(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryNonceManager()
+	key := newNonceKey("1", "0xabc")
+
+	first, _ := m.Reserve(ctx, key, 0)
+	second, _ := m.Reserve(ctx, key, 0)
+	m.Commit(ctx, key, second)
+	m.Release(ctx, key, first)
+
+	if nonce, _ := m.Reserve(ctx, key, 0); nonce != first {
+		t.Errorf("expected released nonce %d to be reused, got %d", first, nonce)
+	}
+
+	if nonce, _ := m.Reserve(ctx, key, 2); nonce != 2 {
+		t.Errorf("expected nonce 2, got %d", nonce)
+	}
+}
+
+func TestMemoryNonceManager_Reconcile(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryNonceManager()
+	key := newNonceKey("1", "0xABC")
+
+	// transactions sent from outside the process move the node ahead
+	nonce, _ := m.Reserve(ctx, key, 0)
+	m.Commit(ctx, key, nonce)
+
+	if nonce, _ := m.Reserve(ctx, newNonceKey("1", "0xabc"), 10); nonce != 10 {
+		t.Errorf("expected nonce 10, got %d", nonce)
+	}
+}
+
+func TestMemoryNonceManager_Gap(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryNonceManager()
+	key := newNonceKey("1", "0xabc")
+
+	for i := 0; i < 3; i++ {
+		nonce, _ := m.Reserve(ctx, key, 0)
+		m.Commit(ctx, key, nonce)
+	}
+
+	// recently submitted transactions may not be known by the node yet
+	if nonce, _ := m.Reserve(ctx, key, 1); nonce != 3 {
+		t.Fatalf("expected nonce 3, got %d", nonce)
+	}
+	m.Commit(ctx, key, 3)
+
+	timeout := NonceGapTimeout
+	NonceGapTimeout = 0
+	defer func() { NonceGapTimeout = timeout }()
+	time.Sleep(time.Millisecond)
+
+	// the transaction with nonce 1 was dropped, the gap has to be filled
+	if nonce, _ := m.Reserve(ctx, key, 1); nonce != 1 {
+		t.Errorf("expected gap nonce 1, got %d", nonce)
+	}
+}
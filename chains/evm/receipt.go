@@ -0,0 +1,192 @@
+package evm
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"sync"
+	"sync/atomic"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/rpc"
+)
+
+// receipt is a transaction receipt with the fee fields go-ethereum don't decode,
+// L1Fee is the data fee paid to the L1 on optimistic rollups
+type receipt struct {
+	types.Receipt
+	EffectiveGasPrice *big.Int
+	L1Fee             *big.Int
+}
+
+func (r *receipt) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &r.Receipt); err != nil {
+		return err
+	}
+
+	var fees struct {
+		EffectiveGasPrice *hexutil.Big `json:"effectiveGasPrice"`
+		L1Fee             *hexutil.Big `json:"l1Fee"`
+	}
+	if err := json.Unmarshal(data, &fees); err != nil {
+		return err
+	}
+
+	r.EffectiveGasPrice = (*big.Int)(fees.EffectiveGasPrice)
+	r.L1Fee = (*big.Int)(fees.L1Fee)
+
+	return nil
+}
+
+// Fee return the fee paid by tx in base units, nodes without effectiveGasPrice
+// use baseFee to compute the price paid by a dynamic fee transaction
+func (r *receipt) Fee(tx *types.Transaction, baseFee *big.Int) *big.Int {
+	price := r.EffectiveGasPrice
+	if price == nil {
+		price = tx.GasPrice()
+		if tx.Type() == types.DynamicFeeTxType && baseFee != nil {
+			price = new(big.Int).Add(baseFee, tx.GasTipCap())
+			if price.Cmp(tx.GasFeeCap()) > 0 {
+				price = tx.GasFeeCap()
+			}
+		}
+	}
+
+	fee := new(big.Int).Mul(price, new(big.Int).SetUint64(r.GasUsed))
+	if r.L1Fee != nil {
+		fee.Add(fee, r.L1Fee)
+	}
+
+	return fee
+}
+
+const (
+	// receiptBatchSize the number of eth_getTransactionReceipt sent in a single batch
+	receiptBatchSize = 100
+	// receiptConcurrency the number of receipt batches requested at the same time
+	receiptConcurrency = 4
+)
+
+// blockReceipts return the receipts of the transactions of blk in the same order,
+// eth_getBlockReceipts is used when the node support it and batched eth_getTransactionReceipt otherwise
+func (b *Blockchain) blockReceipts(ctx context.Context, blk *types.Block) ([]*receipt, error) {
+	txs := blk.Transactions()
+	if len(txs) == 0 {
+		return []*receipt{}, nil
+	}
+
+	if atomic.LoadInt32(&b.noBlockReceipts) == 0 {
+		var receipts []*receipt
+		err := b.rpcClient.Call(ctx, &receipts, "eth_getBlockReceipts", blk.Hash())
+		if err == nil && len(receipts) == len(txs) {
+			return orderReceipts(txs, receipts)
+		}
+
+		if err != nil && !errors.Is(err, rpc.ErrNullResult) {
+			if !isMethodNotFound(err) {
+				return nil, mapError(err, errors.ErrBlockNotFound)
+			}
+
+			atomic.StoreInt32(&b.noBlockReceipts, 1)
+		}
+	}
+
+	hashes := make([]common.Hash, len(txs))
+	for i, tx := range txs {
+		hashes[i] = tx.Hash()
+	}
+
+	return b.batchReceipts(ctx, hashes)
+}
+
+// batchReceipts return the receipts of hashes in the same order
+func (b *Blockchain) batchReceipts(ctx context.Context, hashes []common.Hash) ([]*receipt, error) {
+	receipts := make([]*receipt, len(hashes))
+	elems := make([]rpc.BatchElem, len(hashes))
+	for i, hash := range hashes {
+		elems[i] = rpc.BatchElem{
+			Method: "eth_getTransactionReceipt",
+			Params: []interface{}{hash},
+			Result: &receipts[i],
+		}
+	}
+
+	if err := b.batchCall(ctx, elems, errors.ErrTxNotFound); err != nil {
+		return nil, err
+	}
+
+	for i := range elems {
+		if receipts[i] == nil {
+			return nil, errors.ErrTxNotFound
+		}
+	}
+
+	return receipts, nil
+}
+
+// batchCall send elems in concurrent batches of receiptBatchSize, notFound is used for null results
+func (b *Blockchain) batchCall(ctx context.Context, elems []rpc.BatchElem, notFound error) error {
+	var wg sync.WaitGroup
+	var once sync.Once
+	var batchErr error
+
+	sem := make(chan struct{}, receiptConcurrency)
+	for start := 0; start < len(elems); start += receiptBatchSize {
+		end := start + receiptBatchSize
+		if end > len(elems) {
+			end = len(elems)
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(batch []rpc.BatchElem) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+
+			if err := b.rpcClient.BatchCall(ctx, batch); err != nil {
+				once.Do(func() { batchErr = err })
+			}
+		}(elems[start:end])
+	}
+	wg.Wait()
+
+	if batchErr != nil {
+		return mapError(batchErr, notFound)
+	}
+
+	for _, elem := range elems {
+		if elem.Error != nil {
+			return mapError(elem.Error, notFound)
+		}
+	}
+
+	return nil
+}
+
+// orderReceipts match the receipts to txs by hash
+func orderReceipts(txs types.Transactions, receipts []*receipt) ([]*receipt, error) {
+	byHash := make(map[common.Hash]*receipt, len(receipts))
+	for _, r := range receipts {
+		if r != nil {
+			byHash[r.TxHash] = r
+		}
+	}
+
+	ordered := make([]*receipt, len(txs))
+	for i, tx := range txs {
+		r, ok := byHash[tx.Hash()]
+		if !ok {
+			return nil, errors.ErrTxNotFound
+		}
+
+		ordered[i] = r
+	}
+
+	return ordered, nil
+}
@@ -0,0 +1,189 @@
+package evm
+
+import (
+	"context"
+	"encoding/json"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/zsmartex/multichain/internal/rpctest"
+	"github.com/zsmartex/multichain/pkg/rpc"
+)
+
+func receiptJSON(hash common.Hash) map[string]interface{} {
+	return map[string]interface{}{
+		"transactionHash":   hash.Hex(),
+		"blockNumber":       "0x10",
+		"cumulativeGasUsed": "0x5208",
+		"gasUsed":           "0x5208",
+		"effectiveGasPrice": "0x64",
+		"logsBloom":         "0x" + strings.Repeat("00", 256),
+		"logs":              []interface{}{},
+		"status":            "0x1",
+	}
+}
+
+func newTestBlock(size int) *types.Block {
+	to := common.HexToAddress("0xf37111de2f6ae2f64be1e59472b5c50801540c8c")
+
+	txs := make([]*types.Transaction, size)
+	for i := range txs {
+		txs[i] = types.NewTx(&types.LegacyTx{Nonce: uint64(i), GasPrice: big.NewInt(100), Gas: 21_000, To: &to, Value: big.NewInt(1)})
+	}
+
+	return types.NewBlockWithHeader(&types.Header{Number: big.NewInt(16)}).WithBody(txs, nil)
+}
+
+func newTestReceipt(t *testing.T, fields string) *receipt {
+	raw := `{
+		"transactionHash": "0x2e5f5a0ef3e5c5b3fa9b6c9e0d7c7e1b9a2d6e9c0d7b6c1a2b3c4d5e6f7a8b9c",
+		"blockNumber": "0x10",
+		"cumulativeGasUsed": "0x5208",
+		"gasUsed": "0x5208",
+		"logsBloom": "0x` + strings.Repeat("00", 256) + `",
+		"logs": [],
+		"status": "0x1"` + fields + `
+	}`
+
+	r := new(receipt)
+	if err := json.Unmarshal([]byte(raw), r); err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestReceipt_Fee(t *testing.T) {
+	to := common.HexToAddress("0xf37111de2f6ae2f64be1e59472b5c50801540c8c")
+	tx := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   big.NewInt(1),
+		GasTipCap: big.NewInt(2),
+		GasFeeCap: big.NewInt(1_000),
+		Gas:       21_000,
+		To:        &to,
+		Value:     big.NewInt(1),
+	})
+
+	tests := []struct {
+		name     string
+		fields   string
+		baseFee  *big.Int
+		expected int64
+	}{
+		{name: "effective gas price", fields: `, "effectiveGasPrice": "0x64"`, expected: 21_000 * 100},
+		{name: "rollup l1 fee", fields: `, "effectiveGasPrice": "0x64", "l1Fee": "0x3e8"`, expected: 21_000*100 + 1_000},
+		{name: "base fee", baseFee: big.NewInt(50), expected: 21_000 * 52},
+		{name: "fee cap", expected: 21_000 * 1_000},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := newTestReceipt(t, test.fields)
+
+			if fee := r.Fee(tx, test.baseFee); fee.Int64() != test.expected {
+				t.Errorf("expected fee %d, got %s", test.expected, fee)
+			}
+
+			if r.BlockNumber.Int64() != 16 || r.Status != types.ReceiptStatusSuccessful {
+				t.Errorf("unexpected receipt %+v", r.Receipt)
+			}
+		})
+	}
+}
+
+func TestBlockchain_BlockReceipts(t *testing.T) {
+	blk := newTestBlock(3)
+
+	blockReceipts := make([]interface{}, 0)
+	for i := len(blk.Transactions()) - 1; i >= 0; i-- {
+		blockReceipts = append(blockReceipts, receiptJSON(blk.Transactions()[i].Hash()))
+	}
+
+	receiptsByHash := func(params []json.RawMessage) interface{} {
+		var hash common.Hash
+		json.Unmarshal(params[0], &hash)
+
+		return receiptJSON(hash)
+	}
+
+	tests := []struct {
+		name    string
+		results map[string]interface{}
+	}{
+		{name: "block receipts", results: map[string]interface{}{"eth_getBlockReceipts": blockReceipts}},
+		{name: "batch", results: map[string]interface{}{"eth_getTransactionReceipt": receiptsByHash}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := rpctest.NewServer(t, test.results)
+			defer server.Close()
+
+			b := &Blockchain{rpcClient: rpc.NewClient(server.URL)}
+
+			receipts, err := b.blockReceipts(context.Background(), blk)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i, tx := range blk.Transactions() {
+				if receipts[i].TxHash != tx.Hash() {
+					t.Errorf("receipt %d don't match its transaction", i)
+				}
+			}
+		})
+	}
+}
+
+func TestBlockchain_ReceiptFees(t *testing.T) {
+	to := common.HexToAddress("0xf37111de2f6ae2f64be1e59472b5c50801540c8c")
+	tx := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   big.NewInt(1),
+		GasTipCap: big.NewInt(2),
+		GasFeeCap: big.NewInt(1_000),
+		Gas:       21_000,
+		To:        &to,
+		Value:     big.NewInt(1),
+	})
+
+	var calls []string
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"eth_getTransactionByHash": func(params []json.RawMessage) interface{} {
+			calls = append(calls, "eth_getTransactionByHash")
+			return tx
+		},
+		"eth_getBlockByNumber": func(params []json.RawMessage) interface{} {
+			calls = append(calls, "eth_getBlockByNumber")
+			return &types.Header{Number: big.NewInt(16), Difficulty: big.NewInt(0), BaseFee: big.NewInt(50)}
+		},
+	})
+	defer server.Close()
+
+	b := &Blockchain{rpcClient: rpc.NewClient(server.URL), currency: newTestBlockchain(t, server.URL).currency}
+
+	withoutPrice := receiptJSON(common.HexToHash("0x01"))
+	delete(withoutPrice, "effectiveGasPrice")
+	raw, _ := json.Marshal(withoutPrice)
+	r := new(receipt)
+	if err := json.Unmarshal(raw, r); err != nil {
+		t.Fatal(err)
+	}
+
+	fees, err := b.receiptFees(context.Background(), []*receipt{newTestReceipt(t, `, "effectiveGasPrice": "0x64"`), r})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the dynamic fee transaction pays the base fee of its block plus its tip
+	if fees[0].Shift(18).String() != "2100000" || fees[1].Shift(18).String() != "1092000" {
+		t.Errorf("unexpected fees %v", fees)
+	}
+
+	if len(calls) != 2 {
+		t.Errorf("expected the transaction and its block to be fetched once, got %v", calls)
+	}
+}
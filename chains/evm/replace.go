@@ -0,0 +1,187 @@
+package evm
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/shopspring/decimal"
+	"github.com/volatiletech/null/v9"
+
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/rpc"
+	"github.com/zsmartex/multichain/pkg/transaction"
+	"github.com/zsmartex/multichain/pkg/wallet"
+)
+
+// replacementBump the minimum percentage a replacement has to raise every fee field by, it's the txpool default of geth
+const replacementBump = 10
+
+// pendingTransaction is the part of eth_getTransactionByHash needed to replace a transaction
+type pendingTransaction struct {
+	BlockNumber          *hexutil.Big    `json:"blockNumber"`
+	From                 common.Address  `json:"from"`
+	To                   *common.Address `json:"to"`
+	Nonce                hexutil.Uint64  `json:"nonce"`
+	Gas                  hexutil.Uint64  `json:"gas"`
+	Value                *hexutil.Big    `json:"value"`
+	Input                hexutil.Bytes   `json:"input"`
+	GasPrice             *hexutil.Big    `json:"gasPrice"`
+	MaxFeePerGas         *hexutil.Big    `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big    `json:"maxPriorityFeePerGas"`
+}
+
+// SpeedUpTransaction send tx again with the same nonce and a fee of newRate, bumped enough to be accepted as a replacement
+func (w *Wallet) SpeedUpTransaction(ctx context.Context, tx *transaction.Transaction, newRate wallet.GasPriceRate) (*transaction.Transaction, error) {
+	original, err := w.pendingTransaction(ctx, tx.TxHash.String)
+	if err != nil {
+		return nil, err
+	}
+
+	if original.To == nil {
+		return nil, fmt.Errorf("transaction %s create a contract and can't be sped up", tx.TxHash.String)
+	}
+
+	fee, err := w.replacementFee(ctx, original, newRate)
+	if err != nil {
+		return nil, err
+	}
+
+	var value *big.Int
+	if original.Value != nil {
+		value = original.Value.ToInt()
+	}
+
+	return w.sendReplacement(ctx, tx, original, &txCall{
+		To:       *original.To,
+		Value:    value,
+		Data:     original.Input,
+		GasLimit: uint64(original.Gas),
+		Fee:      fee,
+	})
+}
+
+// CancelTransaction replace tx with a transfer of nothing to the sender at a fast rate
+func (w *Wallet) CancelTransaction(ctx context.Context, tx *transaction.Transaction) (*transaction.Transaction, error) {
+	original, err := w.pendingTransaction(ctx, tx.TxHash.String)
+	if err != nil {
+		return nil, err
+	}
+
+	fee, err := w.replacementFee(ctx, original, wallet.GasPriceRateFast)
+	if err != nil {
+		return nil, err
+	}
+
+	cancelled, err := w.sendReplacement(ctx, tx, original, &txCall{
+		To:       original.From,
+		Value:    big.NewInt(0),
+		GasLimit: uint64(defaultEvmFee["gas_limit"].(int)),
+		Fee:      fee,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	cancelled.ToAddress = original.From.Hex()
+	cancelled.Amount = decimal.Zero
+
+	return cancelled, nil
+}
+
+func (w *Wallet) pendingTransaction(ctx context.Context, hash string) (*pendingTransaction, error) {
+	original := new(pendingTransaction)
+	if err := w.client.Call(ctx, original, "eth_getTransactionByHash", hash); err != nil {
+		if errors.Is(err, rpc.ErrNullResult) {
+			return nil, errors.ErrTxNotFound
+		}
+
+		return nil, err
+	}
+
+	if original.BlockNumber != nil {
+		return nil, errors.Wrap(errors.ErrNonceConflict, fmt.Errorf("transaction %s is already mined", hash))
+	}
+
+	from, _, err := w.sender()
+	if err != nil {
+		return nil, err
+	}
+
+	if original.From != from {
+		return nil, fmt.Errorf("transaction %s was not sent by %s", hash, from.Hex())
+	}
+
+	return original, nil
+}
+
+func (w *Wallet) sendReplacement(ctx context.Context, tx *transaction.Transaction, original *pendingTransaction, call *txCall) (*transaction.Transaction, error) {
+	nonce := uint64(original.Nonce)
+	call.Nonce = &nonce
+
+	txid, err := w.sendTransaction(ctx, call)
+	if err != nil {
+		return nil, err
+	}
+
+	replacement := *tx
+	replacement.Fee = decimal.NewNullDecimal(w.ConvertFromBaseUnit(decimal.NewFromBigInt(call.Fee.Total(call.GasLimit), 0)))
+	replacement.Status = transaction.StatusPending
+	replacement.TxHash = null.StringFrom(txid)
+	replacement.ReplacedTxHash = tx.TxHash
+
+	return &replacement, nil
+}
+
+// replacementFee return the fee of rate raised to at least the replacementBump of every fee field of original,
+// the replacement keep the type of the original transaction
+func (w *Wallet) replacementFee(ctx context.Context, original *pendingTransaction, rate wallet.GasPriceRate) (*gasFee, error) {
+	options := w.mergeOptions(nil, w.currency.Options, map[string]interface{}{"gas_rate": rate})
+	delete(options, "gas_price")
+
+	current, err := w.calculateGasFee(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	if original.MaxFeePerGas != nil {
+		tip := current.MaxPriorityFeePerGas
+		if tip == nil {
+			tip = current.GasPrice
+		}
+
+		tip = maxBig(tip, bumpFee(original.MaxPriorityFeePerGas.ToInt()))
+		maxFee := maxBig(current.Cap(), bumpFee(original.MaxFeePerGas.ToInt()))
+
+		return &gasFee{
+			MaxFeePerGas:         maxBig(maxFee, tip),
+			MaxPriorityFeePerGas: tip,
+		}, nil
+	}
+
+	// a legacy transaction pays its whole gas price, the room left in MaxFeePerGas for the base fee to rise would be overpaid
+	gasPrice := current.GasPrice
+	if current.IsDynamic() {
+		gasPrice = new(big.Int).Add(current.BaseFee, current.MaxPriorityFeePerGas)
+	}
+
+	return &gasFee{GasPrice: maxBig(gasPrice, bumpFee(original.GasPrice.ToInt()))}, nil
+}
+
+// bumpFee return fee raised by replacementBump rounded up
+func bumpFee(fee *big.Int) *big.Int {
+	bumped := new(big.Int).Mul(fee, big.NewInt(100+replacementBump))
+	bumped.Add(bumped, big.NewInt(99))
+
+	return bumped.Div(bumped, big.NewInt(100))
+}
+
+func maxBig(a, b *big.Int) *big.Int {
+	if a.Cmp(b) >= 0 {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,206 @@
+package evm
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/volatiletech/null/v9"
+
+	"github.com/zsmartex/multichain/internal/rpctest"
+	"github.com/zsmartex/multichain/pkg/currency"
+	"github.com/zsmartex/multichain/pkg/transaction"
+	"github.com/zsmartex/multichain/pkg/wallet"
+)
+
+func TestWallet_SpeedUpTransaction(t *testing.T) {
+	var sent map[string]string
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"eth_chainId": "0x1",
+		"eth_getTransactionByHash": map[string]interface{}{
+			"blockNumber":          nil,
+			"from":                 "0x249aeb18f3a323c12334a595cb6220912c4b9087",
+			"to":                   "0xf37111de2f6ae2f64be1e59472b5c50801540c8c",
+			"nonce":                "0x5",
+			"gas":                  "0x5208",
+			"value":                "0x64",
+			"input":                "0x",
+			"maxFeePerGas":         "0x3e8",
+			"maxPriorityFeePerGas": "0x64",
+		},
+		"eth_feeHistory": map[string]interface{}{
+			"baseFeePerGas": []string{"0x64", "0x64"},
+			"reward":        [][]string{{"0x1"}},
+		},
+		"personal_sendTransaction": func(params []json.RawMessage) interface{} {
+			json.Unmarshal(params[0], &sent)
+			return "0x02"
+		},
+	})
+	defer server.Close()
+
+	w := newTestWallet(t, server.URL)
+
+	tx, err := w.SpeedUpTransaction(context.Background(), &transaction.Transaction{TxHash: null.StringFrom("0x01")}, wallet.GasPriceRateFast)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tx.TxHash.String != "0x02" || tx.ReplacedTxHash.String != "0x01" {
+		t.Errorf("unexpected replacement %s of %s", tx.TxHash.String, tx.ReplacedTxHash.String)
+	}
+
+	// the current fee is lower than the original so the original is bumped by 10%
+	if sent["nonce"] != "0x5" || sent["maxFeePerGas"] != "0x44c" || sent["maxPriorityFeePerGas"] != "0x6e" || sent["value"] != "0x64" {
+		t.Errorf("unexpected replacement params %v", sent)
+	}
+}
+
+func TestWallet_CancelTransaction(t *testing.T) {
+	var sent map[string]string
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"eth_chainId": "0x1",
+		"eth_getTransactionByHash": map[string]interface{}{
+			"blockNumber": nil,
+			"from":        "0x249aeb18f3a323c12334a595cb6220912c4b9087",
+			"to":          "0xf37111de2f6ae2f64be1e59472b5c50801540c8c",
+			"nonce":       "0x9",
+			"gas":         "0x15f90",
+			"value":       "0x0",
+			"input":       "0xa9059cbb",
+			"gasPrice":    "0x64",
+		},
+		"eth_gasPrice": "0x3e8",
+		"personal_sendTransaction": func(params []json.RawMessage) interface{} {
+			json.Unmarshal(params[0], &sent)
+			return "0x02"
+		},
+	})
+	defer server.Close()
+
+	w := newTestWallet(t, server.URL)
+
+	tx, err := w.CancelTransaction(context.Background(), &transaction.Transaction{TxHash: null.StringFrom("0x01")})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.EqualFold(tx.ToAddress, "0x249aeb18f3a323c12334a595cb6220912c4b9087") || tx.ReplacedTxHash.String != "0x01" {
+		t.Errorf("unexpected cancellation %+v", tx)
+	}
+
+	// eth_gasPrice is 1000 and fast rate add 10%
+	if sent["nonce"] != "0x9" || sent["gasPrice"] != "0x44c" || sent["to"] != sent["from"] || sent["data"] != "" {
+		t.Errorf("unexpected cancellation params %v", sent)
+	}
+}
+
+func TestWallet_SpeedUpLegacyTransaction(t *testing.T) {
+	var sent map[string]string
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"eth_chainId": "0x1",
+		"eth_getTransactionByHash": map[string]interface{}{
+			"blockNumber": nil,
+			"from":        "0x249aeb18f3a323c12334a595cb6220912c4b9087",
+			"to":          "0xf37111de2f6ae2f64be1e59472b5c50801540c8c",
+			"nonce":       "0x5",
+			"gas":         "0x5208",
+			"value":       "0x64",
+			"input":       "0x",
+			"gasPrice":    "0x64",
+		},
+		"eth_feeHistory": map[string]interface{}{
+			"baseFeePerGas": []string{"0x3e8", "0x3e8"},
+			"reward":        [][]string{{"0x1"}},
+		},
+		"personal_sendTransaction": func(params []json.RawMessage) interface{} {
+			json.Unmarshal(params[0], &sent)
+			return "0x02"
+		},
+	})
+	defer server.Close()
+
+	w := newTestWallet(t, server.URL)
+
+	if _, err := w.SpeedUpTransaction(context.Background(), &transaction.Transaction{TxHash: null.StringFrom("0x01")}, wallet.GasPriceRateFast); err != nil {
+		t.Fatal(err)
+	}
+
+	// the base fee of 1000 plus the tip of 1, not the max fee which leave room for the base fee to double
+	if sent["gasPrice"] != "0x3e9" || sent["maxFeePerGas"] != "" {
+		t.Errorf("unexpected replacement params %v", sent)
+	}
+}
+
+func TestWallet_SpeedUpMinedTransaction(t *testing.T) {
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"eth_getTransactionByHash": map[string]interface{}{
+			"blockNumber": "0x10",
+			"from":        "0x249aeb18f3a323c12334a595cb6220912c4b9087",
+			"nonce":       "0x9",
+		},
+	})
+	defer server.Close()
+
+	w := newTestWallet(t, server.URL)
+
+	_, err := w.SpeedUpTransaction(context.Background(), &transaction.Transaction{TxHash: null.StringFrom("0x01")}, wallet.GasPriceRateFast)
+	if err == nil {
+		t.Fatal("expected an error for a mined transaction")
+	}
+}
+
+func TestWallet_CancelTransactionLocalSigner(t *testing.T) {
+	address, secret, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sent *types.Transaction
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"eth_chainId": "0x1",
+		"eth_getTransactionByHash": map[string]interface{}{
+			"blockNumber": nil,
+			"from":        address,
+			"to":          "0xf37111de2f6ae2f64be1e59472b5c50801540c8c",
+			"nonce":       "0x9",
+			"gas":         "0x5208",
+			"value":       "0x0",
+			"input":       "0x",
+			"gasPrice":    "0x64",
+		},
+		"eth_gasPrice": "0x3e8",
+		"eth_sendRawTransaction": func(params []json.RawMessage) interface{} {
+			var raw string
+			json.Unmarshal(params[0], &raw)
+
+			sent = new(types.Transaction)
+			if err := sent.UnmarshalBinary(hexutil.MustDecode(raw)); err != nil {
+				t.Error(err)
+			}
+
+			return sent.Hash().Hex()
+		},
+	})
+	defer server.Close()
+
+	// the address is derived from the key
+	w := NewWallet().(*Wallet)
+	if err := w.Configure(&wallet.Setting{
+		Wallet:   &wallet.SettingWallet{URI: server.URL, Secret: secret},
+		Currency: &currency.Currency{ID: "ETH", Subunits: 18, Options: map[string]interface{}{"signer": SignerLocal}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.CancelTransaction(context.Background(), &transaction.Transaction{TxHash: null.StringFrom("0x01")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if sent == nil || sent.Nonce() != 9 {
+		t.Errorf("expected the cancellation to reuse nonce 9, got %v", sent)
+	}
+}
@@ -0,0 +1,248 @@
+package evm
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+
+	"github.com/zsmartex/multichain/pkg/errors"
+)
+
+const (
+	// SignerNode transactions are signed by the node with personal_sendTransaction
+	SignerNode = "node"
+	// SignerLocal transactions are signed locally with the wallet secret and sent with eth_sendRawTransaction
+	SignerLocal = "local"
+)
+
+// txCall is a transaction to be signed and sent by the wallet
+type txCall struct {
+	To       common.Address
+	Value    *big.Int
+	Data     []byte
+	GasLimit uint64
+	Fee      *gasFee
+	Nonce    *uint64 // reuse the nonce of a pending transaction to replace it
+}
+
+func (w *Wallet) isLocalSigner() bool {
+	return w.currency.Options["signer"] == SignerLocal
+}
+
+// sendTransaction sign and send call with the configured signer and return the transaction hash,
+// the nonce is reserved from the NonceManager and released when the node reject the transaction
+func (w *Wallet) sendTransaction(ctx context.Context, call *txCall) (string, error) {
+	from, key, err := w.sender()
+	if err != nil {
+		return "", err
+	}
+
+	chainID, err := w.chainID(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if call.Nonce != nil {
+		return w.signAndSend(ctx, from, key, chainID, *call.Nonce, call)
+	}
+
+	var pending hexutil.Uint64
+	if err := w.client.Call(ctx, &pending, "eth_getTransactionCount", from.Hex(), "pending"); err != nil {
+		return "", err
+	}
+
+	nonces := w.nonceManager()
+	nonceKey := newNonceKey(chainID.String(), from.Hex())
+
+	nonce, err := nonces.Reserve(ctx, nonceKey, uint64(pending))
+	if err != nil {
+		return "", err
+	}
+
+	txid, err := w.signAndSend(ctx, from, key, chainID, nonce, call)
+	if err != nil {
+		// the node may have accepted a transaction whose send failed without an answer, its nonce is kept
+		// as submitted so it's offered again only once the pending count show it was never broadcast
+		if !isRejected(err) {
+			if commitErr := nonces.Commit(ctx, nonceKey, nonce); commitErr != nil {
+				return "", commitErr
+			}
+
+			return "", err
+		}
+
+		if releaseErr := nonces.Release(ctx, nonceKey, nonce); releaseErr != nil {
+			return "", releaseErr
+		}
+
+		return "", err
+	}
+
+	if err := nonces.Commit(ctx, nonceKey, nonce); err != nil {
+		return "", err
+	}
+
+	return txid, nil
+}
+
+// sender return the address sending the transactions and its key when they are signed locally,
+// the address is derived from the key so the wallet address can be left empty
+func (w *Wallet) sender() (common.Address, *ecdsa.PrivateKey, error) {
+	if !w.isLocalSigner() {
+		return common.HexToAddress(w.wallet.Address), nil, nil
+	}
+
+	key, err := w.privateKey()
+	if err != nil {
+		return common.Address{}, nil, err
+	}
+
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	if len(w.wallet.Address) > 0 && !strings.EqualFold(from.Hex(), w.normalizeAddress(w.wallet.Address)) {
+		return common.Address{}, nil, errors.NewConfigError("wallet.secret", "private key does not belong to %s", w.wallet.Address)
+	}
+
+	return from, key, nil
+}
+
+// signAndSend sign call of from locally when key is given or by the node otherwise
+func (w *Wallet) signAndSend(ctx context.Context, from common.Address, key *ecdsa.PrivateKey, chainID *big.Int, nonce uint64, call *txCall) (string, error) {
+	if key != nil {
+		return w.sendLocalTransaction(ctx, key, chainID, nonce, call)
+	}
+
+	return w.sendNodeTransaction(ctx, from, nonce, call)
+}
+
+func (w *Wallet) sendNodeTransaction(ctx context.Context, from common.Address, nonce uint64, call *txCall) (string, error) {
+	params := map[string]string{
+		"from":  w.normalizeAddress(from.Hex()),
+		"to":    w.normalizeAddress(call.To.Hex()),
+		"gas":   hexutil.EncodeUint64(call.GasLimit),
+		"nonce": hexutil.EncodeUint64(nonce),
+	}
+	if call.Value != nil {
+		params["value"] = hexutil.EncodeBig(call.Value)
+	}
+	if len(call.Data) > 0 {
+		params["data"] = hexutil.Encode(call.Data)
+	}
+	call.Fee.apply(params)
+
+	var txid string
+	if err := w.client.CallOnce(ctx, &txid, "personal_sendTransaction", params, w.wallet.Secret); err != nil {
+		return "", err
+	}
+
+	return txid, nil
+}
+
+func (w *Wallet) sendLocalTransaction(ctx context.Context, key *ecdsa.PrivateKey, chainID *big.Int, nonce uint64, call *txCall) (string, error) {
+	signedTx, err := types.SignTx(w.buildTransaction(chainID, nonce, call), types.LatestSignerForChainID(chainID), key)
+	if err != nil {
+		return "", err
+	}
+
+	rawTx, err := signedTx.MarshalBinary()
+	if err != nil {
+		return "", err
+	}
+
+	// a node which already got the signed transaction, e.g. from a previous attempt, has it in its mempool
+	var txid string
+	if err := w.client.CallOnce(ctx, &txid, "eth_sendRawTransaction", hexutil.Encode(rawTx)); err != nil && !isAlreadyKnown(err) {
+		return "", err
+	}
+
+	return signedTx.Hash().Hex(), nil
+}
+
+func (w *Wallet) buildTransaction(chainID *big.Int, nonce uint64, call *txCall) *types.Transaction {
+	to := call.To
+	value := call.Value
+	if value == nil {
+		value = big.NewInt(0)
+	}
+
+	if call.Fee.IsDynamic() {
+		return types.NewTx(&types.DynamicFeeTx{
+			ChainID:   chainID,
+			Nonce:     nonce,
+			GasTipCap: call.Fee.MaxPriorityFeePerGas,
+			GasFeeCap: call.Fee.MaxFeePerGas,
+			Gas:       call.GasLimit,
+			To:        &to,
+			Value:     value,
+			Data:      call.Data,
+		})
+	}
+
+	return types.NewTx(&types.LegacyTx{
+		Nonce:    nonce,
+		GasPrice: call.Fee.GasPrice,
+		Gas:      call.GasLimit,
+		To:       &to,
+		Value:    value,
+		Data:     call.Data,
+	})
+}
+
+func (w *Wallet) chainID(ctx context.Context) (*big.Int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.cachedChainID != nil {
+		return w.cachedChainID, nil
+	}
+
+	var chainID hexutil.Big
+	if err := w.client.Call(ctx, &chainID, "eth_chainId"); err != nil {
+		return nil, err
+	}
+
+	w.cachedChainID = chainID.ToInt()
+
+	return w.cachedChainID, nil
+}
+
+// privateKey resolve the wallet secret, it can be a hex private key or a reference
+// to one with env:NAME to read an environment variable or file:PATH to read a file
+func (w *Wallet) privateKey() (*ecdsa.PrivateKey, error) {
+	secret := w.wallet.Secret
+
+	switch {
+	case strings.HasPrefix(secret, "env:"):
+		secret = os.Getenv(strings.TrimPrefix(secret, "env:"))
+	case strings.HasPrefix(secret, "file:"):
+		content, err := os.ReadFile(strings.TrimPrefix(secret, "file:"))
+		if err != nil {
+			return nil, err
+		}
+
+		secret = string(content)
+	}
+
+	key, err := crypto.HexToECDSA(strings.TrimPrefix(strings.TrimSpace(secret), "0x"))
+	if err != nil {
+		return nil, errors.NewConfigError("wallet.secret", "invalid private key")
+	}
+
+	return key, nil
+}
+
+// generateKey create a new keypair locally and return its address and hex encoded private key
+func generateKey() (address string, secret string, err error) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		return "", "", err
+	}
+
+	return crypto.PubkeyToAddress(key.PublicKey).Hex(), hexutil.Encode(crypto.FromECDSA(key))[2:], nil
+}
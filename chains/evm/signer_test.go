@@ -0,0 +1,241 @@
+package evm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/multichain/internal/rpctest"
+	"github.com/zsmartex/multichain/pkg/currency"
+	"github.com/zsmartex/multichain/pkg/transaction"
+	"github.com/zsmartex/multichain/pkg/wallet"
+)
+
+func TestWallet_CreateTransactionLocalSigner(t *testing.T) {
+	var sentTx *types.Transaction
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"eth_chainId":             "0x38",
+		"eth_getTransactionCount": "0x7",
+		"eth_gasPrice":            "0x3e8",
+		"eth_sendRawTransaction": func(params []json.RawMessage) interface{} {
+			var raw string
+			json.Unmarshal(params[0], &raw)
+
+			sentTx = new(types.Transaction)
+			if err := sentTx.UnmarshalBinary(hexutil.MustDecode(raw)); err != nil {
+				t.Error(err)
+				return &rpctest.Error{Code: -32000, Message: err.Error()}
+			}
+
+			return sentTx.Hash().Hex()
+		},
+	})
+	defer server.Close()
+
+	address, secret, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewWallet()
+	if err := w.Configure(&wallet.Setting{
+		Wallet: &wallet.SettingWallet{URI: server.URL, Address: address, Secret: secret},
+		Currency: &currency.Currency{
+			ID:       "BSC",
+			Subunits: 18,
+			Options:  map[string]interface{}{"signer": SignerLocal},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := w.CreateTransaction(context.Background(), &transaction.Transaction{
+		ToAddress: "0xF37111De2f6AE2f64Be1e59472b5C50801540C8c",
+		Amount:    decimal.NewFromFloat(0.001),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if sentTx == nil || tx.TxHash.String != sentTx.Hash().Hex() {
+		t.Fatalf("expected returned hash to match the signed transaction")
+	}
+
+	sender, err := types.Sender(types.LatestSignerForChainID(sentTx.ChainId()), sentTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.EqualFold(sender.Hex(), address) || sentTx.ChainId().Int64() != 56 || sentTx.Nonce() != 7 {
+		t.Errorf("unexpected signed transaction from %s chain %d nonce %d", sender.Hex(), sentTx.ChainId(), sentTx.Nonce())
+	}
+
+	if sentTx.Value().String() != "1000000000000000" {
+		t.Errorf("unexpected value %s", sentTx.Value())
+	}
+}
+
+func TestWallet_CreateTransactionAlreadyKnown(t *testing.T) {
+	var sentHash string
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"eth_chainId":             "0x38",
+		"eth_getTransactionCount": "0x7",
+		"eth_gasPrice":            "0x3e8",
+		"eth_sendRawTransaction": func(params []json.RawMessage) interface{} {
+			var raw string
+			json.Unmarshal(params[0], &raw)
+
+			sentTx := new(types.Transaction)
+			if err := sentTx.UnmarshalBinary(hexutil.MustDecode(raw)); err != nil {
+				t.Error(err)
+			}
+			sentHash = sentTx.Hash().Hex()
+
+			return &rpctest.Error{Code: -32000, Message: "already known"}
+		},
+	})
+	defer server.Close()
+
+	address, secret, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewWallet()
+	if err := w.Configure(&wallet.Setting{
+		Wallet:   &wallet.SettingWallet{URI: server.URL, Address: address, Secret: secret},
+		Currency: &currency.Currency{ID: "BSC", Subunits: 18, Options: map[string]interface{}{"signer": SignerLocal}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := w.CreateTransaction(context.Background(), &transaction.Transaction{
+		ToAddress: "0xF37111De2f6AE2f64Be1e59472b5C50801540C8c",
+		Amount:    decimal.NewFromFloat(0.001),
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(sentHash) == 0 || tx.TxHash.String != sentHash {
+		t.Errorf("expected the hash of the known transaction, got %s", tx.TxHash.String)
+	}
+}
+
+func TestWallet_CreateTransactionUnknownResult(t *testing.T) {
+	nonces := make([]uint64, 0)
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"eth_chainId":             "0x38",
+		"eth_getTransactionCount": "0x7",
+		"eth_gasPrice":            "0x3e8",
+		"eth_sendRawTransaction": func(params []json.RawMessage) interface{} {
+			var raw string
+			json.Unmarshal(params[0], &raw)
+
+			sentTx := new(types.Transaction)
+			if err := sentTx.UnmarshalBinary(hexutil.MustDecode(raw)); err != nil {
+				t.Error(err)
+			}
+			nonces = append(nonces, sentTx.Nonce())
+
+			// drop the connection of the first send as a node dying after getting it
+			if len(nonces) == 1 {
+				panic(http.ErrAbortHandler)
+			}
+
+			return sentTx.Hash().Hex()
+		},
+	})
+	defer server.Close()
+
+	address, secret, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := NewWallet().(*Wallet)
+	w.SetNonceManager(NewMemoryNonceManager())
+	if err := w.Configure(&wallet.Setting{
+		Wallet:   &wallet.SettingWallet{URI: server.URL, Address: address, Secret: secret},
+		Currency: &currency.Currency{ID: "BSC", Subunits: 18, Options: map[string]interface{}{"signer": SignerLocal}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		w.CreateTransaction(context.Background(), &transaction.Transaction{
+			ToAddress: "0xF37111De2f6AE2f64Be1e59472b5C50801540C8c",
+			Amount:    decimal.NewFromFloat(0.001),
+		}, nil)
+	}
+
+	if len(nonces) != 2 || nonces[0] != 7 || nonces[1] != 8 {
+		t.Errorf("expected the nonce of an unknown send to be kept, got %v", nonces)
+	}
+}
+
+func TestWallet_LocalSignerWithoutAddress(t *testing.T) {
+	address, secret, err := generateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var estimatedFrom, balanceOf string
+	server := rpctest.NewServer(t, map[string]interface{}{
+		"eth_chainId":             "0x38",
+		"eth_getTransactionCount": "0x7",
+		"eth_gasPrice":            "0x3e8",
+		"eth_estimateGas": func(params []json.RawMessage) interface{} {
+			var call map[string]string
+			json.Unmarshal(params[0], &call)
+			estimatedFrom = call["from"]
+
+			return "0x5208"
+		},
+		"eth_getBalance": func(params []json.RawMessage) interface{} {
+			json.Unmarshal(params[0], &balanceOf)
+
+			return "0x1"
+		},
+		"eth_sendRawTransaction": func(params []json.RawMessage) interface{} {
+			var raw string
+			json.Unmarshal(params[0], &raw)
+
+			sentTx := new(types.Transaction)
+			sentTx.UnmarshalBinary(hexutil.MustDecode(raw))
+
+			return sentTx.Hash().Hex()
+		},
+	})
+	defer server.Close()
+
+	w := NewWallet()
+	if err := w.Configure(&wallet.Setting{
+		Wallet:   &wallet.SettingWallet{URI: server.URL, Secret: secret},
+		Currency: &currency.Currency{ID: "BSC", Subunits: 18, Options: map[string]interface{}{"signer": SignerLocal}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.CreateTransaction(context.Background(), &transaction.Transaction{
+		ToAddress: "0xF37111De2f6AE2f64Be1e59472b5C50801540C8c",
+		Amount:    decimal.NewFromFloat(0.001),
+	}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := w.LoadBalance(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// the gas is estimated and the balance read for the address of the key
+	if !strings.EqualFold(estimatedFrom, address) || !strings.EqualFold(balanceOf, address) {
+		t.Errorf("expected the address of the key %s, got %s and %s", address, estimatedFrom, balanceOf)
+	}
+}
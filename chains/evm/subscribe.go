@@ -0,0 +1,317 @@
+package evm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/zsmartex/multichain/pkg/blockchain"
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/transaction"
+)
+
+// SubscribeRetryInterval wait time before reconnecting a dropped subscription
+var SubscribeRetryInterval = 5 * time.Second
+
+// SubscribeReorgDepth the number of handled blocks kept to detect reorganizations
+var SubscribeReorgDepth = 64
+
+var _ blockchain.Subscriber = (*Blockchain)(nil)
+
+// handlerError is returned by the handler and stop the subscription instead of reconnecting
+type handlerError struct {
+	err error
+}
+
+func (e *handlerError) Error() string {
+	return e.err.Error()
+}
+
+// subscription is the state kept across the reconnections of a subscription
+type subscription struct {
+	handler  blockchain.SubscriptionHandler
+	detector *blockchain.ReorgDetector
+	// the number of the last handled block, -1 until the first head is received
+	last int64
+	// the number of the last received head
+	head int64
+	// the transfers handled before reaching the min_confirmations of their currency by unique id
+	pending map[string]*transaction.Transaction
+}
+
+// Subscribe use eth_subscribe newHeads and logs of the token contracts over the websocket endpoints of the URI,
+// the next one is dialed after a dropped connection, blocks are requested through the http endpoints
+func (b *Blockchain) Subscribe(ctx context.Context, handler blockchain.SubscriptionHandler) error {
+	if len(b.wsURIs) == 0 {
+		return errors.NewConfigError("uri", "subscriptions need a websocket endpoint, got %s", b.setting.URI)
+	}
+
+	s := &subscription{
+		handler:  handler,
+		detector: blockchain.NewReorgDetector(SubscribeReorgDepth),
+		last:     -1,
+		head:     -1,
+		pending:  make(map[string]*transaction.Transaction),
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := b.subscribe(ctx, s, b.wsURIs[attempt%len(b.wsURIs)])
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var handlerErr *handlerError
+		if errors.As(err, &handlerErr) {
+			return handlerErr.err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(SubscribeRetryInterval):
+		}
+	}
+}
+
+// subscribe run a subscription on uri until its connection is dropped
+func (b *Blockchain) subscribe(ctx context.Context, s *subscription, uri string) error {
+	rpcClient, err := rpc.DialContext(ctx, uri)
+	if err != nil {
+		return err
+	}
+	defer rpcClient.Close()
+
+	client := ethclient.NewClient(rpcClient)
+
+	heads := make(chan *types.Header)
+	headSub, err := client.SubscribeNewHead(ctx, heads)
+	if err != nil {
+		return mapError(err, errors.ErrBlockNotFound)
+	}
+	defer headSub.Unsubscribe()
+
+	logs := make(chan types.Log)
+	var logErrs <-chan error
+	if len(b.contracts) > 0 {
+		addresses := make([]common.Address, len(b.contracts))
+		for i, c := range b.contracts {
+			addresses[i] = common.HexToAddress(c.Options["erc20_contract_address"].(string))
+		}
+
+		logSub, err := client.SubscribeFilterLogs(ctx, ethereum.FilterQuery{
+			Addresses: addresses,
+			Topics:    [][]common.Hash{{common.HexToHash(tokenEventIdentifier)}},
+		}, logs)
+		if err != nil {
+			return mapError(err, errors.ErrBlockNotFound)
+		}
+		defer logSub.Unsubscribe()
+
+		logErrs = logSub.Err()
+	}
+
+	// backfill the blocks and the token transfers produced while disconnected,
+	// the logs are only sent by the node from the new subscription
+	if s.last >= 0 {
+		latestBlockNumber, err := b.GetLatestBlockNumber(ctx)
+		if err != nil {
+			return err
+		}
+
+		transfers, err := b.GetTokenTransfers(ctx, s.last+1, latestBlockNumber)
+		if err != nil {
+			return err
+		}
+
+		s.head = latestBlockNumber
+		if err := b.handleTransfers(ctx, s, transfers); err != nil {
+			return err
+		}
+
+		if err := b.handleHead(ctx, s, latestBlockNumber); err != nil {
+			return err
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-headSub.Err():
+			return err
+		case err := <-logErrs:
+			return err
+		case header := <-heads:
+			if s.last < 0 {
+				s.last = header.Number.Int64() - 1
+			}
+
+			if err := b.handleHead(ctx, s, header.Number.Int64()); err != nil {
+				return err
+			}
+		case l := <-logs:
+			if err := b.handleLog(ctx, s, &l); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// handleHead handle the blocks after the last handled one up to number, a head which doesn't
+// extend the handled blocks means they were reorganized
+func (b *Blockchain) handleHead(ctx context.Context, s *subscription, number int64) error {
+	s.head = number
+
+	if number <= s.last {
+		if err := b.rollback(ctx, s); err != nil {
+			return err
+		}
+	}
+
+	for height := s.last + 1; height <= number; height++ {
+		blk, err := b.GetBlockByNumber(ctx, height)
+		if err != nil {
+			return err
+		}
+
+		if !s.detector.Connects(blk) {
+			if err := b.rollback(ctx, s); err != nil {
+				return err
+			}
+
+			// the node answered a block which doesn't extend its own chain, retry after reconnecting
+			if s.last >= height-1 {
+				return fmt.Errorf("block %d does not extend the handled block %d", height, s.last)
+			}
+
+			height = s.last
+			continue
+		}
+
+		if err := s.handler.HandleBlock(ctx, blk); err != nil {
+			return &handlerError{err: err}
+		}
+
+		s.detector.Push(blk.Number, blk.Hash)
+		s.last = height
+	}
+
+	return b.confirmTransfers(ctx, s)
+}
+
+// rollback find the handled blocks orphaned by a reorganization and hand them to the handler,
+// the blocks are handled again from the first orphaned height
+func (b *Blockchain) rollback(ctx context.Context, s *subscription) error {
+	orphaned, err := s.detector.Detect(ctx, b)
+	if len(orphaned) == 0 {
+		return err
+	}
+
+	if err := s.handler.HandleRollback(ctx, orphaned); err != nil {
+		return &handlerError{err: err}
+	}
+
+	for id, t := range s.pending {
+		if t.BlockNumber >= orphaned[0] {
+			delete(s.pending, id)
+		}
+	}
+
+	s.last = orphaned[0] - 1
+
+	if errors.Is(err, blockchain.ErrReorgTooDeep) {
+		return &handlerError{err: err}
+	}
+
+	return err
+}
+
+// confirmTransfers handle again the pending transfers which reached the min_confirmations of their currency
+func (b *Blockchain) confirmTransfers(ctx context.Context, s *subscription) error {
+	confirmed := make([]*transaction.Transaction, 0)
+	for id, t := range s.pending {
+		t.Status = transaction.StatusSucceed
+		b.setting.ApplyConfirmations(s.head-t.BlockNumber+1, t)
+
+		if t.IsSuccess() {
+			confirmed = append(confirmed, t)
+			delete(s.pending, id)
+		}
+	}
+
+	if len(confirmed) == 0 {
+		return nil
+	}
+
+	if err := s.handler.HandleTransfers(ctx, confirmed); err != nil {
+		return &handlerError{err: err}
+	}
+
+	return nil
+}
+
+func (b *Blockchain) handleLog(ctx context.Context, s *subscription, l *types.Log) error {
+	// the transfers of an orphaned block are rolled back with it
+	if l.Removed {
+		for id, t := range s.pending {
+			if strings.EqualFold(t.TxHash.String, l.TxHash.Hex()) && t.Position.Int64 == int64(l.Index) {
+				delete(s.pending, id)
+			}
+		}
+
+		return nil
+	}
+
+	r, err := b.transactionReceipt(ctx, l.TxHash)
+	if err != nil {
+		return err
+	}
+
+	fees, err := b.receiptFees(ctx, []*receipt{r})
+	if err != nil {
+		return err
+	}
+
+	t := b.buildERC20Transfer(l, &r.Receipt, fees[0])
+	if t == nil {
+		return nil
+	}
+
+	return b.handleTransfers(ctx, s, []*transaction.Transaction{t})
+}
+
+// handleTransfers apply the confirmations of the received head to transfers and hand them to the handler,
+// the ones below the min_confirmations of their currency are kept to be confirmed later
+func (b *Blockchain) handleTransfers(ctx context.Context, s *subscription, transfers []*transaction.Transaction) error {
+	if len(transfers) == 0 {
+		return nil
+	}
+
+	for _, t := range transfers {
+		confirmations := s.head - t.BlockNumber + 1
+		if confirmations < 1 {
+			confirmations = 1
+		}
+
+		b.setting.ApplyConfirmations(confirmations, t)
+
+		// the handler may keep t so a copy is confirmed later
+		if t.IsPending() {
+			pending := *t
+			s.pending[t.UniqueID()] = &pending
+		}
+	}
+
+	if err := s.handler.HandleTransfers(ctx, transfers); err != nil {
+		return &handlerError{err: err}
+	}
+
+	return nil
+}
@@ -0,0 +1,374 @@
+package evm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/zsmartex/multichain/pkg/block"
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/transaction"
+)
+
+// fakeChain is an eth service serving empty blocks up to head, the blocks from forkAt are replaced when fork is set,
+// logs are only returned by eth_getLogs
+type fakeChain struct {
+	mu     sync.Mutex
+	head   int64
+	forkAt int64
+	fork   byte
+	logs   []map[string]interface{}
+}
+
+func (c *fakeChain) header(number int64) *types.Header {
+	c.mu.Lock()
+	forkAt, fork := c.forkAt, c.fork
+	c.mu.Unlock()
+
+	return forkHeader(number, forkAt, fork)
+}
+
+func forkHeader(number, forkAt int64, fork byte) *types.Header {
+	header := &types.Header{
+		Number:     big.NewInt(number),
+		Difficulty: big.NewInt(1),
+		Time:       uint64(number),
+		TxHash:     types.EmptyRootHash,
+		UncleHash:  types.EmptyUncleHash,
+		Extra:      []byte{},
+	}
+
+	if fork > 0 && number >= forkAt {
+		header.Extra = []byte{fork}
+	}
+
+	if number > 0 {
+		header.ParentHash = forkHeader(number-1, forkAt, fork).Hash()
+	}
+
+	return header
+}
+
+func (c *fakeChain) block(number int64) (map[string]interface{}, error) {
+	raw, err := json.Marshal(c.header(number))
+	if err != nil {
+		return nil, err
+	}
+
+	var blk map[string]interface{}
+	if err := json.Unmarshal(raw, &blk); err != nil {
+		return nil, err
+	}
+
+	blk["transactions"] = []interface{}{}
+	blk["uncles"] = []interface{}{}
+
+	return blk, nil
+}
+
+func (c *fakeChain) BlockNumber() hexutil.Uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return hexutil.Uint64(c.head)
+}
+
+func (c *fakeChain) GetBlockByNumber(number rpc.BlockNumber, _ bool) (map[string]interface{}, error) {
+	return c.block(number.Int64())
+}
+
+func (c *fakeChain) GetBlockByHash(hash common.Hash, _ bool) (map[string]interface{}, error) {
+	for number := int64(0); number <= int64(c.BlockNumber()); number++ {
+		if c.header(number).Hash() == hash {
+			return c.block(number)
+		}
+	}
+
+	return nil, nil
+}
+
+func (c *fakeChain) GetLogs(filter map[string]interface{}) ([]map[string]interface{}, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	from, _ := hexutil.DecodeUint64(filter["fromBlock"].(string))
+	to, _ := hexutil.DecodeUint64(filter["toBlock"].(string))
+
+	logs := make([]map[string]interface{}, 0)
+	for _, l := range c.logs {
+		if number, _ := hexutil.DecodeUint64(l["blockNumber"].(string)); number >= from && number <= to {
+			logs = append(logs, l)
+		}
+	}
+
+	return logs, nil
+}
+
+func (c *fakeChain) GetTransactionReceipt(hash common.Hash) map[string]interface{} {
+	r := receiptJSON(hash)
+	r["blockNumber"] = "0xb"
+
+	return r
+}
+
+func (c *fakeChain) NewHeads(ctx context.Context) (*rpc.Subscription, error) {
+	notifier, _ := rpc.NotifierFromContext(ctx)
+	sub := notifier.CreateSubscription()
+
+	go notifier.Notify(sub.ID, c.header(int64(c.BlockNumber())))
+
+	return sub, nil
+}
+
+func (c *fakeChain) Logs(ctx context.Context, _ map[string]interface{}) (*rpc.Subscription, error) {
+	notifier, _ := rpc.NotifierFromContext(ctx)
+
+	return notifier.CreateSubscription(), nil
+}
+
+// hijackRecorder record the websocket connections to be able to drop them
+type hijackRecorder struct {
+	http.ResponseWriter
+	record func(conn net.Conn)
+}
+
+func (h *hijackRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := h.ResponseWriter.(http.Hijacker).Hijack()
+	if err == nil {
+		h.record(conn)
+	}
+
+	return conn, rw, err
+}
+
+// newFakeChainBlockchain return a blockchain using chain over http and websocket, dropConnections
+// close the websocket connections
+func newFakeChainBlockchain(t *testing.T, chain *fakeChain) (bl *Blockchain, dropConnections func()) {
+	server := rpc.NewServer()
+	if err := server.RegisterName("eth", chain); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	conns := make([]net.Conn, 0)
+
+	httpServer := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			server.ServeHTTP(rw, r)
+			return
+		}
+
+		server.WebsocketHandler([]string{"*"}).ServeHTTP(&hijackRecorder{ResponseWriter: rw, record: func(conn net.Conn) {
+			mu.Lock()
+			defer mu.Unlock()
+
+			conns = append(conns, conn)
+		}}, r)
+	}))
+	t.Cleanup(httpServer.Close)
+
+	dropConnections = func() {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+
+	return newTestBlockchain(t, httpServer.URL+",ws"+strings.TrimPrefix(httpServer.URL, "http")), dropConnections
+}
+
+type subscriptionRecorder struct {
+	blocks    chan *block.Block
+	rollbacks chan []int64
+	transfers []*transaction.Transaction
+}
+
+func (r *subscriptionRecorder) HandleBlock(_ context.Context, blk *block.Block) error {
+	r.blocks <- blk
+	return nil
+}
+
+func (r *subscriptionRecorder) HandleTransfers(_ context.Context, transactions []*transaction.Transaction) error {
+	r.transfers = append(r.transfers, transactions...)
+	return nil
+}
+
+func (r *subscriptionRecorder) HandleRollback(_ context.Context, heights []int64) error {
+	r.rollbacks <- heights
+	return nil
+}
+
+func TestBlockchain_Subscribe(t *testing.T) {
+	retryInterval := SubscribeRetryInterval
+	SubscribeRetryInterval = 10 * time.Millisecond
+	defer func() { SubscribeRetryInterval = retryInterval }()
+
+	chain := &fakeChain{head: 10}
+
+	bl, dropConnections := newFakeChainBlockchain(t, chain)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	recorder := &subscriptionRecorder{blocks: make(chan *block.Block, 10), rollbacks: make(chan []int64, 10)}
+	go bl.Subscribe(ctx, recorder)
+
+	expect := func(number int64) *block.Block {
+		select {
+		case blk := <-recorder.blocks:
+			if blk.Number != number {
+				t.Fatalf("expected block %d, got %d", number, blk.Number)
+			}
+
+			return blk
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for block %d", number)
+		}
+
+		return nil
+	}
+
+	expect(10)
+
+	// drop the connection while two blocks are produced
+	chain.mu.Lock()
+	chain.head = 12
+	chain.mu.Unlock()
+
+	dropConnections()
+
+	expect(11)
+	orphan := expect(12)
+
+	// block 12 is replaced while 13 is produced
+	chain.mu.Lock()
+	chain.head = 13
+	chain.forkAt = 12
+	chain.fork = 1
+	chain.mu.Unlock()
+
+	dropConnections()
+
+	select {
+	case heights := <-recorder.rollbacks:
+		if len(heights) != 1 || heights[0] != 12 {
+			t.Fatalf("expected block 12 to be rolled back, got %v", heights)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for the rollback")
+	}
+
+	if blk := expect(12); blk.Hash == orphan.Hash {
+		t.Errorf("expected the new block 12, got %s", blk.Hash)
+	}
+	expect(13)
+}
+
+func TestBlockchain_SubscribeBackfillTransfers(t *testing.T) {
+	retryInterval := SubscribeRetryInterval
+	SubscribeRetryInterval = 10 * time.Millisecond
+	defer func() { SubscribeRetryInterval = retryInterval }()
+
+	chain := &fakeChain{head: 10}
+
+	bl, dropConnections := newFakeChainBlockchain(t, chain)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	recorder := &subscriptionRecorder{blocks: make(chan *block.Block, 10), rollbacks: make(chan []int64, 10)}
+	go bl.Subscribe(ctx, recorder)
+
+	expect := func(number int64) {
+		select {
+		case blk := <-recorder.blocks:
+			if blk.Number != number {
+				t.Fatalf("expected block %d, got %d", number, blk.Number)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for block %d", number)
+		}
+	}
+
+	expect(10)
+
+	// a transfer is mined in block 11 while the connection is dropped
+	chain.mu.Lock()
+	chain.head = 12
+	chain.logs = []map[string]interface{}{{
+		"address":          "0x337610d27c682e347c9cd60bd4b3b107c9d34ddd",
+		"topics":           []string{tokenEventIdentifier, common.HexToHash("0x249aeb18f3a323c12334a595cb6220912c4b9087").Hex(), common.HexToHash("0xf37111de2f6ae2f64be1e59472b5c50801540c8c").Hex()},
+		"data":             "0x00000000000000000000000000000000000000000000000000000000000f4240",
+		"blockNumber":      "0xb",
+		"blockHash":        common.HexToHash("0x02").Hex(),
+		"transactionHash":  common.HexToHash("0x01").Hex(),
+		"transactionIndex": "0x0",
+		"logIndex":         "0x0",
+		"removed":          false,
+	}}
+	chain.mu.Unlock()
+
+	dropConnections()
+
+	expect(11)
+	expect(12)
+
+	// the backfilled transfers are handled before the blocks
+	if len(recorder.transfers) != 1 || recorder.transfers[0].BlockNumber != 11 || recorder.transfers[0].Confirmations != 2 {
+		t.Fatalf("expected the transfer of block 11 to be backfilled, got %v", recorder.transfers)
+	}
+}
+
+func TestBlockchain_SubscribeHTTP(t *testing.T) {
+	bl := newTestBlockchain(t, "http://127.0.0.1:0")
+
+	if err := bl.Subscribe(context.Background(), &subscriptionRecorder{}); !errors.Is(err, errors.ErrInvalidConfig) {
+		t.Errorf("expected invalid config error, got %v", err)
+	}
+}
+
+func TestBlockchain_SubscribeConfirmTransfers(t *testing.T) {
+	bl := newTestBlockchain(t, "http://127.0.0.1:0")
+	bl.setting.Currencies[1].Options["min_confirmations"] = 3
+
+	recorder := &subscriptionRecorder{}
+	s := &subscription{
+		handler: recorder,
+		head:    11,
+		pending: map[string]*transaction.Transaction{
+			"0x1:0": {Currency: "USDT", BlockNumber: 10, Status: transaction.StatusPending},
+		},
+	}
+
+	if err := bl.confirmTransfers(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recorder.transfers) != 0 || len(s.pending) != 1 {
+		t.Fatalf("expected the transfer to stay pending with 2 confirmations, got %v", recorder.transfers)
+	}
+
+	s.head = 12
+	if err := bl.confirmTransfers(context.Background(), s); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(recorder.transfers) != 1 || !recorder.transfers[0].IsSuccess() || recorder.transfers[0].Confirmations != 3 || len(s.pending) != 0 {
+		t.Errorf("expected the transfer to be confirmed, got %v", recorder.transfers)
+	}
+}
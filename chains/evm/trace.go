@@ -0,0 +1,230 @@
+package evm
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/shopspring/decimal"
+	"github.com/volatiletech/null/v9"
+
+	"github.com/zsmartex/multichain/pkg/currency"
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/transaction"
+)
+
+const (
+	// TraceModeDebug trace blocks with debug_traceBlockByNumber and the callTracer of geth
+	TraceModeDebug = "debug"
+	// TraceModeParity trace blocks with trace_block of Erigon and OpenEthereum
+	TraceModeParity = "parity"
+)
+
+// internalTransfer is a value transfer made by a CALL inside a transaction
+type internalTransfer struct {
+	TxIndex      int
+	TraceAddress []int
+	From         common.Address
+	To           common.Address
+	Value        *big.Int
+}
+
+// callFrame is a call of the geth callTracer
+type callFrame struct {
+	Type  string          `json:"type"`
+	From  common.Address  `json:"from"`
+	To    *common.Address `json:"to"`
+	Value *hexutil.Big    `json:"value"`
+	Error string          `json:"error"`
+	Calls []callFrame     `json:"calls"`
+}
+
+// parityTrace is a call of trace_block
+type parityTrace struct {
+	Action struct {
+		CallType string          `json:"callType"`
+		From     common.Address  `json:"from"`
+		To       *common.Address `json:"to"`
+		Value    *hexutil.Big    `json:"value"`
+	} `json:"action"`
+	Error               string `json:"error"`
+	TraceAddress        []int  `json:"traceAddress"`
+	TransactionPosition *int   `json:"transactionPosition"`
+	Type                string `json:"type"`
+}
+
+// traceMode return the trace_mode option of the native currency, tracing is disabled when it's empty
+func (b *Blockchain) traceMode() string {
+	mode, _ := b.currency.Options["trace_mode"].(string)
+
+	return mode
+}
+
+func validateTraceMode(c *currency.Currency) error {
+	switch c.Options["trace_mode"] {
+	case nil, TraceModeDebug, TraceModeParity:
+		return nil
+	default:
+		return errors.NewConfigError("currency.options.trace_mode", "%v of %s is not %s or %s", c.Options["trace_mode"], c.ID, TraceModeDebug, TraceModeParity)
+	}
+}
+
+// buildInternalTransactions return the internal native transfers of the successful transactions of blk
+func (b *Blockchain) buildInternalTransactions(ctx context.Context, blk *types.Block, receipts []*receipt) ([]*transaction.Transaction, error) {
+	var transfers []internalTransfer
+	var err error
+	switch b.traceMode() {
+	case TraceModeDebug:
+		transfers, err = b.debugTraceTransfers(ctx, blk.Number())
+	case TraceModeParity:
+		transfers, err = b.parityTraceTransfers(ctx, blk.Number())
+	default:
+		return []*transaction.Transaction{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	transactions := make([]*transaction.Transaction, 0, len(transfers))
+	for _, transfer := range transfers {
+		if transfer.TxIndex >= len(receipts) {
+			return nil, fmt.Errorf("trace of transaction %d is out of block %s", transfer.TxIndex, blk.Hash().Hex())
+		}
+
+		receipt := receipts[transfer.TxIndex]
+		if b.transactionStatus(&receipt.Receipt) != transaction.StatusSucceed {
+			continue
+		}
+
+		fee := decimal.NewFromBigInt(receipt.Fee(blk.Transactions()[transfer.TxIndex], blk.BaseFee()), -b.currency.Subunits)
+
+		transactions = append(transactions, &transaction.Transaction{
+			Currency:     b.currency.ID,
+			CurrencyFee:  b.currency.ID,
+			TxHash:       null.StringFrom(receipt.TxHash.Hex()),
+			TraceAddress: formatTraceAddress(transfer.TraceAddress),
+			FromAddress:  transfer.From.Hex(),
+			ToAddress:    transfer.To.Hex(),
+			Fee:          decimal.NewNullDecimal(fee),
+			Amount:       decimal.NewFromBigInt(transfer.Value, -b.currency.Subunits),
+			BlockNumber:  receipt.BlockNumber.Int64(),
+			Status:       transaction.StatusSucceed,
+		})
+	}
+
+	return transactions, nil
+}
+
+func (b *Blockchain) debugTraceTransfers(ctx context.Context, number *big.Int) ([]internalTransfer, error) {
+	var results []struct {
+		Result callFrame `json:"result"`
+	}
+	if err := b.rpcClient.Call(ctx, &results, "debug_traceBlockByNumber", hexutil.EncodeBig(number), map[string]string{"tracer": "callTracer"}); err != nil {
+		return nil, mapError(err, errors.ErrBlockNotFound)
+	}
+
+	transfers := make([]internalTransfer, 0)
+	for i, result := range results {
+		// the outer call is the transaction itself
+		if len(result.Result.Error) > 0 {
+			continue
+		}
+
+		for j, call := range result.Result.Calls {
+			transfers = appendCallTransfers(transfers, i, []int{j}, call)
+		}
+	}
+
+	return transfers, nil
+}
+
+// appendCallTransfers append the value transfers of call and its sub calls, a failed call revert all its sub calls
+func appendCallTransfers(transfers []internalTransfer, txIndex int, traceAddress []int, call callFrame) []internalTransfer {
+	if len(call.Error) > 0 {
+		return transfers
+	}
+
+	if call.Type == "CALL" && call.To != nil && call.Value != nil && call.Value.ToInt().Sign() > 0 {
+		transfers = append(transfers, internalTransfer{
+			TxIndex:      txIndex,
+			TraceAddress: traceAddress,
+			From:         call.From,
+			To:           *call.To,
+			Value:        call.Value.ToInt(),
+		})
+	}
+
+	for i, sub := range call.Calls {
+		address := append(append([]int{}, traceAddress...), i)
+		transfers = appendCallTransfers(transfers, txIndex, address, sub)
+	}
+
+	return transfers
+}
+
+func (b *Blockchain) parityTraceTransfers(ctx context.Context, number *big.Int) ([]internalTransfer, error) {
+	var traces []parityTrace
+	if err := b.rpcClient.Call(ctx, &traces, "trace_block", hexutil.EncodeBig(number)); err != nil {
+		return nil, mapError(err, errors.ErrBlockNotFound)
+	}
+
+	// the trace addresses of failed calls by transaction, their sub calls are reverted
+	failed := make(map[int][]string)
+	for _, trace := range traces {
+		if trace.TransactionPosition != nil && len(trace.Error) > 0 {
+			failed[*trace.TransactionPosition] = append(failed[*trace.TransactionPosition], formatTraceAddress(trace.TraceAddress))
+		}
+	}
+
+	transfers := make([]internalTransfer, 0)
+	for _, trace := range traces {
+		// rewards don't belong to a transaction and the outer call is the transaction itself
+		if trace.TransactionPosition == nil || len(trace.TraceAddress) == 0 {
+			continue
+		}
+
+		if trace.Type != "call" || trace.Action.CallType != "call" || trace.Action.To == nil || trace.Action.Value == nil || trace.Action.Value.ToInt().Sign() <= 0 {
+			continue
+		}
+
+		if isReverted(failed[*trace.TransactionPosition], formatTraceAddress(trace.TraceAddress)) {
+			continue
+		}
+
+		transfers = append(transfers, internalTransfer{
+			TxIndex:      *trace.TransactionPosition,
+			TraceAddress: trace.TraceAddress,
+			From:         trace.Action.From,
+			To:           *trace.Action.To,
+			Value:        trace.Action.Value.ToInt(),
+		})
+	}
+
+	return transfers, nil
+}
+
+// isReverted report whether the call at traceAddress or one of its parents failed
+func isReverted(failed []string, traceAddress string) bool {
+	for _, address := range failed {
+		if address == "" || address == traceAddress || strings.HasPrefix(traceAddress, address+".") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// formatTraceAddress join the indexes of the calls leading to an internal call, 0.1 is the second sub call of the first call
+func formatTraceAddress(traceAddress []int) string {
+	parts := make([]string, len(traceAddress))
+	for i, index := range traceAddress {
+		parts[i] = strconv.Itoa(index)
+	}
+
+	return strings.Join(parts, ".")
+}
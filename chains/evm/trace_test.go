@@ -0,0 +1,88 @@
+package evm
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/zsmartex/multichain/internal/rpctest"
+)
+
+func TestBlockchain_BuildInternalTransactions(t *testing.T) {
+	blk := newTestBlock(2)
+
+	receipts := make([]*receipt, len(blk.Transactions()))
+	for i, tx := range blk.Transactions() {
+		raw, _ := json.Marshal(receiptJSON(tx.Hash()))
+
+		receipts[i] = new(receipt)
+		if err := json.Unmarshal(raw, receipts[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const (
+		multisig = "0x249aeb18f3a323c12334a595cb6220912c4b9087"
+		deposit  = "0xf37111de2f6ae2f64be1e59472b5c50801540c8c"
+	)
+
+	tests := []struct {
+		mode    string
+		results map[string]interface{}
+	}{
+		{
+			mode: TraceModeDebug,
+			results: map[string]interface{}{
+				"debug_traceBlockByNumber": []interface{}{
+					map[string]interface{}{"result": map[string]interface{}{"type": "CALL", "from": deposit, "to": multisig, "value": "0x0"}},
+					map[string]interface{}{"result": map[string]interface{}{
+						"type": "CALL", "from": deposit, "to": multisig, "value": "0x0",
+						"calls": []interface{}{
+							map[string]interface{}{"type": "STATICCALL", "from": multisig, "to": deposit},
+							map[string]interface{}{"type": "CALL", "from": multisig, "to": deposit, "value": "0xde0b6b3a7640000"},
+							map[string]interface{}{"type": "CALL", "from": multisig, "to": deposit, "value": "0x1", "error": "execution reverted",
+								"calls": []interface{}{map[string]interface{}{"type": "CALL", "from": deposit, "to": multisig, "value": "0x1"}}},
+						},
+					}},
+				},
+			},
+		},
+		{
+			mode: TraceModeParity,
+			results: map[string]interface{}{
+				"trace_block": []interface{}{
+					map[string]interface{}{"type": "call", "action": map[string]interface{}{"callType": "call", "from": deposit, "to": multisig, "value": "0x0"}, "traceAddress": []int{}, "transactionPosition": 1},
+					map[string]interface{}{"type": "call", "action": map[string]interface{}{"callType": "staticcall", "from": multisig, "to": deposit, "value": "0x0"}, "traceAddress": []int{0}, "transactionPosition": 1},
+					map[string]interface{}{"type": "call", "action": map[string]interface{}{"callType": "call", "from": multisig, "to": deposit, "value": "0xde0b6b3a7640000"}, "traceAddress": []int{1}, "transactionPosition": 1},
+					map[string]interface{}{"type": "call", "action": map[string]interface{}{"callType": "call", "from": multisig, "to": deposit, "value": "0x1"}, "traceAddress": []int{2}, "transactionPosition": 1, "error": "Reverted"},
+					map[string]interface{}{"type": "call", "action": map[string]interface{}{"callType": "call", "from": deposit, "to": multisig, "value": "0x1"}, "traceAddress": []int{2, 0}, "transactionPosition": 1},
+					map[string]interface{}{"type": "reward", "action": map[string]interface{}{"author": multisig, "value": "0x1"}, "traceAddress": []int{}},
+				},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.mode, func(t *testing.T) {
+			server := rpctest.NewServer(t, test.results)
+			defer server.Close()
+
+			bl := newTestBlockchain(t, server.URL)
+			bl.currency.Options = map[string]interface{}{"trace_mode": test.mode}
+
+			transactions, err := bl.buildInternalTransactions(context.Background(), blk, receipts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(transactions) != 1 {
+				t.Fatalf("expected 1 internal transfer, got %d", len(transactions))
+			}
+
+			tx := transactions[0]
+			if tx.TxHash.String != blk.Transactions()[1].Hash().Hex() || tx.TraceAddress != "1" || tx.Amount.String() != "1" || tx.Currency != "BSC" {
+				t.Errorf("unexpected internal transfer %+v", tx)
+			}
+		})
+	}
+}
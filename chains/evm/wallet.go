@@ -2,21 +2,20 @@ package evm
 
 import (
 	"context"
-	"encoding/json"
-	"errors"
+	"fmt"
 	"math"
 	"math/big"
-	"math/rand"
 	"strings"
+	"sync"
 
 	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/hexutil"
-	"github.com/go-resty/resty/v2"
 	"github.com/shopspring/decimal"
 	"github.com/volatiletech/null/v9"
 
 	"github.com/zsmartex/multichain/pkg/currency"
+	"github.com/zsmartex/multichain/pkg/rpc"
 	"github.com/zsmartex/multichain/pkg/transaction"
 	"github.com/zsmartex/multichain/pkg/utils"
 	"github.com/zsmartex/multichain/pkg/wallet"
@@ -32,100 +31,127 @@ var defaultErc20Fee = map[string]interface{}{
 	"gas_rate":  wallet.GasPriceRateStandard,
 }
 
+var defaultNFTFee = map[string]interface{}{
+	"gas_limit": 150_000,
+	"gas_rate":  wallet.GasPriceRateStandard,
+}
+
 type Wallet struct {
-	client   *resty.Client
+	client   *rpc.Client
 	currency *currency.Currency    // selected currency for this wallet
 	wallet   *wallet.SettingWallet // selected wallet for this currency
+
+	nonces NonceManager
+
+	mu            sync.Mutex
+	cachedChainID *big.Int
+}
+
+func init() {
+	wallet.Register("evm", NewWallet)
 }
 
 func NewWallet() wallet.Wallet {
-	return &Wallet{
-		client: resty.New(),
-	}
+	return &Wallet{}
 }
 
-func (w *Wallet) Configure(settings *wallet.Setting) {
-	if settings.Wallet != nil {
-		w.wallet = settings.Wallet
+func (w *Wallet) Configure(settings *wallet.Setting) error {
+	if err := settings.Validate(); err != nil {
+		return err
 	}
 
 	if settings.Currency != nil {
-		w.currency = settings.Currency
+		if err := validateContractAddress(settings.Currency); err != nil {
+			return err
+		}
 	}
-}
 
-func (w *Wallet) jsonRPC(ctx context.Context, resp interface{}, method string, params ...interface{}) error {
-	type Result struct {
-		Version string           `json:"version"`
-		ID      int              `json:"id"`
-		Result  *json.RawMessage `json:"result"`
-		Error   *json.RawMessage `json:"error"`
-	}
-
-	response, err := w.client.
-		R().
-		SetContext(ctx).
-		SetResult(Result{}).
-		SetHeaders(map[string]string{
-			"Accept":       "application/json",
-			"Content-Type": "application/json",
-		}).
-		SetBody(map[string]interface{}{
-			"version": "2.0",
-			"id":      rand.Int(),
-			"method":  method,
-			"params":  params,
-		}).
-		Post(w.wallet.URI)
+	if settings.Wallet != nil {
+		w.wallet = settings.Wallet
+		w.client = rpc.NewClient(settings.Wallet.URI, rpc.WithErrorParser(parseRPCError), rpc.WithHealthCheck(rpc.DefaultHealthInterval, "eth_blockNumber"))
 
-	if err != nil {
-		return err
+		w.mu.Lock()
+		w.cachedChainID = nil
+		w.mu.Unlock()
 	}
 
-	result := response.Result().(*Result)
-
-	if result.Error != nil {
-		return errors.New("jsonRPC error: " + string(*result.Error))
+	if settings.Currency != nil {
+		w.currency = settings.Currency
 	}
 
-	if result.Result == nil {
-		return errors.New("jsonRPC error: result is nil")
-	}
+	return nil
+}
 
-	if err := json.Unmarshal(*result.Result, resp); err != nil {
-		return err
+// SetNonceManager replace the DefaultNonceManager, use a manager backed by a shared store
+// when several processes send transactions from the same address
+func (w *Wallet) SetNonceManager(nonces NonceManager) {
+	w.nonces = nonces
+}
+
+func (w *Wallet) nonceManager() NonceManager {
+	if w.nonces == nil {
+		return DefaultNonceManager
 	}
 
-	return nil
+	return w.nonces
 }
 
 func (w *Wallet) CreateAddress(ctx context.Context) (address, secret string, err error) {
+	if w.isLocalSigner() {
+		return generateKey()
+	}
+
 	secret = utils.RandomString(32)
 
-	err = w.jsonRPC(ctx, &address, "personal_newAccount", secret)
+	err = w.client.Call(ctx, &address, "personal_newAccount", secret)
 
 	return
 }
 
-// PrepareDepositCollection this func don't execute create transaction just return transaction was built
+// PrepareDepositCollection this func don't execute create transaction just return transaction was built,
+// it sends the fee of the token transfers of the deposit spreads to the deposit address, native deposits return nil
 func (w *Wallet) PrepareDepositCollection(ctx context.Context, tx *transaction.Transaction, depositSpreads []*transaction.Transaction, depositCurrency *currency.Currency) (*transaction.Transaction, error) {
-	if depositCurrency.Options["erc20_contract_address"] == nil {
+	var options map[string]interface{}
+	var contractAddress common.Address
+	switch {
+	case depositCurrency.Options["erc20_contract_address"] != nil:
+		options = w.mergeOptions(nil, defaultErc20Fee, depositCurrency.Options)
+		contractAddress = common.HexToAddress(depositCurrency.Options["erc20_contract_address"].(string))
+	case len(nftContractAddress(depositCurrency)) > 0:
+		options = w.mergeOptions(nil, defaultNFTFee, depositCurrency.Options)
+		contractAddress = common.HexToAddress(nftContractAddress(depositCurrency))
+	default:
 		return nil, nil
 	}
 
-	options := w.mergeOptions(defaultEvmFee, depositCurrency.Options)
-
-	gasPrice, err := w.calculateGasPrice(ctx, options)
+	gasFee, err := w.calculateGasFee(ctx, options)
 	if err != nil {
 		return nil, err
 	}
 
-	gasLimit := uint64(options["gas_limit"].(int))
+	fees := decimal.Zero
+
+	// each deposit spread is a token transfer sent from the deposit address
+	for _, spread := range depositSpreads {
+		var data []byte
+		if depositCurrency.Options["erc20_contract_address"] != nil {
+			data, err = packTransfer(spread.ToAddress, spread.Amount.Shift(depositCurrency.Subunits).BigInt())
+		} else {
+			data, err = packNFTTransfer(depositCurrency, common.HexToAddress(tx.ToAddress), common.HexToAddress(spread.ToAddress), spread.TokenID, spread.Amount)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		gasLimit, err := w.estimateGasLimit(ctx, tx.ToAddress, &txCall{To: contractAddress, Data: data}, options)
+		if err != nil {
+			return nil, err
+		}
 
-	fees := decimal.NewFromBigInt(big.NewInt(int64(gasLimit*gasPrice)), -w.currency.Subunits)
-	amount := fees.Mul(decimal.NewFromInt(int64(len(depositSpreads))))
+		fees = fees.Add(decimal.NewFromBigInt(gasFee.Total(gasLimit), -w.currency.Subunits))
+	}
 
-	tx.Amount = amount
+	tx.Amount = fees
 
 	return w.createEvmTransaction(ctx, tx, nil)
 }
@@ -133,45 +159,53 @@ func (w *Wallet) PrepareDepositCollection(ctx context.Context, tx *transaction.T
 func (w *Wallet) CreateTransaction(ctx context.Context, tx *transaction.Transaction, options map[string]interface{}) (*transaction.Transaction, error) {
 	if len(w.ContractAddress()) > 0 {
 		return w.createErc20Transaction(ctx, tx, options)
+	} else if len(nftContractAddress(w.currency)) > 0 {
+		return w.createNFTTransaction(ctx, tx, options)
 	} else {
 		return w.createEvmTransaction(ctx, tx, options)
 	}
 }
 
 func (w *Wallet) createEvmTransaction(ctx context.Context, tx *transaction.Transaction, options map[string]interface{}) (t *transaction.Transaction, err error) {
-	options = w.mergeOptions(options, defaultEvmFee, w.currency.Options)
+	options = w.mergeOptions(nil, defaultEvmFee, w.currency.Options, options)
 
 	if tx.Options["gas_price"] != nil {
 		options["gas_price"] = tx.Options["gas_price"]
-	} else {
-		gasPrice, err := w.calculateGasPrice(ctx, options)
-		if err != nil {
-			return nil, err
-		}
-
-		options["gas_price"] = int(gasPrice)
 	}
 
-	gasLimit := uint64(options["gas_limit"].(int))
-	gasPrice := uint64(options["gas_price"].(int))
+	gasFee, err := w.calculateGasFee(ctx, options)
+	if err != nil {
+		return nil, err
+	}
 
 	amount := w.ConvertToBaseUnit(tx.Amount)
-	fee := decimal.NewFromInt(int64(gasLimit * gasPrice))
+
+	from, _, err := w.sender()
+	if err != nil {
+		return nil, err
+	}
+
+	call := &txCall{
+		To:    common.HexToAddress(tx.ToAddress),
+		Value: amount.BigInt(),
+		Fee:   gasFee,
+	}
+
+	call.GasLimit, err = w.estimateGasLimit(ctx, from.Hex(), call, options)
+	if err != nil {
+		return nil, err
+	}
+
+	fee := decimal.NewFromBigInt(gasFee.Total(call.GasLimit), 0)
 
 	if options["subtract_fee"] != nil {
 		if options["subtract_fee"].(bool) {
 			amount = amount.Sub(fee)
+			call.Value = amount.BigInt()
 		}
 	}
 
-	var txid string
-	err = w.jsonRPC(ctx, &txid, "personal_sendTransaction", map[string]string{
-		"from":     w.normalizeAddress(w.wallet.Address),
-		"to":       w.normalizeAddress(tx.ToAddress),
-		"value":    hexutil.EncodeBig(amount.BigInt()),
-		"gas":      hexutil.EncodeUint64(gasLimit),
-		"gasPrice": hexutil.EncodeUint64(gasPrice),
-	}, w.wallet.Secret)
+	txid, err := w.sendTransaction(ctx, call)
 	if err != nil {
 		return nil, err
 	}
@@ -184,44 +218,43 @@ func (w *Wallet) createEvmTransaction(ctx context.Context, tx *transaction.Trans
 }
 
 func (w *Wallet) createErc20Transaction(ctx context.Context, tx *transaction.Transaction, options map[string]interface{}) (*transaction.Transaction, error) {
-	options = w.mergeOptions(options, defaultErc20Fee, w.currency.Options)
+	options = w.mergeOptions(nil, defaultErc20Fee, w.currency.Options, options)
 
 	if tx.Options["gas_price"] != nil {
 		options["gas_price"] = tx.Options["gas_price"]
-	} else {
-		gasPrice, err := w.calculateGasPrice(ctx, options)
-		if err != nil {
-			return nil, err
-		}
+	}
 
-		options["gas_price"] = int(gasPrice)
+	gasFee, err := w.calculateGasFee(ctx, options)
+	if err != nil {
+		return nil, err
 	}
 
 	amount := w.ConvertToBaseUnit(tx.Amount)
 
-	abiJSON, err := abi.JSON(strings.NewReader(abiDefinition))
+	data, err := packTransfer(tx.ToAddress, amount.BigInt())
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := abiJSON.Pack("transfer", common.HexToAddress(w.normalizeAddress(tx.ToAddress)), amount.BigInt())
+	from, _, err := w.sender()
 	if err != nil {
 		return nil, err
 	}
 
-	gasLimit := uint64(options["gas_limit"].(int))
-	gasPrice := uint64(options["gas_price"].(int))
+	call := &txCall{
+		To:   common.HexToAddress(w.ContractAddress()), // to contract address
+		Data: data,
+		Fee:  gasFee,
+	}
+
+	call.GasLimit, err = w.estimateGasLimit(ctx, from.Hex(), call, options)
+	if err != nil {
+		return nil, err
+	}
 
-	fee := decimal.NewFromInt(int64(gasLimit * gasPrice))
+	fee := decimal.NewFromBigInt(gasFee.Total(call.GasLimit), 0)
 
-	var txid string
-	err = w.jsonRPC(ctx, &txid, "personal_sendTransaction", map[string]string{
-		"from":     w.normalizeAddress(w.wallet.Address),
-		"to":       w.ContractAddress(), // to contract address
-		"data":     hexutil.Encode(data),
-		"gas":      hexutil.EncodeUint64(gasLimit),
-		"gasPrice": hexutil.EncodeUint64(gasPrice),
-	}, w.wallet.Secret)
+	txid, err := w.sendTransaction(ctx, call)
 	if err != nil {
 		return nil, err
 	}
@@ -233,6 +266,65 @@ func (w *Wallet) createErc20Transaction(ctx context.Context, tx *transaction.Tra
 	return tx, nil
 }
 
+// createNFTTransaction send the token tx.TokenID with safeTransferFrom, tx.Amount is only used by ERC1155 contracts
+func (w *Wallet) createNFTTransaction(ctx context.Context, tx *transaction.Transaction, options map[string]interface{}) (*transaction.Transaction, error) {
+	options = w.mergeOptions(nil, defaultNFTFee, w.currency.Options, options)
+
+	if tx.Options["gas_price"] != nil {
+		options["gas_price"] = tx.Options["gas_price"]
+	}
+
+	gasFee, err := w.calculateGasFee(ctx, options)
+	if err != nil {
+		return nil, err
+	}
+
+	// the tokens are moved from the address of the signing key
+	from, _, err := w.sender()
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := packNFTTransfer(w.currency, from, common.HexToAddress(w.normalizeAddress(tx.ToAddress)), tx.TokenID, tx.Amount)
+	if err != nil {
+		return nil, err
+	}
+
+	call := &txCall{
+		To:   common.HexToAddress(nftContractAddress(w.currency)), // to contract address
+		Data: data,
+		Fee:  gasFee,
+	}
+
+	call.GasLimit, err = w.estimateGasLimit(ctx, from.Hex(), call, options)
+	if err != nil {
+		return nil, err
+	}
+
+	fee := decimal.NewFromBigInt(gasFee.Total(call.GasLimit), 0)
+
+	txid, err := w.sendTransaction(ctx, call)
+	if err != nil {
+		return nil, err
+	}
+
+	tx.Fee = decimal.NewNullDecimal(w.ConvertFromBaseUnit(fee))
+	tx.Status = transaction.StatusPending
+	tx.TxHash = null.StringFrom(txid)
+
+	return tx, nil
+}
+
+// packTransfer encode the ERC20 transfer call data
+func packTransfer(to string, amount *big.Int) ([]byte, error) {
+	abiJSON, err := abi.JSON(strings.NewReader(abiDefinition))
+	if err != nil {
+		return nil, err
+	}
+
+	return abiJSON.Pack("transfer", common.HexToAddress(to), amount)
+}
+
 func (w *Wallet) normalizeAddress(address string) string {
 	if !strings.HasPrefix(address, "0x") {
 		address = "0x" + address
@@ -251,7 +343,7 @@ func (w *Wallet) ContractAddress() string {
 
 func (w *Wallet) calculateGasPrice(ctx context.Context, options map[string]interface{}) (uint64, error) {
 	var result string
-	if err := w.jsonRPC(ctx, &result, "eth_gasPrice"); err != nil {
+	if err := w.client.Call(ctx, &result, "eth_gasPrice"); err != nil {
 		return 0, err
 	}
 
@@ -272,16 +364,28 @@ func (w *Wallet) calculateGasPrice(ctx context.Context, options map[string]inter
 }
 
 func (w *Wallet) LoadBalance(ctx context.Context) (balance decimal.Decimal, err error) {
-	if len(w.ContractAddress()) > 0 {
-		return w.loadBalanceErc20Balance(ctx, w.wallet.Address)
-	} else {
-		return w.loadBalanceEvmBalance(ctx, w.wallet.Address)
+	from, _, err := w.sender()
+	if err != nil {
+		return decimal.Zero, err
+	}
+
+	switch {
+	case len(w.ContractAddress()) > 0:
+		return w.loadBalanceErc20Balance(ctx, w.ContractAddress(), from.Hex())
+	case w.currency.Options["erc721_contract_address"] != nil:
+		// the number of tokens held, an ERC721 token is a single unit
+		balance, err := w.loadBalanceErc20Balance(ctx, nftContractAddress(w.currency), from.Hex())
+		return balance.Shift(w.currency.Subunits), err
+	case w.currency.Options["erc1155_contract_address"] != nil:
+		return decimal.Zero, fmt.Errorf("%w: %s", ErrERC1155Balance, w.currency.ID)
+	default:
+		return w.loadBalanceEvmBalance(ctx, from.Hex())
 	}
 }
 
 func (w *Wallet) loadBalanceEvmBalance(ctx context.Context, address string) (balance decimal.Decimal, err error) {
 	var result string
-	err = w.jsonRPC(ctx, &result, "eth_getBalance", address, "latest")
+	err = w.client.Call(ctx, &result, "eth_getBalance", address, "latest")
 	if err != nil {
 		return
 	}
@@ -289,7 +393,8 @@ func (w *Wallet) loadBalanceEvmBalance(ctx context.Context, address string) (bal
 	return w.hexToDecimal(result)
 }
 
-func (w *Wallet) loadBalanceErc20Balance(ctx context.Context, address string) (balance decimal.Decimal, err error) {
+// loadBalanceErc20Balance call balanceOf(address) on contractAddress, ERC721 contracts have the same method
+func (w *Wallet) loadBalanceErc20Balance(ctx context.Context, contractAddress, address string) (balance decimal.Decimal, err error) {
 	abiJSON, err := abi.JSON(strings.NewReader(abiDefinition))
 	if err != nil {
 		return decimal.Zero, err
@@ -301,7 +406,7 @@ func (w *Wallet) loadBalanceErc20Balance(ctx context.Context, address string) (b
 	}
 
 	var result string
-	if err := w.jsonRPC(ctx, &result, "eth_call", map[string]string{"to": w.ContractAddress(), "data": hexutil.Encode(data)}, "latest"); err != nil {
+	if err := w.client.Call(ctx, &result, "eth_call", map[string]string{"to": contractAddress, "data": hexutil.Encode(data)}, "latest"); err != nil {
 		return decimal.Zero, err
 	}
 
@@ -323,6 +428,7 @@ func (w *Wallet) hexToDecimal(hex string) (decimal.Decimal, error) {
 	return decimal.NewFromBigInt(b, -w.currency.Subunits), nil
 }
 
+// mergeOptions copy steps over first in order, the options of a later step override the earlier ones
 func (w *Wallet) mergeOptions(first map[string]interface{}, steps ...map[string]interface{}) map[string]interface{} {
 	if first == nil {
 		first = make(map[string]interface{})
@@ -3,9 +3,9 @@ package tron
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"math/big"
+	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/huandu/xstrings"
@@ -16,12 +16,15 @@ import (
 	"github.com/zsmartex/multichain/pkg/block"
 	"github.com/zsmartex/multichain/pkg/blockchain"
 	"github.com/zsmartex/multichain/pkg/currency"
+	"github.com/zsmartex/multichain/pkg/errors"
 	"github.com/zsmartex/multichain/pkg/transaction"
 )
 
 type BlockHeader struct {
 	RawData struct {
-		Number int64 `json:"number"`
+		Number     int64  `json:"number"`
+		ParentHash string `json:"parentHash"`
+		Timestamp  int64  `json:"timestamp"` // milliseconds
 	} `json:"raw_data"`
 }
 
@@ -50,6 +53,7 @@ type Transaction struct {
 
 type TransactionInfo struct {
 	ID              string `json:"id"`
+	BlockNumber     int64  `json:"blockNumber"`
 	ContractAddress string `json:"contract_address"`
 	Receipt         struct {
 		Result string
@@ -62,6 +66,7 @@ type TransactionInfo struct {
 }
 
 type Block struct {
+	BlockID      string         `json:"blockID"`
 	BlockHeader  BlockHeader    `json:"block_header"`
 	Transactions []*Transaction `json:"transactions"`
 }
@@ -83,29 +88,69 @@ type Blockchain struct {
 	setting    *blockchain.Setting
 }
 
+func init() {
+	blockchain.Register("tron", NewBlockchain)
+}
+
 func NewBlockchain() blockchain.Blockchain {
 	return &Blockchain{
 		contracts: make([]*currency.Currency, 0),
 	}
 }
 
-func (b *Blockchain) Configure(setting *blockchain.Setting) {
-	b.setting = setting
-	b.client = resty.New()
-	b.currencies = setting.Currencies
+func (b *Blockchain) Configure(setting *blockchain.Setting) error {
+	if err := setting.Validate(); err != nil {
+		return err
+	}
 
+	var nativeCurrency *currency.Currency
+	contracts := make([]*currency.Currency, 0)
 	for _, c := range setting.Currencies {
 		if c.Options["trc20_contract_address"] != nil {
-			b.contracts = append(b.contracts, c)
+			if err := validateContractAddress(c); err != nil {
+				return err
+			}
+
+			contracts = append(contracts, c)
 		} else {
-			b.currency = c
+			if nativeCurrency != nil {
+				return errors.NewConfigError("currencies", "expected exactly one native currency, got %s and %s", nativeCurrency.ID, c.ID)
+			}
+
+			nativeCurrency = c
 		}
 	}
+
+	if nativeCurrency == nil {
+		return errors.NewConfigError("currencies", "native currency is missing")
+	}
+
+	b.setting = setting
+	b.client = resty.New()
+	b.currencies = setting.Currencies
+	b.currency = nativeCurrency
+	b.contracts = contracts
+
+	return nil
+}
+
+func validateContractAddress(c *currency.Currency) error {
+	contractAddress, ok := c.Options["trc20_contract_address"].(string)
+	if !ok {
+		return errors.NewConfigError("currency.options.trc20_contract_address", "%v of %s is not a valid address", c.Options["trc20_contract_address"], c.ID)
+	}
+
+	address, err := concerns.Base58ToAddress(contractAddress)
+	if err != nil || len(address) != concerns.AddressLength || address[0] != concerns.TronBytePrefix {
+		return errors.NewConfigError("currency.options.trc20_contract_address", "%s of %s is not a valid address", contractAddress, c.ID)
+	}
+
+	return nil
 }
 
 func (b *Blockchain) jsonRPC(ctx context.Context, resp interface{}, method string, params interface{}) error {
 	type Result struct {
-		Error *json.RawMessage `json:"Error,omitempty"`
+		Error string `json:"Error,omitempty"`
 	}
 
 	response, err := b.client.
@@ -120,13 +165,17 @@ func (b *Blockchain) jsonRPC(ctx context.Context, resp interface{}, method strin
 		Post(fmt.Sprintf("%s/%s", b.setting.URI, method))
 
 	if err != nil {
-		return err
+		return errors.Wrap(errors.ErrNodeUnavailable, err)
+	}
+
+	if response.IsError() {
+		return parseHTTPStatus(response.StatusCode(), errors.New("jsonRPC error: "+response.Status()))
 	}
 
 	result := response.Result().(*Result)
 
-	if result.Error != nil {
-		return errors.New("jsonRPC error: " + string(*result.Error))
+	if len(result.Error) > 0 {
+		return parseRPCError("", result.Error)
 	}
 
 	if err := json.Unmarshal(response.Body(), resp); err != nil {
@@ -155,6 +204,10 @@ func (b *Blockchain) GetBlockByNumber(ctx context.Context, blockNumber int64) (*
 		return nil, err
 	}
 
+	if resp == nil || len(resp.BlockID) == 0 {
+		return nil, errors.ErrBlockNotFound
+	}
+
 	return b.buildBlock(ctx, resp)
 }
 
@@ -167,6 +220,10 @@ func (b *Blockchain) GetBlockByHash(ctx context.Context, hash string) (*block.Bl
 		return nil, err
 	}
 
+	if resp == nil || len(resp.BlockID) == 0 {
+		return nil, errors.ErrBlockNotFound
+	}
+
 	return b.buildBlock(ctx, resp)
 }
 
@@ -185,8 +242,18 @@ func (b *Blockchain) buildBlock(ctx context.Context, blk *Block) (*block.Block,
 		transactions = append(transactions, trans...)
 	}
 
+	latestBlockNumber, err := b.GetLatestBlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	b.setting.ApplyConfirmations(latestBlockNumber-blk.BlockHeader.RawData.Number+1, transactions...)
+
 	return &block.Block{
+		Hash:         blk.BlockID,
+		ParentHash:   blk.BlockHeader.RawData.ParentHash,
 		Number:       blk.BlockHeader.RawData.Number,
+		Timestamp:    time.UnixMilli(blk.BlockHeader.RawData.Timestamp),
 		Transactions: transactions,
 	}, nil
 }
@@ -269,7 +336,7 @@ func (b *Blockchain) buildTrc20Transaction(txnReceipt *TransactionInfo) ([]*tran
 	}
 
 	transactions := make([]*transaction.Transaction, 0)
-	for _, log := range txnReceipt.Log {
+	for i, log := range txnReceipt.Log {
 		if len(log.Topics) == 0 || log.Topics[0] != "ddf252ad1be2c89b69c2b068fc378daa952ba7f163c4a11628f55a4df523b3ef" {
 			continue
 		}
@@ -298,6 +365,7 @@ func (b *Blockchain) buildTrc20Transaction(txnReceipt *TransactionInfo) ([]*tran
 			Currency:    c.ID,
 			CurrencyFee: b.currency.ID,
 			TxHash:      null.StringFrom(txnReceipt.ID),
+			Position:    null.Int64From(int64(i)),
 			ToAddress:   toAddress.String(),
 			FromAddress: fromAddress.String(),
 			Amount:      amount,
@@ -419,10 +487,53 @@ func (b *Blockchain) GetTransaction(ctx context.Context, transactionHash string)
 		return nil, err
 	}
 
+	if resp == nil || len(resp.TxID) == 0 {
+		return nil, errors.ErrTxNotFound
+	}
+
 	ts, err := b.buildTransaction(ctx, resp)
 	if err != nil {
 		return nil, err
 	}
 
+	confirmations, err := b.Confirmations(ctx, transactionHash)
+	if err != nil {
+		return nil, err
+	}
+
+	b.setting.ApplyConfirmations(confirmations, ts[0])
+
 	return ts[0], err
 }
+
+func (b *Blockchain) Confirmations(ctx context.Context, transactionHash string) (int64, error) {
+	var txn *TransactionInfo
+	if err := b.jsonRPC(ctx, &txn, "wallet/gettransactioninfobyid", map[string]interface{}{
+		"value": transactionHash,
+	}); err != nil {
+		return 0, err
+	}
+
+	// transaction info is empty until the transaction is included in a block
+	if txn == nil || txn.BlockNumber == 0 {
+		var resp *Transaction
+		if err := b.jsonRPC(ctx, &resp, "wallet/gettransactionbyid", map[string]interface{}{
+			"value": transactionHash,
+		}); err != nil {
+			return 0, err
+		}
+
+		if resp == nil || len(resp.TxID) == 0 {
+			return 0, errors.ErrTxNotFound
+		}
+
+		return 0, nil
+	}
+
+	latestBlockNumber, err := b.GetLatestBlockNumber(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return latestBlockNumber - txn.BlockNumber + 1, nil
+}
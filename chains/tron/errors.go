@@ -0,0 +1,52 @@
+package tron
+
+import (
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/zsmartex/multichain/pkg/errors"
+)
+
+// parseRPCError map the error returned by a tron node onto the shared errors,
+// code is the return code of broadcast apis (e.g. CONTRACT_VALIDATE_ERROR) and can be empty
+func parseRPCError(code string, message string) error {
+	// broadcast apis return the message hex encoded
+	if decoded, err := hex.DecodeString(message); err == nil && len(decoded) > 0 {
+		message = string(decoded)
+	}
+
+	if len(code) > 0 {
+		message = code + ": " + message
+	}
+
+	rpcErr := &errors.RPCError{
+		Message: message,
+	}
+
+	msg := strings.ToLower(message)
+	switch {
+	case strings.Contains(msg, "balance is not sufficient"), strings.Contains(msg, "insufficient"):
+		rpcErr.Err = errors.ErrInsufficientFunds
+	case strings.Contains(msg, "dup_transaction_error"), strings.Contains(msg, "transaction_expiration_error"):
+		rpcErr.Err = errors.ErrNonceConflict
+	case strings.Contains(msg, "invalid address"), strings.Contains(msg, "invalid toaddress"), strings.Contains(msg, "invalid owneraddress"):
+		rpcErr.Err = errors.ErrInvalidAddress
+	case strings.Contains(msg, "server_busy"), strings.Contains(msg, "not_enough_effective_connection"):
+		rpcErr.Err = errors.ErrNodeUnavailable
+	}
+
+	return rpcErr
+}
+
+// parseHTTPStatus map a failed http status onto the shared errors
+func parseHTTPStatus(statusCode int, err error) error {
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return errors.Wrap(errors.ErrRateLimited, err)
+	case statusCode >= http.StatusInternalServerError:
+		return errors.Wrap(errors.ErrNodeUnavailable, err)
+	default:
+		return err
+	}
+}
@@ -3,7 +3,6 @@ package tron
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"math"
 	"math/big"
@@ -17,6 +16,7 @@ import (
 
 	"github.com/zsmartex/multichain/chains/tron/concerns"
 	"github.com/zsmartex/multichain/pkg/currency"
+	"github.com/zsmartex/multichain/pkg/errors"
 	"github.com/zsmartex/multichain/pkg/transaction"
 	"github.com/zsmartex/multichain/pkg/wallet"
 )
@@ -35,13 +35,27 @@ type Wallet struct {
 	wallet   *wallet.SettingWallet // selected wallet for this currency
 }
 
+func init() {
+	wallet.Register("tron", NewWallet)
+}
+
 func NewWallet() wallet.Wallet {
 	return &Wallet{
 		client: resty.New(),
 	}
 }
 
-func (w *Wallet) Configure(settings *wallet.Setting) {
+func (w *Wallet) Configure(settings *wallet.Setting) error {
+	if err := settings.Validate(); err != nil {
+		return err
+	}
+
+	if settings.Currency != nil && settings.Currency.Options["trc20_contract_address"] != nil {
+		if err := validateContractAddress(settings.Currency); err != nil {
+			return err
+		}
+	}
+
 	if settings.Currency != nil {
 		w.currency = settings.Currency
 	}
@@ -49,11 +63,13 @@ func (w *Wallet) Configure(settings *wallet.Setting) {
 	if settings.Wallet != nil {
 		w.wallet = settings.Wallet
 	}
+
+	return nil
 }
 
 func (w *Wallet) jsonRPC(ctx context.Context, resp interface{}, method string, params interface{}) error {
 	type Result struct {
-		Error *json.RawMessage `json:"Error,omitempty"`
+		Error string `json:"Error,omitempty"`
 	}
 
 	response, err := w.client.
@@ -68,13 +84,17 @@ func (w *Wallet) jsonRPC(ctx context.Context, resp interface{}, method string, p
 		Post(fmt.Sprintf("%s/%s", w.wallet.URI, method))
 
 	if err != nil {
-		return err
+		return errors.Wrap(errors.ErrNodeUnavailable, err)
+	}
+
+	if response.IsError() {
+		return parseHTTPStatus(response.StatusCode(), errors.New("jsonRPC error: "+response.Status()))
 	}
 
 	result := response.Result().(*Result)
 
-	if result.Error != nil {
-		return errors.New("jsonRPC error: " + string(*result.Error))
+	if len(result.Error) > 0 {
+		return parseRPCError("", result.Error)
 	}
 
 	if err := json.Unmarshal(response.Body(), resp); err != nil {
@@ -140,6 +160,10 @@ func (w *Wallet) createTrxTransaction(ctx context.Context, tx *transaction.Trans
 	}
 
 	var resp *struct {
+		Result struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"result"`
 		Transaction struct {
 			TxID string `json:"txID"`
 		} `json:"transaction"`
@@ -153,6 +177,10 @@ func (w *Wallet) createTrxTransaction(ctx context.Context, tx *transaction.Trans
 		return nil, err
 	}
 
+	if len(resp.Result.Code) > 0 {
+		return nil, parseRPCError(resp.Result.Code, resp.Result.Message)
+	}
+
 	tx.Fee = decimal.NewNullDecimal(w.ConvertFromBaseUnit(fee))
 	tx.Status = transaction.StatusPending
 	tx.TxHash = null.StringFrom(resp.Transaction.TxID)
@@ -172,10 +200,16 @@ func (w *Wallet) createTrc20Transaction(ctx context.Context, tx *transaction.Tra
 	fee := w.ConvertToBaseUnit(decimal.NewFromInt(feeLimit))
 
 	resp := new(struct {
-		Result bool `json:"result"`
+		Result  bool   `json:"result"`
+		Code    string `json:"code"`
+		Message string `json:"message"`
 	})
-	if err := w.jsonRPC(ctx, &resp, "wallet/broadcasttransaction", signedTxn); err != nil || !resp.Result {
-		return nil, fmt.Errorf("failed to create trc20 transaction from %s to %s", w.wallet.Address, tx.ToAddress)
+	if err := w.jsonRPC(ctx, &resp, "wallet/broadcasttransaction", signedTxn); err != nil {
+		return nil, fmt.Errorf("failed to create trc20 transaction from %s to %s: %w", w.wallet.Address, tx.ToAddress, err)
+	}
+
+	if !resp.Result {
+		return nil, fmt.Errorf("failed to create trc20 transaction from %s to %s: %w", w.wallet.Address, tx.ToAddress, parseRPCError(resp.Code, resp.Message))
 	}
 
 	tx.Fee = decimal.NewNullDecimal(w.ConvertFromBaseUnit(fee))
@@ -1 +1,9 @@
+// Package chains import every chain implementation so they register themselves
+// into the blockchain and wallet registries
 package chains
+
+import (
+	_ "github.com/zsmartex/multichain/chains/bitcoin"
+	_ "github.com/zsmartex/multichain/chains/evm"
+	_ "github.com/zsmartex/multichain/chains/tron"
+)
@@ -0,0 +1,85 @@
+// Package rpctest provide a fake JSON-RPC node for the tests of the chain implementations
+package rpctest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// Handler compute the result of a request from its params, it can return an *Error
+type Handler = func(params []json.RawMessage) interface{}
+
+// Error is answered as the error of a request instead of its result
+type Error struct {
+	Code    int
+	Message string
+}
+
+type request struct {
+	ID     json.RawMessage   `json:"id"`
+	Method string            `json:"method"`
+	Params []json.RawMessage `json:"params"`
+}
+
+// NewServer answer each method with its result, a result can be a Handler or an *Error,
+// unknown methods are answered with a method not found error and batch requests are answered in order
+func NewServer(t testing.TB, results map[string]interface{}) *httptest.Server {
+	answer := func(req request) map[string]interface{} {
+		resp := map[string]interface{}{"jsonrpc": "2.0", "id": req.ID}
+
+		result, ok := results[req.Method]
+		if !ok {
+			result = &Error{Code: -32601, Message: "the method " + req.Method + " does not exist/is not available"}
+		}
+
+		if fn, ok := result.(Handler); ok {
+			result = fn(req.Params)
+		}
+
+		if rpcErr, ok := result.(*Error); ok {
+			resp["error"] = map[string]interface{}{"code": rpcErr.Code, "message": rpcErr.Message}
+		} else {
+			resp["result"] = result
+		}
+
+		return resp
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		var body json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			// t.Fatal can't be called outside of the test goroutine
+			t.Error(err)
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if len(body) > 0 && body[0] == '[' {
+			var reqs []request
+			if err := json.Unmarshal(body, &reqs); err != nil {
+				t.Error(err)
+				http.Error(rw, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			resps := make([]map[string]interface{}, len(reqs))
+			for i, req := range reqs {
+				resps[i] = answer(req)
+			}
+
+			json.NewEncoder(rw).Encode(resps)
+			return
+		}
+
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			t.Error(err)
+			http.Error(rw, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		json.NewEncoder(rw).Encode(answer(req))
+	}))
+}
@@ -1,11 +1,15 @@
 package block
 
 import (
+	"time"
+
 	"github.com/zsmartex/multichain/pkg/transaction"
 )
 
 type Block struct {
 	Hash         string
+	ParentHash   string
 	Number       int64
+	Timestamp    time.Time
 	Transactions []*transaction.Transaction
 }
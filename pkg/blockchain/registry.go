@@ -0,0 +1,73 @@
+package blockchain
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Factory create a new unconfigured Blockchain
+type Factory func() Blockchain
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// UnknownChainError returned by New when no Blockchain registered under the kind
+type UnknownChainError struct {
+	Kind string
+}
+
+func (e *UnknownChainError) Error() string {
+	return fmt.Sprintf("blockchain: unknown chain %q", e.Kind)
+}
+
+// Register make a Blockchain implementation available under the kind (e.g. "evm", "bitcoin", "tron")
+// it panics if the kind is registered twice or factory is nil
+func Register(kind string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("blockchain: Register factory is nil")
+	}
+
+	if _, dup := factories[kind]; dup {
+		panic("blockchain: Register called twice for kind " + kind)
+	}
+
+	factories[kind] = factory
+}
+
+// Kinds return a sorted list of the registered kinds
+func Kinds() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	kinds := make([]string, 0, len(factories))
+	for kind := range factories {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	return kinds
+}
+
+// New create a Blockchain registered under the kind and configure it with setting
+func New(kind string, setting *Setting) (Blockchain, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[kind]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, &UnknownChainError{Kind: kind}
+	}
+
+	bl := factory()
+	if err := bl.Configure(setting); err != nil {
+		return nil, err
+	}
+
+	return bl, nil
+}
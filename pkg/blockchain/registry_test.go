@@ -0,0 +1,74 @@
+package blockchain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/zsmartex/multichain/pkg/block"
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/transaction"
+)
+
+type stubBlockchain struct {
+	setting *Setting
+	blocks  map[int64]*block.Block
+}
+
+func (s *stubBlockchain) Configure(setting *Setting) error {
+	s.setting = setting
+
+	return nil
+}
+
+func (s *stubBlockchain) GetLatestBlockNumber(context.Context) (int64, error) {
+	return 0, nil
+}
+
+func (s *stubBlockchain) GetBlockByHash(context.Context, string) (*block.Block, error) {
+	return nil, nil
+}
+
+func (s *stubBlockchain) GetBlockByNumber(_ context.Context, blockNumber int64) (*block.Block, error) {
+	blk, ok := s.blocks[blockNumber]
+	if !ok {
+		return nil, errors.ErrBlockNotFound
+	}
+
+	return blk, nil
+}
+
+func (s *stubBlockchain) GetTransaction(context.Context, string) (*transaction.Transaction, error) {
+	return nil, nil
+}
+
+func (s *stubBlockchain) Confirmations(context.Context, string) (int64, error) {
+	return 0, nil
+}
+
+func (s *stubBlockchain) GetBalanceOfAddress(context.Context, string, string) (decimal.Decimal, error) {
+	return decimal.Zero, nil
+}
+
+func TestRegistry_New(t *testing.T) {
+	Register("stub", func() Blockchain { return &stubBlockchain{} })
+
+	setting := &Setting{URI: "http://localhost:8545"}
+
+	bl, err := New("stub", setting)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bl.(*stubBlockchain).setting != setting {
+		t.Error("expected blockchain to be configured with setting")
+	}
+
+	_, err = New("unknown", setting)
+
+	var unknownErr *UnknownChainError
+	if !errors.As(err, &unknownErr) || unknownErr.Kind != "unknown" {
+		t.Errorf("expected UnknownChainError, got %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package blockchain
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/zsmartex/multichain/pkg/block"
+	"github.com/zsmartex/multichain/pkg/errors"
+)
+
+// ErrReorgTooDeep returned by ReorgDetector.Detect when every tracked block was orphaned
+var ErrReorgTooDeep = errors.New("reorg deeper than tracked blocks")
+
+// ReorgDetector keep the hashes of the last processed blocks
+// to find the heights orphaned by a chain reorganization
+type ReorgDetector struct {
+	depth int
+
+	mu      sync.Mutex
+	heights []int64 // ascending
+	hashes  map[int64]string
+}
+
+// NewReorgDetector create a detector tracking the last depth processed blocks
+func NewReorgDetector(depth int) *ReorgDetector {
+	if depth < 1 {
+		depth = 1
+	}
+
+	return &ReorgDetector{
+		depth:   depth,
+		heights: make([]int64, 0, depth),
+		hashes:  make(map[int64]string, depth),
+	}
+}
+
+// Push record a processed block hash, blocks older than depth are forgotten
+func (d *ReorgDetector) Push(number int64, hash string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if _, ok := d.hashes[number]; !ok {
+		d.heights = append(d.heights, number)
+		sort.Slice(d.heights, func(i, j int) bool { return d.heights[i] < d.heights[j] })
+	}
+
+	d.hashes[number] = hash
+
+	for len(d.heights) > d.depth {
+		delete(d.hashes, d.heights[0])
+		d.heights = d.heights[1:]
+	}
+}
+
+// Latest return the last processed block number and hash, ok is false when nothing was processed
+func (d *ReorgDetector) Latest() (number int64, hash string, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if len(d.heights) == 0 {
+		return 0, "", false
+	}
+
+	number = d.heights[len(d.heights)-1]
+
+	return number, d.hashes[number], true
+}
+
+// Blocks return a copy of the tracked block hashes by number, pushing them back restore the detector
+func (d *ReorgDetector) Blocks() map[int64]string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	blocks := make(map[int64]string, len(d.hashes))
+	for number, hash := range d.hashes {
+		blocks[number] = hash
+	}
+
+	return blocks
+}
+
+// Connects report whether blk extend the last processed block, it is always true when blk is not the next height
+func (d *ReorgDetector) Connects(blk *block.Block) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	parentHash, ok := d.hashes[blk.Number-1]
+	if !ok || len(blk.ParentHash) == 0 {
+		return true
+	}
+
+	return parentHash == blk.ParentHash
+}
+
+// Detect compare the processed blocks with the canonical chain of bl, it returns the orphaned heights
+// in ascending order and forget them so they can be rolled back and processed again
+func (d *ReorgDetector) Detect(ctx context.Context, bl Blockchain) ([]int64, error) {
+	d.mu.Lock()
+	heights := make([]int64, len(d.heights))
+	copy(heights, d.heights)
+	hashes := make(map[int64]string, len(d.hashes))
+	for number, hash := range d.hashes {
+		hashes[number] = hash
+	}
+	d.mu.Unlock()
+
+	orphaned := make([]int64, 0)
+	for i := len(heights) - 1; i >= 0; i-- {
+		number := heights[i]
+
+		canonical, err := bl.GetBlockByNumber(ctx, number)
+		if errors.Is(err, errors.ErrBlockNotFound) {
+			orphaned = append(orphaned, number)
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if canonical.Hash == hashes[number] {
+			break
+		}
+
+		orphaned = append(orphaned, number)
+	}
+
+	sort.Slice(orphaned, func(i, j int) bool { return orphaned[i] < orphaned[j] })
+
+	d.forget(orphaned)
+
+	if len(heights) > 0 && len(orphaned) == len(heights) {
+		return orphaned, ErrReorgTooDeep
+	}
+
+	return orphaned, nil
+}
+
+func (d *ReorgDetector) forget(numbers []int64) {
+	if len(numbers) == 0 {
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, number := range numbers {
+		delete(d.hashes, number)
+	}
+
+	heights := make([]int64, 0, len(d.hashes))
+	for _, number := range d.heights {
+		if _, ok := d.hashes[number]; ok {
+			heights = append(heights, number)
+		}
+	}
+
+	d.heights = heights
+}
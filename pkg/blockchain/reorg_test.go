@@ -0,0 +1,68 @@
+package blockchain
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/zsmartex/multichain/pkg/block"
+	"github.com/zsmartex/multichain/pkg/errors"
+)
+
+func TestReorgDetector_Detect(t *testing.T) {
+	detector := NewReorgDetector(4)
+	for number, hash := range []string{"a0", "a1", "a2", "a3", "a4", "a5"} {
+		detector.Push(int64(number), hash)
+	}
+
+	bl := &stubBlockchain{
+		blocks: map[int64]*block.Block{
+			2: {Number: 2, Hash: "a2"},
+			3: {Number: 3, Hash: "a3"},
+			4: {Number: 4, Hash: "b4", ParentHash: "a3"},
+			5: {Number: 5, Hash: "b5", ParentHash: "b4"},
+		},
+	}
+
+	if !detector.Connects(&block.Block{Number: 6, ParentHash: "a5"}) {
+		t.Error("expected block to connect to the last processed block")
+	}
+
+	if detector.Connects(bl.blocks[5]) {
+		t.Error("expected block of another fork not to connect")
+	}
+
+	orphaned, err := detector.Detect(context.Background(), bl)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(orphaned, []int64{4, 5}) {
+		t.Errorf("unexpected orphaned heights %v", orphaned)
+	}
+
+	if number, hash, _ := detector.Latest(); number != 3 || hash != "a3" {
+		t.Errorf("expected orphaned blocks to be forgotten, latest is %d %s", number, hash)
+	}
+}
+
+func TestReorgDetector_DetectTooDeep(t *testing.T) {
+	detector := NewReorgDetector(2)
+	detector.Push(1, "a1")
+	detector.Push(2, "a2")
+
+	bl := &stubBlockchain{
+		blocks: map[int64]*block.Block{
+			1: {Number: 1, Hash: "b1"},
+		},
+	}
+
+	orphaned, err := detector.Detect(context.Background(), bl)
+	if !errors.Is(err, ErrReorgTooDeep) {
+		t.Errorf("expected reorg too deep error, got %v", err)
+	}
+
+	if !reflect.DeepEqual(orphaned, []int64{1, 2}) {
+		t.Errorf("unexpected orphaned heights %v", orphaned)
+	}
+}
@@ -7,20 +7,81 @@ import (
 
 	"github.com/zsmartex/multichain/pkg/block"
 	"github.com/zsmartex/multichain/pkg/currency"
+	"github.com/zsmartex/multichain/pkg/errors"
 	"github.com/zsmartex/multichain/pkg/transaction"
 )
 
 type Setting struct {
 	Currencies           []*currency.Currency
 	WhitelistedAddresses []string
-	URI                  string
+	URI                  string // json-rpc adapters accept a comma separated list of endpoints for failover, evm sends its requests to the http ones and subscribes through the websocket ones
+}
+
+// Validate check the fields shared by every chain, chain specific rules are checked by Configure
+func (s *Setting) Validate() error {
+	if s == nil {
+		return errors.NewConfigError("setting", "is nil")
+	}
+
+	if len(s.URI) == 0 {
+		return errors.NewConfigError("uri", "is empty")
+	}
+
+	if len(s.Currencies) == 0 {
+		return errors.NewConfigError("currencies", "is empty")
+	}
+
+	for _, c := range s.Currencies {
+		if err := c.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ApplyConfirmations set confirmations on transactions and keep them pending
+// until they reach the min_confirmations of their currency
+func (s *Setting) ApplyConfirmations(confirmations int64, transactions ...*transaction.Transaction) {
+	for _, t := range transactions {
+		var required int64
+		for _, c := range s.Currencies {
+			if c.ID == t.Currency {
+				required = c.MinConfirmations()
+				break
+			}
+		}
+
+		t.SetConfirmations(confirmations, required)
+	}
 }
 
 type Blockchain interface {
-	Configure(setting *Setting)
+	// Configure Validate setting and configure the blockchain with it
+	Configure(setting *Setting) error
 	GetLatestBlockNumber(ctx context.Context) (int64, error)
 	GetBlockByHash(ctx context.Context, hash string) (*block.Block, error)
 	GetBlockByNumber(ctx context.Context, blockNumber int64) (*block.Block, error)
 	GetTransaction(ctx context.Context, transactionHash string) (*transaction.Transaction, error)
+	// Confirmations return the number of blocks on top of the transaction block including it, 0 when it is still in mempool
+	Confirmations(ctx context.Context, transactionHash string) (int64, error)
 	GetBalanceOfAddress(ctx context.Context, address string, currencyID string) (decimal.Decimal, error)
 }
+
+// SubscriptionHandler receive what a Subscriber pushed, returning an error stop the subscription
+type SubscriptionHandler interface {
+	// HandleBlock called in order with every new block built like GetBlockByHash
+	HandleBlock(ctx context.Context, blk *block.Block) error
+	// HandleTransfers called with token transfers as soon as they are mined, before the block holding them is handled,
+	// pending transfers are called again once they reach the min_confirmations of their currency
+	HandleTransfers(ctx context.Context, transactions []*transaction.Transaction) error
+	// HandleRollback called with the orphaned heights which must be rolled back, their new blocks are handled next
+	HandleRollback(ctx context.Context, heights []int64) error
+}
+
+// Subscriber is implemented by blockchains which can push new blocks instead of being polled
+type Subscriber interface {
+	// Subscribe push new blocks to handler until ctx is done, a dropped connection is reconnected
+	// and the blocks missed since the last handled block are backfilled, reorganizations are rolled back
+	Subscribe(ctx context.Context, handler SubscriptionHandler) error
+}
@@ -0,0 +1,42 @@
+package blockchain
+
+import (
+	"testing"
+
+	"github.com/zsmartex/multichain/pkg/currency"
+	"github.com/zsmartex/multichain/pkg/transaction"
+)
+
+func TestSetting_ApplyConfirmations(t *testing.T) {
+	setting := &Setting{
+		Currencies: []*currency.Currency{
+			{ID: "ETH", Subunits: 18, Options: map[string]interface{}{"min_confirmations": 12}},
+			{ID: "USDT", Subunits: 6},
+		},
+	}
+
+	eth := &transaction.Transaction{Currency: "ETH", Status: transaction.StatusSucceed}
+	usdt := &transaction.Transaction{Currency: "USDT", Status: transaction.StatusSucceed}
+	failed := &transaction.Transaction{Currency: "ETH", Status: transaction.StatusFailed}
+
+	setting.ApplyConfirmations(3, eth, usdt, failed)
+
+	if !eth.IsPending() || eth.Confirmations != 3 {
+		t.Errorf("expected ETH transaction to be pending, got %s", eth.Status)
+	}
+
+	if !usdt.IsSuccess() {
+		t.Errorf("expected USDT transaction to succeed, got %s", usdt.Status)
+	}
+
+	if !failed.IsFailed() {
+		t.Errorf("expected failed transaction to stay failed, got %s", failed.Status)
+	}
+
+	mempool := &transaction.Transaction{Currency: "USDT", Status: transaction.StatusSucceed}
+	setting.ApplyConfirmations(0, mempool)
+
+	if !mempool.IsPending() {
+		t.Errorf("expected unconfirmed transaction to be pending, got %s", mempool.Status)
+	}
+}
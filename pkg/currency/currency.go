@@ -1,7 +1,51 @@
 package currency
 
+import (
+	"github.com/zsmartex/multichain/pkg/errors"
+)
+
+// MaxSubunits the max subunits a currency can have to keep its base unit in int64
+const MaxSubunits = 18
+
 type Currency struct {
 	ID       string
 	Subunits int32 // 8 -> 18
 	Options  map[string]interface{}
 }
+
+// MinConfirmations return the min_confirmations option, the confirmations a transaction need to be considered final
+func (c *Currency) MinConfirmations() int64 {
+	switch v := c.Options["min_confirmations"].(type) {
+	case int:
+		return int64(v)
+	case int32:
+		return int64(v)
+	case int64:
+		return v
+	case float64:
+		return int64(v)
+	default:
+		return 0
+	}
+}
+
+// Validate check that currency has an ID and sane Subunits
+func (c *Currency) Validate() error {
+	if c == nil {
+		return errors.NewConfigError("currency", "is nil")
+	}
+
+	if len(c.ID) == 0 {
+		return errors.NewConfigError("currency.id", "is empty")
+	}
+
+	if c.Subunits < 0 || c.Subunits > MaxSubunits {
+		return errors.NewConfigError("currency.subunits", "%d of %s must be between 0 and %d", c.Subunits, c.ID, MaxSubunits)
+	}
+
+	if c.MinConfirmations() < 0 {
+		return errors.NewConfigError("currency.options.min_confirmations", "%d of %s must be positive", c.MinConfirmations(), c.ID)
+	}
+
+	return nil
+}
@@ -0,0 +1,108 @@
+// Package errors hold the errors shared by every chain implementation,
+// it also re-export the standard library helpers so it can replace the "errors" import
+package errors
+
+import (
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrInvalidConfig     = errors.New("invalid config")
+	ErrTxNotFound        = errors.New("transaction not found")
+	ErrBlockNotFound     = errors.New("block not found")
+	ErrInsufficientFunds = errors.New("insufficient funds")
+	ErrNonceConflict     = errors.New("nonce conflict")
+	ErrNodeUnavailable   = errors.New("node unavailable")
+	ErrInvalidAddress    = errors.New("invalid address")
+	ErrRateLimited       = errors.New("rate limited")
+	ErrAlreadyBroadcast  = errors.New("transaction already broadcast")
+)
+
+// RPCError hold an error payload returned by a node,
+// Err is the shared error it was mapped onto by the chain implementation or nil when it is unknown
+type RPCError struct {
+	Code    int
+	Message string
+	Err     error
+}
+
+func (e *RPCError) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("jsonRPC error: %d %s", e.Code, e.Message)
+	}
+
+	return "jsonRPC error: " + e.Message
+}
+
+func (e *RPCError) Unwrap() error {
+	return e.Err
+}
+
+// Wrap annotate err with the shared error kind so errors.Is(err, kind) report true
+func Wrap(kind error, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	if errors.Is(err, kind) {
+		return err
+	}
+
+	return &wrapError{kind: kind, err: err}
+}
+
+type wrapError struct {
+	kind error
+	err  error
+}
+
+func (e *wrapError) Error() string {
+	return fmt.Sprintf("%s: %s", e.kind, e.err)
+}
+
+func (e *wrapError) Is(target error) bool {
+	return target == e.kind
+}
+
+func (e *wrapError) Unwrap() error {
+	return e.err
+}
+
+// ConfigError describe an invalid field of a blockchain or wallet setting
+type ConfigError struct {
+	Field  string
+	Reason string
+}
+
+func (e *ConfigError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", ErrInvalidConfig, e.Field, e.Reason)
+}
+
+func (e *ConfigError) Is(target error) bool {
+	return target == ErrInvalidConfig
+}
+
+// NewConfigError create a ConfigError for field with a formatted reason
+func NewConfigError(field string, format string, args ...interface{}) error {
+	return &ConfigError{
+		Field:  field,
+		Reason: fmt.Sprintf(format, args...),
+	}
+}
+
+func New(text string) error {
+	return errors.New(text)
+}
+
+func Is(err, target error) bool {
+	return errors.Is(err, target)
+}
+
+func As(err error, target interface{}) bool {
+	return errors.As(err, target)
+}
+
+func Unwrap(err error) error {
+	return errors.Unwrap(err)
+}
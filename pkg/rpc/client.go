@@ -0,0 +1,478 @@
+// Package rpc implement a JSON-RPC 2.0 client shared by the chain implementations,
+// it supports batch requests, retries with exponential backoff and failover between several endpoints
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+
+	"github.com/zsmartex/multichain/pkg/errors"
+)
+
+const (
+	DefaultRetries    = 3
+	DefaultMinBackoff = 200 * time.Millisecond
+	DefaultMaxBackoff = 5 * time.Second
+	DefaultTimeout    = 30 * time.Second
+	DefaultCooldown   = 30 * time.Second
+	// DefaultHealthInterval the min wait time between two health checks enabled by WithHealthCheck
+	DefaultHealthInterval = 10 * time.Second
+)
+
+// ErrNullResult returned by Call when the node answer a null result
+var ErrNullResult = errors.New("jsonRPC error: result is nil")
+
+// ErrorParser map the error payload of a node onto the shared errors
+type ErrorParser func(code int, message string) error
+
+type Option func(c *Client)
+
+// WithRetries set how many times a request is retried on transient errors
+func WithRetries(retries int) Option {
+	return func(c *Client) {
+		c.retries = retries
+	}
+}
+
+// WithBackoff set the backoff between retries, it doubles after each retry up to max
+func WithBackoff(min, max time.Duration) Option {
+	return func(c *Client) {
+		c.minBackoff = min
+		c.maxBackoff = max
+	}
+}
+
+// WithTimeout set the timeout of a single attempt
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		c.timeout = timeout
+	}
+}
+
+// WithCooldown set how long a failed endpoint is skipped before being tried again
+func WithCooldown(cooldown time.Duration) Option {
+	return func(c *Client) {
+		c.cooldown = cooldown
+	}
+}
+
+// WithErrorParser set the parser used to map node errors onto the shared errors
+func WithErrorParser(parser ErrorParser) Option {
+	return func(c *Client) {
+		c.parseError = parser
+	}
+}
+
+// WithHealthCheck probe the endpoints with method at most once per interval while the client is used,
+// a failed endpoint is skipped before a request hits it and a recovered one is used again before its cooldown ends
+func WithHealthCheck(interval time.Duration, method string, params ...interface{}) Option {
+	return func(c *Client) {
+		c.healthInterval = interval
+		c.healthMethod = method
+		c.healthParams = params
+	}
+}
+
+// BatchElem is a request in a batch, Result and Error are filled once the batch is done
+type BatchElem struct {
+	Method string
+	Params []interface{}
+	Result interface{}
+	Error  error
+}
+
+type request struct {
+	JSONRPC string        `json:"jsonrpc"`
+	ID      uint64        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      uint64          `json:"id"`
+	Result  json.RawMessage `json:"result"`
+	Error   *rpcError       `json:"error"`
+}
+
+type endpoint struct {
+	uri string
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+func (e *endpoint) markUp() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.downUntil = time.Time{}
+}
+
+func (e *endpoint) markDown(cooldown time.Duration) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.downUntil = time.Now().Add(cooldown)
+}
+
+type Client struct {
+	http       *resty.Client
+	endpoints  []*endpoint
+	retries    int
+	minBackoff time.Duration
+	maxBackoff time.Duration
+	timeout    time.Duration
+	cooldown   time.Duration
+	parseError ErrorParser
+	lastID     uint64
+
+	healthInterval time.Duration
+	healthMethod   string
+	healthParams   []interface{}
+	// lastHealthCheck the unix nano time of the last health check
+	lastHealthCheck int64
+}
+
+// NewClient create a client for uri, uri can be a comma separated list of endpoints
+// the first endpoint is preferred and the next ones are used as failover
+func NewClient(uri string, opts ...Option) *Client {
+	c := &Client{
+		http:       resty.New(),
+		endpoints:  make([]*endpoint, 0),
+		retries:    DefaultRetries,
+		minBackoff: DefaultMinBackoff,
+		maxBackoff: DefaultMaxBackoff,
+		timeout:    DefaultTimeout,
+		cooldown:   DefaultCooldown,
+		parseError: func(code int, message string) error {
+			return &errors.RPCError{Code: code, Message: message}
+		},
+	}
+
+	for _, u := range SplitURI(uri) {
+		c.endpoints = append(c.endpoints, &endpoint{uri: u})
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
+}
+
+// SplitURI split a comma separated list of endpoints
+func SplitURI(uri string) []string {
+	uris := make([]string, 0)
+	for _, u := range strings.Split(uri, ",") {
+		u = strings.TrimSpace(u)
+		if len(u) > 0 {
+			uris = append(uris, u)
+		}
+	}
+
+	return uris
+}
+
+// Call send a single request and unmarshal its result into result
+func (c *Client) Call(ctx context.Context, result interface{}, method string, params ...interface{}) error {
+	return c.withRetry(ctx, c.callFunc(c.newRequest(method, params), result))
+}
+
+// CallOnce send a single request without retry nor failover, it must be used for the requests which
+// can't be safely sent twice like broadcasts, the node may have accepted the request when an error is returned
+func (c *Client) CallOnce(ctx context.Context, result interface{}, method string, params ...interface{}) error {
+	return c.once(ctx, c.callFunc(c.newRequest(method, params), result))
+}
+
+func (c *Client) callFunc(req *request, result interface{}) func(ctx context.Context, e *endpoint) error {
+	return func(ctx context.Context, e *endpoint) error {
+		var resp *response
+		if err := c.post(ctx, e, req, &resp); err != nil {
+			return err
+		}
+
+		if resp == nil || resp.ID != req.ID {
+			return fmt.Errorf("jsonRPC error: unexpected response id for request %d", req.ID)
+		}
+
+		return c.decode(resp, result)
+	}
+}
+
+// BatchCall send all elements in a single batch request, the error of each element is set on BatchElem.Error
+// the returned error is only set when the whole batch failed
+func (c *Client) BatchCall(ctx context.Context, batch []BatchElem) error {
+	if len(batch) == 0 {
+		return nil
+	}
+
+	reqs := make([]*request, len(batch))
+	for i, elem := range batch {
+		reqs[i] = c.newRequest(elem.Method, elem.Params)
+	}
+
+	return c.withRetry(ctx, func(ctx context.Context, e *endpoint) error {
+		var resps []*response
+		if err := c.post(ctx, e, reqs, &resps); err != nil {
+			return err
+		}
+
+		byID := make(map[uint64]*response, len(resps))
+		for _, resp := range resps {
+			if resp != nil {
+				byID[resp.ID] = resp
+			}
+		}
+
+		for i, req := range reqs {
+			resp, ok := byID[req.ID]
+			if !ok {
+				batch[i].Error = fmt.Errorf("jsonRPC error: missing response for request %d", req.ID)
+				continue
+			}
+
+			batch[i].Error = c.decode(resp, batch[i].Result)
+		}
+
+		return nil
+	})
+}
+
+// CheckHealth probe every endpoint with method and update their state,
+// it returns an error when no endpoint is healthy
+func (c *Client) CheckHealth(ctx context.Context, method string, params ...interface{}) error {
+	var lastErr error
+	healthy := 0
+	for _, e := range c.endpoints {
+		var result json.RawMessage
+		req := c.newRequest(method, params)
+
+		attemptCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		var resp *response
+		err := c.post(attemptCtx, e, req, &resp)
+		if err == nil {
+			err = c.decode(resp, &result)
+		}
+		cancel()
+
+		if isTransient(err) {
+			e.markDown(c.cooldown)
+			lastErr = err
+			continue
+		}
+
+		e.markUp()
+		healthy++
+	}
+
+	if healthy == 0 {
+		return lastErr
+	}
+
+	return nil
+}
+
+// checkHealth start a health check in background when one is due, a single endpoint has nothing to fail over to
+func (c *Client) checkHealth() {
+	if len(c.healthMethod) == 0 || len(c.endpoints) < 2 {
+		return
+	}
+
+	now := time.Now().UnixNano()
+	last := atomic.LoadInt64(&c.lastHealthCheck)
+	if now-last < int64(c.healthInterval) || !atomic.CompareAndSwapInt64(&c.lastHealthCheck, last, now) {
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), c.timeout*time.Duration(len(c.endpoints)))
+		defer cancel()
+
+		c.CheckHealth(ctx, c.healthMethod, c.healthParams...)
+	}()
+}
+
+func (c *Client) newRequest(method string, params []interface{}) *request {
+	if params == nil {
+		params = make([]interface{}, 0)
+	}
+
+	return &request{
+		JSONRPC: "2.0",
+		ID:      atomic.AddUint64(&c.lastID, 1),
+		Method:  method,
+		Params:  params,
+	}
+}
+
+func (c *Client) decode(resp *response, result interface{}) error {
+	if resp.Error != nil {
+		return c.parseError(resp.Error.Code, resp.Error.Message)
+	}
+
+	if len(resp.Result) == 0 || string(resp.Result) == "null" {
+		return ErrNullResult
+	}
+
+	if result == nil {
+		return nil
+	}
+
+	return json.Unmarshal(resp.Result, result)
+}
+
+func (c *Client) post(ctx context.Context, e *endpoint, body interface{}, out interface{}) error {
+	response, err := c.http.
+		R().
+		SetContext(ctx).
+		SetHeaders(map[string]string{
+			"Accept":       "application/json",
+			"Content-Type": "application/json",
+		}).
+		SetBody(body).
+		Post(e.uri)
+
+	if err != nil {
+		return errors.Wrap(errors.ErrNodeUnavailable, err)
+	}
+
+	statusErr := parseHTTPStatus(response.StatusCode(), errors.New("jsonRPC error: "+response.Status()))
+	if response.StatusCode() == http.StatusTooManyRequests {
+		return statusErr
+	}
+
+	// some nodes (e.g. bitcoind) answer errors with a failed status and a json-rpc error payload
+	if err := json.Unmarshal(response.Body(), out); err != nil {
+		if response.IsError() {
+			return statusErr
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+// withRetry run fn against the available endpoints until it succeed or fail with a non transient error
+func (c *Client) withRetry(ctx context.Context, fn func(ctx context.Context, e *endpoint) error) error {
+	if len(c.endpoints) == 0 {
+		return errors.Wrap(errors.ErrNodeUnavailable, errors.New("jsonRPC error: no endpoint configured"))
+	}
+
+	c.checkHealth()
+
+	var err error
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(c.backoff(attempt)):
+			}
+		}
+
+		e := c.pick()
+
+		attemptCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		err = fn(attemptCtx, e)
+		cancel()
+
+		if !isTransient(err) {
+			e.markUp()
+			return err
+		}
+
+		e.markDown(c.cooldown)
+
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+
+	return err
+}
+
+// once run fn a single time against the first available endpoint
+func (c *Client) once(ctx context.Context, fn func(ctx context.Context, e *endpoint) error) error {
+	if len(c.endpoints) == 0 {
+		return errors.Wrap(errors.ErrNodeUnavailable, errors.New("jsonRPC error: no endpoint configured"))
+	}
+
+	c.checkHealth()
+
+	e := c.pick()
+
+	attemptCtx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	err := fn(attemptCtx, e)
+	if isTransient(err) {
+		e.markDown(c.cooldown)
+	} else {
+		e.markUp()
+	}
+
+	return err
+}
+
+// pick return the first available endpoint or the one which will be available first
+func (c *Client) pick() *endpoint {
+	now := time.Now()
+
+	var next *endpoint
+	var nextUntil time.Time
+	for _, e := range c.endpoints {
+		e.mu.Lock()
+		downUntil := e.downUntil
+		e.mu.Unlock()
+
+		if !now.Before(downUntil) {
+			return e
+		}
+
+		if next == nil || downUntil.Before(nextUntil) {
+			next = e
+			nextUntil = downUntil
+		}
+	}
+
+	return next
+}
+
+func (c *Client) backoff(attempt int) time.Duration {
+	backoff := c.minBackoff << uint(attempt-1)
+	if backoff <= 0 || backoff > c.maxBackoff {
+		return c.maxBackoff
+	}
+
+	return backoff
+}
+
+func isTransient(err error) bool {
+	return errors.Is(err, errors.ErrNodeUnavailable) || errors.Is(err, errors.ErrRateLimited)
+}
+
+func parseHTTPStatus(statusCode int, err error) error {
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return errors.Wrap(errors.ErrRateLimited, err)
+	case statusCode >= http.StatusInternalServerError:
+		return errors.Wrap(errors.ErrNodeUnavailable, err)
+	default:
+		return err
+	}
+}
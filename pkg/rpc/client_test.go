@@ -0,0 +1,284 @@
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zsmartex/multichain/pkg/errors"
+)
+
+func newServer(t *testing.T, handler func(req map[string]interface{}) (interface{}, *rpcError)) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body json.RawMessage
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			// t.Fatal can't be called outside of the test goroutine
+			t.Error(err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		reply := func(req map[string]interface{}) map[string]interface{} {
+			result, rpcErr := handler(req)
+			resp := map[string]interface{}{"jsonrpc": "2.0", "id": req["id"], "result": result}
+			if rpcErr != nil {
+				resp["error"] = rpcErr
+			}
+
+			return resp
+		}
+
+		if body[0] == '[' {
+			var reqs []map[string]interface{}
+			json.Unmarshal(body, &reqs)
+
+			resps := make([]map[string]interface{}, 0)
+			// answer in reverse order to check id correlation
+			for i := len(reqs) - 1; i >= 0; i-- {
+				resps = append(resps, reply(reqs[i]))
+			}
+
+			json.NewEncoder(w).Encode(resps)
+			return
+		}
+
+		var req map[string]interface{}
+		json.Unmarshal(body, &req)
+		json.NewEncoder(w).Encode(reply(req))
+	}))
+}
+
+func TestClient_Call(t *testing.T) {
+	server := newServer(t, func(req map[string]interface{}) (interface{}, *rpcError) {
+		if req["jsonrpc"] != "2.0" {
+			t.Errorf("expected jsonrpc 2.0, got %v", req["jsonrpc"])
+		}
+
+		return req["method"], nil
+	})
+	defer server.Close()
+
+	var result string
+	if err := NewClient(server.URL).Call(context.Background(), &result, "getblockcount"); err != nil {
+		t.Fatal(err)
+	}
+
+	if result != "getblockcount" {
+		t.Errorf("unexpected result %s", result)
+	}
+}
+
+func TestClient_BatchCall(t *testing.T) {
+	server := newServer(t, func(req map[string]interface{}) (interface{}, *rpcError) {
+		if req["method"] == "fail" {
+			return nil, &rpcError{Code: -5, Message: "failed"}
+		}
+
+		return req["params"].([]interface{})[0], nil
+	})
+	defer server.Close()
+
+	batch := []BatchElem{
+		{Method: "echo", Params: []interface{}{"a"}, Result: new(string)},
+		{Method: "fail", Result: new(string)},
+		{Method: "echo", Params: []interface{}{"c"}, Result: new(string)},
+	}
+
+	if err := NewClient(server.URL).BatchCall(context.Background(), batch); err != nil {
+		t.Fatal(err)
+	}
+
+	if *batch[0].Result.(*string) != "a" || *batch[2].Result.(*string) != "c" {
+		t.Errorf("unexpected batch results %s %s", *batch[0].Result.(*string), *batch[2].Result.(*string))
+	}
+
+	var rpcErr *errors.RPCError
+	if !errors.As(batch[1].Error, &rpcErr) || rpcErr.Code != -5 {
+		t.Errorf("expected rpc error, got %v", batch[1].Error)
+	}
+}
+
+func TestClient_Failover(t *testing.T) {
+	var downCalls int32
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&downCalls, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	up := newServer(t, func(req map[string]interface{}) (interface{}, *rpcError) {
+		return 1, nil
+	})
+	defer up.Close()
+
+	client := NewClient(down.URL+","+up.URL, WithBackoff(time.Millisecond, time.Millisecond))
+
+	for i := 0; i < 3; i++ {
+		var result int
+		if err := client.Call(context.Background(), &result, "getblockcount"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if downCalls != 1 {
+		t.Errorf("expected failed endpoint to be skipped during cooldown, got %d calls", downCalls)
+	}
+}
+
+func TestClient_RetryExhausted(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	client := NewClient(server.URL, WithRetries(2), WithBackoff(time.Millisecond, time.Millisecond))
+
+	var result int
+	if err := client.Call(context.Background(), &result, "getblockcount"); !errors.Is(err, errors.ErrRateLimited) {
+		t.Errorf("expected rate limited error, got %v", err)
+	}
+}
+
+func TestClient_CallOnce(t *testing.T) {
+	var calls int32
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+
+	var failoverCalls int32
+	up := newServer(t, func(req map[string]interface{}) (interface{}, *rpcError) {
+		atomic.AddInt32(&failoverCalls, 1)
+		return "txid", nil
+	})
+	defer up.Close()
+
+	client := NewClient(down.URL+","+up.URL, WithBackoff(time.Millisecond, time.Millisecond))
+
+	var result string
+	if err := client.CallOnce(context.Background(), &result, "sendrawtransaction", "raw"); !errors.Is(err, errors.ErrNodeUnavailable) {
+		t.Errorf("expected node unavailable error, got %v", err)
+	}
+
+	if calls != 1 || failoverCalls != 0 {
+		t.Errorf("expected a single attempt without failover, got %d and %d calls", calls, failoverCalls)
+	}
+}
+
+func TestClient_CheckHealth(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer down.Close()
+
+	var upCalls int32
+	up := newServer(t, func(req map[string]interface{}) (interface{}, *rpcError) {
+		atomic.AddInt32(&upCalls, 1)
+		return 1, nil
+	})
+	defer up.Close()
+
+	client := NewClient(down.URL+","+up.URL, WithRetries(0))
+	if err := client.CheckHealth(context.Background(), "getblockcount"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first endpoint was marked down by the health check so the request go to the second one
+	var result int
+	if err := client.Call(context.Background(), &result, "getblockcount"); err != nil {
+		t.Fatal(err)
+	}
+
+	if upCalls != 2 {
+		t.Errorf("expected the health check and the request to reach the healthy endpoint, got %d calls", upCalls)
+	}
+
+	if err := NewClient(down.URL).CheckHealth(context.Background(), "getblockcount"); !errors.Is(err, errors.ErrNodeUnavailable) {
+		t.Errorf("expected node unavailable error, got %v", err)
+	}
+}
+
+func TestClient_WithHealthCheck(t *testing.T) {
+	checked := make(chan string, 10)
+	first := newServer(t, func(req map[string]interface{}) (interface{}, *rpcError) {
+		checked <- "first " + req["method"].(string)
+		return 1, nil
+	})
+	defer first.Close()
+
+	second := newServer(t, func(req map[string]interface{}) (interface{}, *rpcError) {
+		checked <- "second " + req["method"].(string)
+		return 1, nil
+	})
+	defer second.Close()
+
+	client := NewClient(first.URL+","+second.URL, WithHealthCheck(time.Hour, "eth_blockNumber"))
+
+	var result int
+	if err := client.Call(context.Background(), &result, "eth_chainId"); err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	timeout := time.After(5 * time.Second)
+	for len(seen) < 3 {
+		select {
+		case call := <-checked:
+			seen[call] = true
+		case <-timeout:
+			t.Fatalf("expected every endpoint to be probed, got %v", seen)
+		}
+	}
+
+	if !seen["first eth_chainId"] || !seen["first eth_blockNumber"] || !seen["second eth_blockNumber"] {
+		t.Errorf("unexpected calls %v", seen)
+	}
+}
+
+func TestClient_RoundTripper(t *testing.T) {
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer down.Close()
+
+	up := newServer(t, func(req map[string]interface{}) (interface{}, *rpcError) {
+		return req["method"], nil
+	})
+	defer up.Close()
+
+	client := NewClient(down.URL+","+up.URL, WithBackoff(time.Millisecond, time.Millisecond))
+	httpClient := &http.Client{Transport: client.RoundTripper()}
+
+	resp, err := httpClient.Post("http://ignored", "application/json", strings.NewReader(`{"jsonrpc":"2.0","id":1,"method":"eth_chainId","params":[]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var body map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	if resp.StatusCode != http.StatusOK || body["result"] != "eth_chainId" {
+		t.Errorf("unexpected response %d %v", resp.StatusCode, body)
+	}
+
+	// the status of the last attempt is answered once the retries are exhausted
+	resp, err = (&http.Client{Transport: NewClient(down.URL, WithRetries(1), WithBackoff(time.Millisecond, time.Millisecond)).RoundTripper()}).
+		Post("http://ignored", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadGateway {
+		t.Errorf("expected bad gateway, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,77 @@
+package rpc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/zsmartex/multichain/pkg/errors"
+)
+
+// roundTripper send the requests of another json-rpc client through the retries and failover of client
+type roundTripper struct {
+	client *Client
+}
+
+// RoundTripper return a http.RoundTripper which send the body of each request to the endpoints of c with its
+// retries and failover, it lets clients like the one of go-ethereum use them, the url of the requests is ignored.
+// Requests which can't be safely sent twice like broadcasts must not go through it
+func (c *Client) RoundTripper() http.RoundTripper {
+	return &roundTripper{client: c}
+}
+
+func (t *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var last *http.Response
+	err := t.client.withRetry(req.Context(), func(ctx context.Context, e *endpoint) error {
+		response, err := t.client.http.
+			R().
+			SetContext(ctx).
+			SetHeaders(map[string]string{
+				"Accept":       "application/json",
+				"Content-Type": "application/json",
+			}).
+			SetBody(body).
+			Post(e.uri)
+		if err != nil {
+			return errors.Wrap(errors.ErrNodeUnavailable, err)
+		}
+
+		last = &http.Response{
+			Status:        response.Status(),
+			StatusCode:    response.StatusCode(),
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        response.Header(),
+			Body:          io.NopCloser(bytes.NewReader(response.Body())),
+			ContentLength: int64(len(response.Body())),
+			Request:       req,
+		}
+
+		// a failed status with a json-rpc payload is an answer of the node
+		if response.StatusCode() == http.StatusTooManyRequests || (response.IsError() && !json.Valid(response.Body())) {
+			return parseHTTPStatus(response.StatusCode(), errors.New("jsonRPC error: "+response.Status()))
+		}
+
+		return nil
+	})
+
+	// the last answer is returned once the retries are exhausted so the caller see its status
+	if last != nil {
+		return last, nil
+	}
+
+	return nil, err
+}
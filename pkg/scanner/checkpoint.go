@@ -0,0 +1,48 @@
+package scanner
+
+import (
+	"context"
+	"sync"
+)
+
+// Checkpoint is the progress of a Scanner
+type Checkpoint struct {
+	// Height the last processed height
+	Height int64
+	// Blocks the hashes of the last processed blocks by height, a reorganization which happened
+	// while the scanner was stopped is detected with them
+	Blocks map[int64]string
+}
+
+// CheckpointStore persist the Checkpoint of a Scanner
+type CheckpointStore interface {
+	// Load return the last saved checkpoint, ok is false when nothing was saved yet
+	Load(ctx context.Context) (checkpoint *Checkpoint, ok bool, err error)
+	Save(ctx context.Context, checkpoint *Checkpoint) error
+}
+
+// MemoryCheckpointStore keep the checkpoint in memory, it is lost on restart
+type MemoryCheckpointStore struct {
+	mu         sync.Mutex
+	checkpoint *Checkpoint
+}
+
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{}
+}
+
+func (s *MemoryCheckpointStore) Load(context.Context) (*Checkpoint, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.checkpoint, s.checkpoint != nil, nil
+}
+
+func (s *MemoryCheckpointStore) Save(_ context.Context, checkpoint *Checkpoint) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.checkpoint = checkpoint
+
+	return nil
+}
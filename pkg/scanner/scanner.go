@@ -0,0 +1,298 @@
+// Package scanner follow a blockchain block by block and stream the transactions touching watched addresses
+package scanner
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zsmartex/multichain/pkg/block"
+	"github.com/zsmartex/multichain/pkg/blockchain"
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/transaction"
+)
+
+const (
+	DefaultConcurrency  = 4
+	DefaultBatchSize    = 16
+	DefaultPollInterval = 5 * time.Second
+	DefaultReorgDepth   = 64
+)
+
+// Handler receive what the Scanner found, returning an error stop the Scanner
+type Handler interface {
+	// HandleTransactions called once per block with the transactions touching a watched address
+	HandleTransactions(ctx context.Context, blk *block.Block, transactions []*transaction.Transaction) error
+	// HandleRollback called with the orphaned heights which must be rolled back, they will be scanned again
+	HandleRollback(ctx context.Context, heights []int64) error
+}
+
+type Options struct {
+	// StartHeight the first height to scan when the checkpoint store is empty
+	StartHeight int64
+	// Confirmations blocks are scanned once they have at least this number of confirmations
+	Confirmations int64
+	// Concurrency the max number of blocks fetched at the same time
+	Concurrency int
+	// BatchSize the max number of blocks fetched per iteration
+	BatchSize int
+	// PollInterval wait time between iterations once the scanner reached the chain head
+	PollInterval time.Duration
+	// ReorgDepth the number of processed blocks kept to detect reorganizations
+	ReorgDepth int
+	// Checkpoint store of the last processed height and block hashes, default to a MemoryCheckpointStore
+	Checkpoint CheckpointStore
+}
+
+type Scanner struct {
+	blockchain blockchain.Blockchain
+	handler    Handler
+	options    Options
+	detector   *blockchain.ReorgDetector
+
+	mu        sync.RWMutex
+	addresses map[string]bool
+}
+
+// New create a scanner for bl, it watches the WhitelistedAddresses of setting,
+// when no address is watched every transaction is emitted
+func New(bl blockchain.Blockchain, setting *blockchain.Setting, handler Handler, options Options) *Scanner {
+	if options.Concurrency < 1 {
+		options.Concurrency = DefaultConcurrency
+	}
+
+	if options.BatchSize < 1 {
+		options.BatchSize = DefaultBatchSize
+	}
+
+	if options.PollInterval <= 0 {
+		options.PollInterval = DefaultPollInterval
+	}
+
+	if options.ReorgDepth < 1 {
+		options.ReorgDepth = DefaultReorgDepth
+	}
+
+	if options.Checkpoint == nil {
+		options.Checkpoint = NewMemoryCheckpointStore()
+	}
+
+	s := &Scanner{
+		blockchain: bl,
+		handler:    handler,
+		options:    options,
+		detector:   blockchain.NewReorgDetector(options.ReorgDepth),
+		addresses:  make(map[string]bool),
+	}
+
+	if setting != nil {
+		s.Watch(setting.WhitelistedAddresses...)
+	}
+
+	return s
+}
+
+// Watch add addresses to the watched address set
+func (s *Scanner) Watch(addresses ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, address := range addresses {
+		s.addresses[addressKey(address)] = true
+	}
+}
+
+// Unwatch remove addresses from the watched address set
+func (s *Scanner) Unwatch(addresses ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, address := range addresses {
+		delete(s.addresses, addressKey(address))
+	}
+}
+
+// Run scan blocks until ctx is done, it returns nil on graceful shutdown
+func (s *Scanner) Run(ctx context.Context) error {
+	checkpoint, ok, err := s.options.Checkpoint.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	next := s.options.StartHeight
+	if ok {
+		next = checkpoint.Height + 1
+
+		for number, hash := range checkpoint.Blocks {
+			s.detector.Push(number, hash)
+		}
+	}
+
+	for {
+		caughtUp, n, err := s.scan(ctx, next)
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err != nil && !isTransient(err) {
+			return err
+		}
+
+		next = n
+
+		if caughtUp || err != nil {
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(s.options.PollInterval):
+			}
+		}
+	}
+}
+
+// scan process a batch of blocks starting at next and return the next height to scan
+func (s *Scanner) scan(ctx context.Context, next int64) (caughtUp bool, _ int64, _ error) {
+	latest, err := s.blockchain.GetLatestBlockNumber(ctx)
+	if err != nil {
+		return false, next, err
+	}
+
+	target := latest
+	if s.options.Confirmations > 1 {
+		target = latest - s.options.Confirmations + 1
+	}
+
+	if next > target {
+		return true, next, nil
+	}
+
+	last := next + int64(s.options.BatchSize) - 1
+	if last > target {
+		last = target
+	}
+
+	blocks, err := s.fetch(ctx, next, last)
+	if err != nil {
+		return false, next, err
+	}
+
+	for _, blk := range blocks {
+		if !s.detector.Connects(blk) {
+			next, err := s.rollback(ctx, next)
+			return false, next, err
+		}
+
+		if err := s.process(ctx, blk); err != nil {
+			return false, next, err
+		}
+
+		next = blk.Number + 1
+	}
+
+	return next > target, next, nil
+}
+
+func (s *Scanner) rollback(ctx context.Context, next int64) (int64, error) {
+	orphaned, err := s.detector.Detect(ctx, s.blockchain)
+	if len(orphaned) == 0 {
+		return next, err
+	}
+
+	if err := s.handler.HandleRollback(ctx, orphaned); err != nil {
+		return next, err
+	}
+
+	if err := s.save(ctx, orphaned[0]-1); err != nil {
+		return next, err
+	}
+
+	return orphaned[0], err
+}
+
+func (s *Scanner) process(ctx context.Context, blk *block.Block) error {
+	transactions := s.filter(blk.Transactions)
+	if len(transactions) > 0 {
+		if err := s.handler.HandleTransactions(ctx, blk, transactions); err != nil {
+			return err
+		}
+	}
+
+	s.detector.Push(blk.Number, blk.Hash)
+
+	return s.save(ctx, blk.Number)
+}
+
+// save checkpoint height with the blocks tracked by the detector
+func (s *Scanner) save(ctx context.Context, height int64) error {
+	return s.options.Checkpoint.Save(ctx, &Checkpoint{Height: height, Blocks: s.detector.Blocks()})
+}
+
+func (s *Scanner) filter(transactions []*transaction.Transaction) []*transaction.Transaction {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(s.addresses) == 0 {
+		return transactions
+	}
+
+	filtered := make([]*transaction.Transaction, 0)
+	for _, t := range transactions {
+		if s.addresses[addressKey(t.ToAddress)] || s.addresses[addressKey(t.FromAddress)] {
+			filtered = append(filtered, t)
+		}
+	}
+
+	return filtered
+}
+
+// addressKey return the key of address in the watched address set, hex addresses are case insensitive
+// but the case of other encodings like base58 is part of the address
+func addressKey(address string) string {
+	if strings.HasPrefix(address, "0x") || strings.HasPrefix(address, "0X") {
+		return strings.ToLower(address)
+	}
+
+	return address
+}
+
+// fetch get blocks from first to last with bounded concurrency, blocks are returned in order
+func (s *Scanner) fetch(ctx context.Context, first, last int64) ([]*block.Block, error) {
+	blocks := make([]*block.Block, last-first+1)
+	errs := make([]error, len(blocks))
+
+	sem := make(chan struct{}, s.options.Concurrency)
+	var wg sync.WaitGroup
+	for i := range blocks {
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(i int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			blocks[i], errs[i] = s.blockchain.GetBlockByNumber(ctx, first+int64(i))
+		}(i)
+	}
+
+	wg.Wait()
+
+	// keep the blocks fetched before the first failure so progress is not lost
+	for i, err := range errs {
+		if err != nil {
+			if i == 0 {
+				return nil, err
+			}
+
+			return blocks[:i], nil
+		}
+	}
+
+	return blocks, nil
+}
+
+func isTransient(err error) bool {
+	return errors.Is(err, errors.ErrNodeUnavailable) ||
+		errors.Is(err, errors.ErrRateLimited) ||
+		errors.Is(err, errors.ErrBlockNotFound)
+}
@@ -0,0 +1,233 @@
+package scanner
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+	"github.com/volatiletech/null/v9"
+
+	"github.com/zsmartex/multichain/pkg/block"
+	"github.com/zsmartex/multichain/pkg/blockchain"
+	"github.com/zsmartex/multichain/pkg/errors"
+	"github.com/zsmartex/multichain/pkg/transaction"
+)
+
+type fakeBlockchain struct {
+	blockchain.Blockchain
+
+	mu     sync.Mutex
+	blocks []*block.Block
+}
+
+func (f *fakeBlockchain) push(fork string, toAddress string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	number := int64(len(f.blocks))
+	parentHash := ""
+	if number > 0 {
+		parentHash = f.blocks[number-1].Hash
+	}
+
+	hash := fmt.Sprintf("%s%d", fork, number)
+	f.blocks = append(f.blocks, &block.Block{
+		Hash:       hash,
+		ParentHash: parentHash,
+		Number:     number,
+		Transactions: []*transaction.Transaction{
+			{TxHash: null.StringFrom(hash), ToAddress: toAddress, Amount: decimal.NewFromInt(1)},
+		},
+	})
+}
+
+func (f *fakeBlockchain) truncate(height int64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.blocks = f.blocks[:height]
+}
+
+func (f *fakeBlockchain) GetLatestBlockNumber(context.Context) (int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return int64(len(f.blocks)) - 1, nil
+}
+
+func (f *fakeBlockchain) GetBlockByNumber(_ context.Context, blockNumber int64) (*block.Block, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if blockNumber >= int64(len(f.blocks)) {
+		return nil, errors.ErrBlockNotFound
+	}
+
+	return f.blocks[blockNumber], nil
+}
+
+type recordHandler struct {
+	mu        sync.Mutex
+	txs       []string
+	rollbacks [][]int64
+	notify    chan string
+}
+
+func (h *recordHandler) HandleTransactions(_ context.Context, _ *block.Block, transactions []*transaction.Transaction) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for _, t := range transactions {
+		h.txs = append(h.txs, t.TxHash.String)
+		h.notify <- t.TxHash.String
+	}
+
+	return nil
+}
+
+func (h *recordHandler) HandleRollback(_ context.Context, heights []int64) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.rollbacks = append(h.rollbacks, heights)
+
+	return nil
+}
+
+func waitFor(t *testing.T, h *recordHandler, hash string) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case got := <-h.notify:
+			if got == hash {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting for %s", hash)
+		}
+	}
+}
+
+func TestScanner_Run(t *testing.T) {
+	bl := &fakeBlockchain{}
+	for i := 0; i < 6; i++ {
+		address := "0xother"
+		if i%2 == 0 {
+			address = "0xWATCHED"
+		}
+		bl.push("a", address)
+	}
+
+	handler := &recordHandler{notify: make(chan string, 100)}
+	checkpoint := NewMemoryCheckpointStore()
+	s := New(bl, &blockchain.Setting{WhitelistedAddresses: []string{"0xwatched"}}, handler, Options{
+		StartHeight:   1,
+		Confirmations: 2,
+		Concurrency:   2,
+		BatchSize:     2,
+		PollInterval:  10 * time.Millisecond,
+		Checkpoint:    checkpoint,
+	})
+	s.Watch("0xother")
+	s.Unwatch("0xother")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+
+	// block 5 has only one confirmation
+	waitFor(t, handler, "a4")
+
+	// replace blocks 3 and 4 with another fork
+	bl.truncate(3)
+	bl.push("b", "0xwatched")
+	bl.push("b", "0xwatched")
+	bl.push("b", "0xwatched")
+	bl.push("b", "0xwatched")
+	waitFor(t, handler, "b5")
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+
+	if !reflect.DeepEqual(handler.txs, []string{"a2", "a4", "b3", "b4", "b5"}) {
+		t.Errorf("unexpected transactions %v", handler.txs)
+	}
+
+	if !reflect.DeepEqual(handler.rollbacks, [][]int64{{3, 4}}) {
+		t.Errorf("unexpected rollbacks %v", handler.rollbacks)
+	}
+
+	if saved, _, _ := checkpoint.Load(context.Background()); saved.Height != 5 || saved.Blocks[5] != "b5" || saved.Blocks[3] != "b3" {
+		t.Errorf("unexpected checkpoint %+v", saved)
+	}
+}
+
+func TestScanner_RunReorgWhileStopped(t *testing.T) {
+	bl := &fakeBlockchain{}
+	for i := 0; i < 4; i++ {
+		bl.push("a", "0xwatched")
+	}
+
+	// the scanner stopped after block 3 of the a fork which was then replaced
+	checkpoint := NewMemoryCheckpointStore()
+	checkpoint.Save(context.Background(), &Checkpoint{Height: 3, Blocks: map[int64]string{1: "a1", 2: "a2", 3: "a3"}})
+
+	bl.truncate(3)
+	bl.push("b", "0xwatched")
+	bl.push("b", "0xwatched")
+
+	handler := &recordHandler{notify: make(chan string, 100)}
+	s := New(bl, nil, handler, Options{PollInterval: 10 * time.Millisecond, Checkpoint: checkpoint})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- s.Run(ctx)
+	}()
+
+	waitFor(t, handler, "b4")
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	handler.mu.Lock()
+	defer handler.mu.Unlock()
+
+	if !reflect.DeepEqual(handler.txs, []string{"b3", "b4"}) {
+		t.Errorf("unexpected transactions %v", handler.txs)
+	}
+
+	if !reflect.DeepEqual(handler.rollbacks, [][]int64{{3}}) {
+		t.Errorf("unexpected rollbacks %v", handler.rollbacks)
+	}
+}
+
+func TestScanner_FilterAddressCase(t *testing.T) {
+	s := New(&fakeBlockchain{}, &blockchain.Setting{}, &recordHandler{}, Options{})
+	s.Watch("0xWATCHED", "TJRabPrwbZy45sbavfcjinPJC18kjpRTv8")
+
+	transactions := []*transaction.Transaction{
+		{TxHash: null.StringFrom("hex"), ToAddress: "0xwatched"},
+		{TxHash: null.StringFrom("base58"), FromAddress: "TJRabPrwbZy45sbavfcjinPJC18kjpRTv8"},
+		// another base58 address only differing by its case
+		{TxHash: null.StringFrom("other"), ToAddress: "tjrabprwbzy45sbavfcjinpjc18kjprtv8"},
+	}
+
+	filtered := s.filter(transactions)
+	if len(filtered) != 2 || filtered[0].TxHash.String != "hex" || filtered[1].TxHash.String != "base58" {
+		t.Errorf("unexpected filtered transactions %v", filtered)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"reflect"
+	"strings"
 
 	"github.com/shopspring/decimal"
 	"github.com/volatiletech/null/v9"
@@ -22,16 +23,59 @@ const (
 )
 
 type Transaction struct {
-	Currency    string                 `json:"currency,omitempty"`
-	CurrencyFee string                 `json:"currency_fee,omitempty"`
-	FromAddress string                 `json:"from_address,omitempty"`
-	ToAddress   string                 `json:"to_address,omitempty"`
-	Fee         decimal.NullDecimal    `json:"fee,omitempty"`
-	Amount      decimal.Decimal        `json:"amount,omitempty"`
-	BlockNumber int64                  `json:"block_number,omitempty"`
-	TxHash      null.String            `json:"tx_hash,omitempty"`
-	Status      Status                 `json:"status,omitempty"`
-	Options     map[string]interface{} `json:"options,omitempty"`
+	Currency         string                 `json:"currency,omitempty"`
+	CurrencyFee      string                 `json:"currency_fee,omitempty"`
+	FromAddress      string                 `json:"from_address,omitempty"`
+	ToAddress        string                 `json:"to_address,omitempty"`
+	Fee              decimal.NullDecimal    `json:"fee,omitempty"`
+	Amount           decimal.Decimal        `json:"amount,omitempty"`
+	BlockNumber      int64                  `json:"block_number,omitempty"`
+	Confirmations    int64                  `json:"confirmations,omitempty"`
+	TxHash           null.String            `json:"tx_hash,omitempty"`
+	ReplacedTxHash   null.String            `json:"replaced_tx_hash,omitempty"`    // hash of the transaction this one replace
+	ReplacedByTxHash null.String            `json:"replaced_by_tx_hash,omitempty"` // hash of the transaction which replaced this one
+	ParentTxHash     null.String            `json:"parent_tx_hash,omitempty"`      // hash of the unconfirmed transaction whose fee this one help to pay
+	TraceAddress     string                 `json:"trace_address,omitempty"`       // path of an internal call inside the transaction, empty for the transaction itself
+	Position         null.Int64             `json:"position,omitempty"`            // index of the transfer inside the transaction, log index on EVM and Tron and output index on Bitcoin
+	TokenID          string                 `json:"token_id,omitempty"`            // id of the ERC721 or ERC1155 token transferred
+	Inputs           []string               `json:"inputs,omitempty"`              // outpoints spent by the transaction as txid:vout on UTXO chains
+	Status           Status                 `json:"status,omitempty"`
+	Options          map[string]interface{} `json:"options,omitempty"`
+}
+
+// SetConfirmations set the confirmations and keep a succeed transaction pending
+// until it reach the required confirmations, a transaction always need at least one confirmation
+func (t *Transaction) SetConfirmations(confirmations int64, required int64) {
+	t.Confirmations = confirmations
+
+	if required < 1 {
+		required = 1
+	}
+
+	if t.Status == StatusSucceed && confirmations < required {
+		t.Status = StatusPending
+	}
+}
+
+// UniqueID return a canonical identifier of the transfer, a transaction can hold several transfers
+// so the hash is suffixed by the position, the token id or the trace address of the transfer when they are set
+func (t *Transaction) UniqueID() string {
+	id := strings.ToLower(t.TxHash.String)
+
+	if t.Position.Valid {
+		id = fmt.Sprintf("%s:%d", id, t.Position.Int64)
+	}
+
+	// an ERC1155 batch transfer several tokens in a single log
+	if len(t.TokenID) > 0 {
+		id = fmt.Sprintf("%s:token:%s", id, t.TokenID)
+	}
+
+	if len(t.TraceAddress) > 0 {
+		id = fmt.Sprintf("%s:call:%s", id, t.TraceAddress)
+	}
+
+	return id
 }
 
 func (t *Transaction) IsPending() bool {
@@ -0,0 +1,26 @@
+package transaction
+
+import (
+	"testing"
+
+	"github.com/volatiletech/null/v9"
+)
+
+func TestTransaction_UniqueID(t *testing.T) {
+	tests := []struct {
+		tx       Transaction
+		expected string
+	}{
+		{tx: Transaction{TxHash: null.StringFrom("0xABC")}, expected: "0xabc"},
+		{tx: Transaction{TxHash: null.StringFrom("0xabc"), Position: null.Int64From(0)}, expected: "0xabc:0"},
+		{tx: Transaction{TxHash: null.StringFrom("0xabc"), Position: null.Int64From(3)}, expected: "0xabc:3"},
+		{tx: Transaction{TxHash: null.StringFrom("0xabc"), Position: null.Int64From(3), TokenID: "42"}, expected: "0xabc:3:token:42"},
+		{tx: Transaction{TxHash: null.StringFrom("0xabc"), TraceAddress: "0.1"}, expected: "0xabc:call:0.1"},
+	}
+
+	for _, test := range tests {
+		if id := test.tx.UniqueID(); id != test.expected {
+			t.Errorf("expected unique id %s, got %s", test.expected, id)
+		}
+	}
+}
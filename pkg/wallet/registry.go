@@ -0,0 +1,73 @@
+package wallet
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Factory create a new unconfigured Wallet
+type Factory func() Wallet
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// UnknownChainError returned by New when no Wallet registered under the kind
+type UnknownChainError struct {
+	Kind string
+}
+
+func (e *UnknownChainError) Error() string {
+	return fmt.Sprintf("wallet: unknown chain %q", e.Kind)
+}
+
+// Register make a Wallet implementation available under the kind (e.g. "evm", "bitcoin", "tron")
+// it panics if the kind is registered twice or factory is nil
+func Register(kind string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+
+	if factory == nil {
+		panic("wallet: Register factory is nil")
+	}
+
+	if _, dup := factories[kind]; dup {
+		panic("wallet: Register called twice for kind " + kind)
+	}
+
+	factories[kind] = factory
+}
+
+// Kinds return a sorted list of the registered kinds
+func Kinds() []string {
+	factoriesMu.RLock()
+	defer factoriesMu.RUnlock()
+
+	kinds := make([]string, 0, len(factories))
+	for kind := range factories {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+
+	return kinds
+}
+
+// New create a Wallet registered under the kind and configure it with setting
+func New(kind string, setting *Setting) (Wallet, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[kind]
+	factoriesMu.RUnlock()
+
+	if !ok {
+		return nil, &UnknownChainError{Kind: kind}
+	}
+
+	w := factory()
+	if err := w.Configure(setting); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
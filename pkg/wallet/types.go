@@ -6,6 +6,7 @@ import (
 	"github.com/shopspring/decimal"
 
 	"github.com/zsmartex/multichain/pkg/currency"
+	"github.com/zsmartex/multichain/pkg/errors"
 	"github.com/zsmartex/multichain/pkg/transaction"
 )
 
@@ -17,7 +18,7 @@ const (
 )
 
 type SettingWallet struct {
-	URI     string
+	URI     string // json-rpc adapters accept a comma separated list of endpoints for failover
 	Secret  string
 	Address string
 }
@@ -27,8 +28,28 @@ type Setting struct {
 	Currency *currency.Currency
 }
 
+// Validate check the given parts of the setting, Wallet and Currency can be configured separately
+func (s *Setting) Validate() error {
+	if s == nil {
+		return errors.NewConfigError("setting", "is nil")
+	}
+
+	if s.Wallet != nil && len(s.Wallet.URI) == 0 {
+		return errors.NewConfigError("wallet.uri", "is empty")
+	}
+
+	if s.Currency != nil {
+		if err := s.Currency.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 type Wallet interface {
-	Configure(settings *Setting)
+	// Configure Validate settings and configure the wallet with it
+	Configure(settings *Setting) error
 
 	// CreateAddress Create new address from server
 	CreateAddress(ctx context.Context) (address string, secret string, err error)